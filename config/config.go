@@ -0,0 +1,324 @@
+// Package config holds the configuration of omada-to-gotify. It is read
+// from an optional YAML file, after which environment variables override
+// whatever the file says; without a file the environment variables are all
+// there is, which is how this program has always been configured.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/omada"
+	"github.com/leeft/omada-to-gotify/webhook"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete configuration. In the YAML file each setting has
+// the name of its yaml tag, nested as the structs are:
+//
+//	port: 8080
+//	gotify:
+//	  url: http://gotify:80/
+//	  token: AbCdEf123
+//	  routes:
+//	    - match:
+//	        type: ^client-roaming$
+//	      drop: true
+//	omada:
+//	  shared_secret: vewySecwet
+//	flap:
+//	  window: 15m
+//
+// Rules, routes and endpoints can be given in the file itself, or be read
+// from files of their own just like with the environment variables.
+type Config struct {
+	Port string `yaml:"port"`
+
+	// With a certificate and key, webhooks are received over HTTPS.
+	TLS TLSConfig `yaml:"tls"`
+
+	// How long to wait for requests and deliveries to finish when stopping.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	Gotify        GotifyConfig              `yaml:"gotify"`
+	Omada         OmadaConfig               `yaml:"omada"`
+	Endpoints     []*webhook.EndpointConfig `yaml:"endpoints"`
+	EndpointsFile string                    `yaml:"endpoints_file"`
+	Queue         QueueConfig               `yaml:"queue"`
+	Flap          FlapConfig                `yaml:"flap"`
+	Metrics       MetricsConfig             `yaml:"metrics"`
+	Log           LogConfig                 `yaml:"log"`
+	History       HistoryConfig             `yaml:"history"`
+	API           APIConfig                 `yaml:"api"`
+	Dashboard     DashboardConfig           `yaml:"dashboard"`
+
+	// The largest request body accepted, in bytes.
+	MaxBodySize int64 `yaml:"max_body_size"`
+
+	// The proxies whose X-Forwarded-For header is believed.
+	TrustedProxies []string        `yaml:"trusted_proxies"`
+	RateLimit      RateLimitConfig `yaml:"rate_limit"`
+
+	Ntfy    NtfyConfig    `yaml:"ntfy"`
+	Webhook WebhookConfig `yaml:"webhook"`
+	Matrix  MatrixConfig  `yaml:"matrix"`
+	SMTP    SMTPConfig    `yaml:"smtp"`
+
+	// The file the configuration was read from, empty when there is none.
+	File string `yaml:"-"`
+}
+
+type GotifyConfig struct {
+	URL          string          `yaml:"url"`
+	Token        string          `yaml:"token"`
+	Format       string          `yaml:"format"`
+	Routes       []*gotify.Route `yaml:"routes"`
+	DefaultRoute *gotify.Route   `yaml:"default_route"`
+	RoutesFile   string          `yaml:"routes_file"`
+}
+
+// How the shared secret is checked is set with auth, hmac_secret and
+// hmac_header (see webhook.AuthConfig). Allow lists the networks requests
+// are accepted from, anywhere when empty. With Strict, fields of messages
+// this program doesn't know about are logged.
+type OmadaConfig struct {
+	SharedSecret string        `yaml:"shared_secret"`
+	Allow        []string      `yaml:"allow"`
+	Strict       bool          `yaml:"strict"`
+	Rules        []*omada.Rule `yaml:"rules"`
+	RulesFile    string        `yaml:"rules_file"`
+
+	webhook.AuthConfig `yaml:",inline"`
+}
+
+type QueueConfig struct {
+	Dir    string        `yaml:"dir"`
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Flap detection is off when Threshold is 0.
+type FlapConfig struct {
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	Settle    time.Duration `yaml:"settle"`
+}
+
+// With ClientCAFile, clients must present a certificate signed by one of
+// the CAs in it.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// The metrics are only served when Port is set; on a listener of their own,
+// so they need not be reachable from wherever the controllers are.
+type MetricsConfig struct {
+	Port  string `yaml:"port"`
+	Token string `yaml:"token"`
+}
+
+// The history is kept in memory, and in File when set. It is off when
+// MaxEntries is 0.
+type HistoryConfig struct {
+	File       string        `yaml:"file"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxEntries int           `yaml:"max_entries"`
+}
+
+// The API is only served when Token is set, and only to those using it.
+type APIConfig struct {
+	Token string `yaml:"token"`
+}
+
+// The dashboard is only served when Password is set, and only to those
+// logging in as User with it.
+type DashboardConfig struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// Level is debug, info, warn or error; Format is json or text. The level
+// can be changed by reloading, the format only by restarting.
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Requests a minute for each source IP, and messages a minute for each
+// controller, with bursts of up to Burst; no limit when 0.
+type RateLimitConfig struct {
+	PerIP         float64 `yaml:"per_ip"`
+	PerController float64 `yaml:"per_controller"`
+	Burst         int     `yaml:"burst"`
+}
+
+type NtfyConfig struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+type MatrixConfig struct {
+	Homeserver  string `yaml:"homeserver"`
+	AccessToken string `yaml:"access_token"`
+	RoomID      string `yaml:"room_id"`
+}
+
+type SMTPConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// The configuration before the file and environment variables are applied.
+func Default() *Config {
+	return &Config{
+		Port:            "8080",
+		ShutdownTimeout: 10 * time.Second,
+		MaxBodySize:     webhook.DefaultMaxBodySize,
+		Log: LogConfig{
+			Level:  "info",
+			Format: logging.FormatJSON,
+		},
+		RateLimit: RateLimitConfig{
+			Burst: 10,
+		},
+		History: HistoryConfig{
+			MaxAge:     history.DefaultMaxAge,
+			MaxEntries: history.DefaultMaxEntries,
+		},
+		Flap: FlapConfig{
+			Threshold: 4,
+			Window:    10 * time.Minute,
+			Settle:    5 * time.Minute,
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+		},
+	}
+}
+
+// Load reads the configuration file at path (when not empty), applies the
+// environment variables, and validates the result.
+func Load(path string) (*Config, error) {
+	c := Default()
+
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.applyEnvironment(); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Unknown fields are rejected to catch typos.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read configuration file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("configuration file %v: %w", path, err)
+	}
+
+	c.File = path
+	return nil
+}
+
+// The rules to classify messages with, from the configuration or the rules
+// file; nil when there are none.
+func (c *Config) Rules() (*omada.RuleSet, error) {
+	if c.Omada.RulesFile != "" {
+		return omada.LoadRules(c.Omada.RulesFile)
+	}
+
+	if len(c.Omada.Rules) == 0 {
+		return nil, nil
+	}
+
+	rs := &omada.RuleSet{Rules: c.Omada.Rules}
+	return rs, rs.Validate()
+}
+
+// The routes for Gotify, from the configuration or the routes file; nil when
+// there are none.
+func (c *Config) Routes() (*gotify.RouteSet, error) {
+	if c.Gotify.RoutesFile != "" {
+		return gotify.LoadRoutes(c.Gotify.RoutesFile)
+	}
+
+	if len(c.Gotify.Routes) == 0 && c.Gotify.DefaultRoute == nil {
+		return nil, nil
+	}
+
+	rs := &gotify.RouteSet{Routes: c.Gotify.Routes, Default: c.Gotify.DefaultRoute}
+	return rs, rs.Validate()
+}
+
+// How requests to the default endpoint are authenticated.
+func (c *Config) Authenticators() ([]webhook.Authenticator, error) {
+	return c.Omada.AuthConfig.Authenticators(c.Omada.SharedSecret)
+}
+
+// The networks requests to the default endpoint are accepted from.
+func (c *Config) AllowedNetworks() ([]netip.Prefix, error) {
+	return webhook.ParseNetworks(c.Omada.Allow)
+}
+
+func (c *Config) ProxyNetworks() ([]netip.Prefix, error) {
+	return webhook.ParseNetworks(c.TrustedProxies)
+}
+
+// The limits for each source IP and for each controller.
+func (c *Config) RateLimits() (perIP, perController webhook.RateLimit) {
+	return webhook.RateLimit{PerMinute: c.RateLimit.PerIP, Burst: c.RateLimit.Burst},
+		webhook.RateLimit{PerMinute: c.RateLimit.PerController, Burst: c.RateLimit.Burst}
+}
+
+// The level to log at; info unless set otherwise.
+func (c *Config) LogLevel() slog.Level {
+	level, _ := logging.ParseLevel(c.Log.Level)
+	return level
+}
+
+// The further webhook endpoints, from the configuration or the endpoints
+// file; the client is used for their defaults (see webhook.LoadEndpoints).
+func (c *Config) WebhookEndpoints(gc gotify.GotifyClient) ([]*webhook.Endpoint, error) {
+	if c.EndpointsFile != "" {
+		return webhook.LoadEndpoints(c.EndpointsFile, gc)
+	}
+
+	return webhook.BuildEndpoints(c.Endpoints, gc)
+}
+
+// EOF
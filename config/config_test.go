@@ -0,0 +1,274 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/config"
+)
+
+// Makes sure the environment of whoever runs the tests doesn't get in the way.
+func clearEnvironment(t *testing.T) {
+	for _, name := range []string{
+		"PORT", "SHUTDOWN_TIMEOUT", "LOG_LEVEL", "LOG_FORMAT", "TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_CLIENT_CA_FILE", "GOTIFY_URL", "GOTIFY_APP_TOKEN", "GOTIFY_APP_TOKEN_FILE", "GOTIFY_FORMAT", "GOTIFY_ROUTES_FILE",
+		"OMADA_SHARED_SECRET", "OMADA_SHARED_SECRET_FILE", "OMADA_AUTH", "OMADA_HMAC_SECRET", "OMADA_HMAC_HEADER", "OMADA_ALLOW", "OMADA_STRICT", "MAX_BODY_SIZE", "OMADA_RULES_FILE",
+		"TRUSTED_PROXIES", "RATE_LIMIT_PER_IP", "RATE_LIMIT_PER_CONTROLLER", "RATE_LIMIT_BURST", "ENDPOINTS_FILE",
+		"QUEUE_DIR", "QUEUE_MAX_AGE", "FLAP_THRESHOLD", "FLAP_WINDOW", "FLAP_SETTLE", "METRICS_PORT", "METRICS_TOKEN",
+		"HISTORY_FILE", "HISTORY_MAX_AGE", "HISTORY_MAX_ENTRIES", "API_TOKEN",
+		"DASHBOARD_USER", "DASHBOARD_PASSWORD",
+		"NTFY_URL", "NTFY_TOKEN", "WEBHOOK_URL", "MATRIX_HOMESERVER", "MATRIX_ACCESS_TOKEN", "MATRIX_ROOM_ID",
+		"SMTP_HOST", "SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD", "SMTP_FROM", "SMTP_TO",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Could not write %v: %v", name, err)
+	}
+	return path
+}
+
+func TestLoad_Environment(t *testing.T) {
+	clearEnvironment(t)
+	t.Setenv("GOTIFY_URL", "http://gotify:80/")
+	t.Setenv("GOTIFY_APP_TOKEN", "app-token")
+	t.Setenv("OMADA_SHARED_SECRET", "vewySecwet")
+	t.Setenv("FLAP_WINDOW", "15m")
+	t.Setenv("SMTP_HOST", "mail.example.com")
+	t.Setenv("SMTP_FROM", "omada@example.com")
+	t.Setenv("SMTP_TO", "ops@example.com, oncall@example.com")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1, 172.16.0.0/12")
+	t.Setenv("RATE_LIMIT_PER_IP", "60")
+	t.Setenv("OMADA_STRICT", "true")
+	t.Setenv("HISTORY_MAX_AGE", "168h")
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if cfg.Gotify.URL != "http://gotify:80/" || cfg.Gotify.Token != "app-token" || cfg.Omada.SharedSecret != "vewySecwet" {
+		t.Errorf("The environment variables were not applied: %+v", cfg)
+	}
+
+	if !cfg.Omada.Strict {
+		t.Errorf("Expected strict mode to be on")
+	}
+
+	if cfg.Port != "8080" || cfg.MaxBodySize != 64*1024 || cfg.Flap.Threshold != 4 || cfg.Flap.Settle != 5*time.Minute || cfg.SMTP.Port != 587 {
+		t.Errorf("The defaults were not applied: %+v", cfg)
+	}
+
+	if cfg.History.MaxAge != 7*24*time.Hour || cfg.History.MaxEntries != 10000 {
+		t.Errorf("Expected a week of history, up to 10000 entries, got %+v", cfg.History)
+	}
+
+	if cfg.Flap.Window != 15*time.Minute {
+		t.Errorf("Expected a flap window of 15m, got %v", cfg.Flap.Window)
+	}
+
+	if len(cfg.SMTP.To) != 2 || cfg.SMTP.To[1] != "oncall@example.com" {
+		t.Errorf("Expected two addresses, got %q", cfg.SMTP.To)
+	}
+
+	if proxies, err := cfg.ProxyNetworks(); err != nil || len(proxies) != 2 || proxies[0].String() != "10.0.0.1/32" {
+		t.Errorf("Expected two trusted proxies, got %v (%v)", proxies, err)
+	}
+
+	if perIP, perController := cfg.RateLimits(); perIP.PerMinute != 60 || perIP.Burst != 10 || perController.PerMinute != 0 {
+		t.Errorf("Expected only a limit per IP, got %+v and %+v", perIP, perController)
+	}
+}
+
+func TestLoad_File(t *testing.T) {
+	clearEnvironment(t)
+
+	path := writeFile(t, "config.yaml", `
+port: "9090"
+gotify:
+  url: http://gotify:80/
+  token: from-the-file
+  routes:
+    - name: Roaming
+      match:
+        type: ^client-roaming$
+      drop: true
+omada:
+  shared_secret: vewySecwet
+  auth: both
+  rules:
+    - match:
+        site: ^Lab$
+      priority: 1
+flap:
+  threshold: 0
+queue:
+  dir: /var/lib/omada-to-gotify
+  max_age: 24h
+`)
+
+	// The environment overrides the file, here with a Docker secret.
+	t.Setenv("GOTIFY_APP_TOKEN_FILE", writeFile(t, "token", "from-a-secret\n"))
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if cfg.File != path || cfg.Port != "9090" || cfg.Queue.MaxAge != 24*time.Hour || cfg.Flap.Threshold != 0 {
+		t.Errorf("The file was not applied: %+v", cfg)
+	}
+
+	if auth, err := cfg.Authenticators(); err != nil || len(auth) != 2 {
+		t.Errorf("Expected a header and a payload check, got %v (%v)", auth, err)
+	}
+
+	if cfg.Gotify.Token != "from-a-secret" {
+		t.Errorf("Expected the token from the secret, got %q", cfg.Gotify.Token)
+	}
+
+	if routes, err := cfg.Routes(); err != nil || routes == nil || len(routes.Routes) != 1 {
+		t.Errorf("Expected one route, got %v (%v)", routes, err)
+	}
+
+	if rules, err := cfg.Rules(); err != nil || rules == nil || len(rules.Rules) != 1 {
+		t.Errorf("Expected one rule, got %v (%v)", rules, err)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "Required environment variable",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/"},
+			want: []string{"GOTIFY_APP_TOKEN environment variable is required"},
+		},
+		{
+			name: "Required setting in the file",
+			file: "gotify:\n  url: http://gotify:80/\n",
+			want: []string{"gotify.token (GOTIFY_APP_TOKEN) is required"},
+		},
+		{
+			name: "Unknown setting",
+			file: "gotify:\n  url: http://gotify:80/\n  tokn: abc\n",
+			env:  map[string]string{"GOTIFY_APP_TOKEN": "app-token"},
+			want: []string{"field tokn not found"},
+		},
+		{
+			name: "Environment variable and its file",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token", "OMADA_SHARED_SECRET_FILE": "/run/secrets/omada"},
+			want: []string{"only one of OMADA_SHARED_SECRET and OMADA_SHARED_SECRET_FILE can be set"},
+		},
+		{
+			name: "Every problem is reported",
+			file: `
+gotify:
+  format: html
+  routes:
+    - token: abc
+omada:
+  rules_file: rules.yaml
+  rules:
+    - match:
+        site: Lab
+flap:
+  threshold: 3
+  window: 0s
+matrix:
+  homeserver: https://matrix.example.com
+`,
+			env: map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token"},
+			want: []string{
+				`gotify.format (GOTIFY_FORMAT) must be either "markdown" or "plain"`,
+				`gotify.routes: route 1: no match conditions given`,
+				`omada.rules and omada.rules_file (OMADA_RULES_FILE) can't both be used`,
+				`flap.window (FLAP_WINDOW) and flap.settle (FLAP_SETTLE) must be more than 0`,
+				`matrix.access_token (MATRIX_ACCESS_TOKEN) and matrix.room_id (MATRIX_ROOM_ID) are required with matrix.homeserver (MATRIX_HOMESERVER)`,
+			},
+		},
+		{
+			name: "Metrics on the webhook port",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token", "METRICS_PORT": "8080"},
+			want: []string{"METRICS_PORT must differ from PORT"},
+		},
+		{
+			name: "Invalid environment variable",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token", "FLAP_THRESHOLD": "many"},
+			want: []string{`FLAP_THRESHOLD must be a whole number of 0 or more, not "many"`},
+		},
+		{
+			name: "Certificate without a key",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token", "TLS_CERT_FILE": "/etc/tls/cert.pem"},
+			want: []string{"TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		},
+		{
+			name: "Logging",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token", "LOG_LEVEL": "verbose", "LOG_FORMAT": "xml"},
+			want: []string{
+				`LOG_LEVEL: unknown log level "verbose", use debug, info, warn or error`,
+				`LOG_FORMAT must be either "json" or "text"`,
+			},
+		},
+		{
+			name: "History",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token", "HISTORY_MAX_ENTRIES": "-1"},
+			want: []string{`HISTORY_MAX_ENTRIES must be a whole number of 0 or more, not "-1"`},
+		},
+		{
+			name: "Dashboard without a password",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token", "DASHBOARD_USER": "admin"},
+			want: []string{`DASHBOARD_USER and DASHBOARD_PASSWORD must be set together`},
+		},
+		{
+			name: "Invalid network",
+			file: "omada:\n  allow: [192.168.1.0/33]\n",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token"},
+			want: []string{`omada.allow (OMADA_ALLOW): "192.168.1.0/33" is not a network`},
+		},
+		{
+			name: "Unknown way of authenticating",
+			env:  map[string]string{"GOTIFY_URL": "http://gotify:80/", "GOTIFY_APP_TOKEN": "app-token", "OMADA_AUTH": "basic"},
+			want: []string{`OMADA_AUTH: auth "basic" must be one of "header", "payload" or "both"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvironment(t)
+			t.Setenv("OMADA_SHARED_SECRET", "vewySecwet")
+
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			path := ""
+			if tt.file != "" {
+				path = writeFile(t, "config.yaml", tt.file)
+			}
+
+			_, err := config.Load(path)
+			if err == nil {
+				t.Fatal("Load() succeeded, expected an error")
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// EOF
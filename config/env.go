@@ -0,0 +1,191 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The environment variables, and the setting each of them overrides. Every
+// one of them can also be given as <NAME>_FILE, naming a file holding the
+// value; handy for Docker secrets.
+var environment = []struct {
+	name  string
+	apply func(c *Config, value string) error
+}{
+	{"PORT", func(c *Config, v string) error { c.Port = v; return nil }},
+	{"TLS_CERT_FILE", func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	{"TLS_KEY_FILE", func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	{"TLS_CLIENT_CA_FILE", func(c *Config, v string) error { c.TLS.ClientCAFile = v; return nil }},
+	{"SHUTDOWN_TIMEOUT", func(c *Config, v string) error { return parseDuration("SHUTDOWN_TIMEOUT", v, &c.ShutdownTimeout) }},
+	{"LOG_LEVEL", func(c *Config, v string) error { c.Log.Level = v; return nil }},
+	{"LOG_FORMAT", func(c *Config, v string) error { c.Log.Format = v; return nil }},
+	{"GOTIFY_URL", func(c *Config, v string) error { c.Gotify.URL = v; return nil }},
+	{"GOTIFY_APP_TOKEN", func(c *Config, v string) error { c.Gotify.Token = v; return nil }},
+	{"GOTIFY_FORMAT", func(c *Config, v string) error { c.Gotify.Format = v; return nil }},
+	{"GOTIFY_ROUTES_FILE", func(c *Config, v string) error { c.Gotify.RoutesFile = v; return nil }},
+	{"OMADA_SHARED_SECRET", func(c *Config, v string) error { c.Omada.SharedSecret = v; return nil }},
+	{"OMADA_AUTH", func(c *Config, v string) error { c.Omada.Mode = v; return nil }},
+	{"OMADA_HMAC_SECRET", func(c *Config, v string) error { c.Omada.HMACSecret = v; return nil }},
+	{"OMADA_HMAC_HEADER", func(c *Config, v string) error { c.Omada.HMACHeader = v; return nil }},
+	{"OMADA_ALLOW", func(c *Config, v string) error { c.Omada.Allow = list(v); return nil }},
+	{"OMADA_STRICT", func(c *Config, v string) error {
+		strict, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("OMADA_STRICT must be true or false, not %q", v)
+		}
+		c.Omada.Strict = strict
+		return nil
+	}},
+	{"MAX_BODY_SIZE", func(c *Config, v string) error {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("MAX_BODY_SIZE must be a number of bytes, not %q", v)
+		}
+		c.MaxBodySize = size
+		return nil
+	}},
+	{"TRUSTED_PROXIES", func(c *Config, v string) error { c.TrustedProxies = list(v); return nil }},
+	{"RATE_LIMIT_PER_IP", func(c *Config, v string) error { return parseRate("RATE_LIMIT_PER_IP", v, &c.RateLimit.PerIP) }},
+	{"RATE_LIMIT_PER_CONTROLLER", func(c *Config, v string) error {
+		return parseRate("RATE_LIMIT_PER_CONTROLLER", v, &c.RateLimit.PerController)
+	}},
+	{"RATE_LIMIT_BURST", func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("RATE_LIMIT_BURST must be a whole number of 1 or more, not %q", v)
+		}
+		c.RateLimit.Burst = n
+		return nil
+	}},
+	{"OMADA_RULES_FILE", func(c *Config, v string) error { c.Omada.RulesFile = v; return nil }},
+	{"ENDPOINTS_FILE", func(c *Config, v string) error { c.EndpointsFile = v; return nil }},
+	{"QUEUE_DIR", func(c *Config, v string) error { c.Queue.Dir = v; return nil }},
+	{"QUEUE_MAX_AGE", func(c *Config, v string) error { return parseDuration("QUEUE_MAX_AGE", v, &c.Queue.MaxAge) }},
+	{"FLAP_THRESHOLD", func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("FLAP_THRESHOLD must be a whole number of 0 or more, not %q", v)
+		}
+		c.Flap.Threshold = n
+		return nil
+	}},
+	{"FLAP_WINDOW", func(c *Config, v string) error { return parseDuration("FLAP_WINDOW", v, &c.Flap.Window) }},
+	{"FLAP_SETTLE", func(c *Config, v string) error { return parseDuration("FLAP_SETTLE", v, &c.Flap.Settle) }},
+	{"METRICS_PORT", func(c *Config, v string) error { c.Metrics.Port = v; return nil }},
+	{"METRICS_TOKEN", func(c *Config, v string) error { c.Metrics.Token = v; return nil }},
+	{"HISTORY_FILE", func(c *Config, v string) error { c.History.File = v; return nil }},
+	{"HISTORY_MAX_AGE", func(c *Config, v string) error { return parseDuration("HISTORY_MAX_AGE", v, &c.History.MaxAge) }},
+	{"HISTORY_MAX_ENTRIES", func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("HISTORY_MAX_ENTRIES must be a whole number of 0 or more, not %q", v)
+		}
+		c.History.MaxEntries = n
+		return nil
+	}},
+	{"API_TOKEN", func(c *Config, v string) error { c.API.Token = v; return nil }},
+	{"DASHBOARD_USER", func(c *Config, v string) error { c.Dashboard.User = v; return nil }},
+	{"DASHBOARD_PASSWORD", func(c *Config, v string) error { c.Dashboard.Password = v; return nil }},
+	{"NTFY_URL", func(c *Config, v string) error { c.Ntfy.URL = v; return nil }},
+	{"NTFY_TOKEN", func(c *Config, v string) error { c.Ntfy.Token = v; return nil }},
+	{"WEBHOOK_URL", func(c *Config, v string) error { c.Webhook.URL = v; return nil }},
+	{"MATRIX_HOMESERVER", func(c *Config, v string) error { c.Matrix.Homeserver = v; return nil }},
+	{"MATRIX_ACCESS_TOKEN", func(c *Config, v string) error { c.Matrix.AccessToken = v; return nil }},
+	{"MATRIX_ROOM_ID", func(c *Config, v string) error { c.Matrix.RoomID = v; return nil }},
+	{"SMTP_HOST", func(c *Config, v string) error { c.SMTP.Host = v; return nil }},
+	{"SMTP_PORT", func(c *Config, v string) error {
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("SMTP_PORT must be a port number, not %q", v)
+		}
+		c.SMTP.Port = port
+		return nil
+	}},
+	{"SMTP_USERNAME", func(c *Config, v string) error { c.SMTP.Username = v; return nil }},
+	{"SMTP_PASSWORD", func(c *Config, v string) error { c.SMTP.Password = v; return nil }},
+	{"SMTP_FROM", func(c *Config, v string) error { c.SMTP.From = v; return nil }},
+	{"SMTP_TO", func(c *Config, v string) error { c.SMTP.To = list(v); return nil }},
+}
+
+// Overrides the settings for which an environment variable is set; an
+// empty variable counts as not set.
+func (c *Config) applyEnvironment() error {
+	errs := []error{}
+
+	for _, env := range environment {
+		value, err := lookup(env.name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if value == "" {
+			continue
+		}
+
+		if err := env.apply(c, value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// The value of the environment variable, or the contents of the file named
+// by <name>_FILE without the trailing newline.
+func lookup(name string) (string, error) {
+	value := os.Getenv(name)
+	file := os.Getenv(name + "_FILE")
+
+	if file == "" {
+		return value, nil
+	}
+
+	if value != "" {
+		return "", fmt.Errorf("only one of %v and %v_FILE can be set", name, name)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("could not read %v_FILE: %w", name, err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// The items of a comma separated list, leaving out empty ones.
+func list(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseRate(name, value string, rate *float64) error {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("%v must be a number of requests a minute, not %q", name, value)
+	}
+
+	*rate = parsed
+	return nil
+}
+
+func parseDuration(name, value string, d *time.Duration) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		return fmt.Errorf("%v must be a duration such as \"10m\", not %q", name, value)
+	}
+
+	*d = parsed
+	return nil
+}
+
+// EOF
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/logging"
+)
+
+// Validate checks the configuration, reporting all problems found at once.
+// The required settings come first, and are reported one at a time: without
+// them there's little point in looking at the rest.
+func (c *Config) Validate() error {
+	required := []struct {
+		key, env, value string
+		needed          bool
+	}{
+		{"gotify.url", "GOTIFY_URL", c.Gotify.URL, true},
+		{"gotify.token", "GOTIFY_APP_TOKEN", c.Gotify.Token, true},
+		// Not needed when all controllers use endpoints of their own.
+		{"omada.shared_secret", "OMADA_SHARED_SECRET", c.Omada.SharedSecret, len(c.Endpoints) == 0 && c.EndpointsFile == ""},
+	}
+
+	for _, r := range required {
+		if r.needed && r.value == "" {
+			if c.File == "" {
+				return fmt.Errorf("%v environment variable is required", r.env)
+			}
+			return fmt.Errorf("%v is required", c.name(r.key, r.env))
+		}
+	}
+
+	errs := []error{}
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		fail("%v must be a port number, not %q", c.name("port", "PORT"), c.Port)
+	}
+
+	if c.Metrics.Port != "" {
+		if port, err := strconv.Atoi(c.Metrics.Port); err != nil || port <= 0 || port > 65535 {
+			fail("%v must be a port number, not %q", c.name("metrics.port", "METRICS_PORT"), c.Metrics.Port)
+		} else if c.Metrics.Port == c.Port {
+			fail("%v must differ from %v", c.name("metrics.port", "METRICS_PORT"), c.name("port", "PORT"))
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("%v and %v must be set together", c.name("tls.cert_file", "TLS_CERT_FILE"), c.name("tls.key_file", "TLS_KEY_FILE"))
+	} else if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		fail("%v needs %v and %v", c.name("tls.client_ca_file", "TLS_CLIENT_CA_FILE"), c.name("tls.cert_file", "TLS_CERT_FILE"), c.name("tls.key_file", "TLS_KEY_FILE"))
+	}
+
+	if c.MaxBodySize <= 0 {
+		fail("%v must be more than 0", c.name("max_body_size", "MAX_BODY_SIZE"))
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		fail("%v must be more than 0", c.name("shutdown_timeout", "SHUTDOWN_TIMEOUT"))
+	}
+
+	if format := c.Gotify.Format; format != "" && format != gotify.FormatMarkdown && format != gotify.FormatPlain {
+		fail("%v must be either %q or %q", c.name("gotify.format", "GOTIFY_FORMAT"), gotify.FormatMarkdown, gotify.FormatPlain)
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		fail("%v: %w", c.name("log.level", "LOG_LEVEL"), err)
+	}
+
+	if format := c.Log.Format; format != logging.FormatJSON && format != logging.FormatText {
+		fail("%v must be either %q or %q", c.name("log.format", "LOG_FORMAT"), logging.FormatJSON, logging.FormatText)
+	}
+
+	if _, err := c.Authenticators(); err != nil {
+		fail("%v: %w", c.name("omada.auth", "OMADA_AUTH"), err)
+	}
+
+	if _, err := c.AllowedNetworks(); err != nil {
+		fail("%v: %w", c.name("omada.allow", "OMADA_ALLOW"), err)
+	}
+
+	if _, err := c.ProxyNetworks(); err != nil {
+		fail("%v: %w", c.name("trusted_proxies", "TRUSTED_PROXIES"), err)
+	}
+
+	if c.RateLimit.PerIP < 0 || c.RateLimit.PerController < 0 {
+		fail("%v and %v can't be negative", c.name("rate_limit.per_ip", "RATE_LIMIT_PER_IP"), c.name("rate_limit.per_controller", "RATE_LIMIT_PER_CONTROLLER"))
+	}
+
+	if c.RateLimit.Burst < 1 {
+		fail("%v must be 1 or more", c.name("rate_limit.burst", "RATE_LIMIT_BURST"))
+	}
+
+	if c.Queue.MaxAge < 0 {
+		fail("%v can't be negative", c.name("queue.max_age", "QUEUE_MAX_AGE"))
+	}
+
+	if c.Flap.Threshold < 0 {
+		fail("%v must be 0 or more", c.name("flap.threshold", "FLAP_THRESHOLD"))
+	}
+
+	if c.Flap.Threshold > 0 && (c.Flap.Window <= 0 || c.Flap.Settle <= 0) {
+		fail("%v and %v must be more than 0", c.name("flap.window", "FLAP_WINDOW"), c.name("flap.settle", "FLAP_SETTLE"))
+	}
+
+	if c.History.MaxAge <= 0 {
+		fail("%v must be more than 0", c.name("history.max_age", "HISTORY_MAX_AGE"))
+	}
+
+	if c.History.MaxEntries < 0 {
+		fail("%v must be 0 or more", c.name("history.max_entries", "HISTORY_MAX_ENTRIES"))
+	}
+
+	if (c.Dashboard.User == "") != (c.Dashboard.Password == "") {
+		fail("%v and %v must be set together", c.name("dashboard.user", "DASHBOARD_USER"), c.name("dashboard.password", "DASHBOARD_PASSWORD"))
+	}
+
+	if c.Matrix.Homeserver != "" && (c.Matrix.AccessToken == "" || c.Matrix.RoomID == "") {
+		fail("%v and %v are required with %v", c.name("matrix.access_token", "MATRIX_ACCESS_TOKEN"), c.name("matrix.room_id", "MATRIX_ROOM_ID"), c.name("matrix.homeserver", "MATRIX_HOMESERVER"))
+	}
+
+	if c.SMTP.Host != "" {
+		if c.SMTP.Port <= 0 || c.SMTP.Port > 65535 {
+			fail("%v must be a port number, not %d", c.name("smtp.port", "SMTP_PORT"), c.SMTP.Port)
+		}
+
+		if c.SMTP.From == "" || len(c.SMTP.To) == 0 {
+			fail("%v and %v are required with %v", c.name("smtp.from", "SMTP_FROM"), c.name("smtp.to", "SMTP_TO"), c.name("smtp.host", "SMTP_HOST"))
+		}
+	}
+
+	// Only the rules, routes and endpoints in the configuration itself are
+	// checked here; their files are checked when they are loaded.
+
+	if c.Omada.RulesFile != "" && len(c.Omada.Rules) > 0 {
+		fail("omada.rules and %v can't both be used", c.name("omada.rules_file", "OMADA_RULES_FILE"))
+	} else if c.Omada.RulesFile == "" {
+		if _, err := c.Rules(); err != nil {
+			fail("omada.rules: %w", err)
+		}
+	}
+
+	if c.Gotify.RoutesFile != "" && (len(c.Gotify.Routes) > 0 || c.Gotify.DefaultRoute != nil) {
+		fail("gotify.routes and %v can't both be used", c.name("gotify.routes_file", "GOTIFY_ROUTES_FILE"))
+	} else if c.Gotify.RoutesFile == "" {
+		if _, err := c.Routes(); err != nil {
+			fail("gotify.routes: %w", err)
+		}
+	}
+
+	if c.EndpointsFile != "" && len(c.Endpoints) > 0 {
+		fail("endpoints and %v can't both be used", c.name("endpoints_file", "ENDPOINTS_FILE"))
+	} else if c.EndpointsFile == "" {
+		if _, err := c.WebhookEndpoints(gotify.GotifyClient{}); err != nil {
+			fail("endpoints: %w", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// How to refer to a setting in an error message: by its environment
+// variable when there is no configuration file, or by both when there is.
+func (c *Config) name(key, env string) string {
+	if c.File == "" {
+		return env
+	}
+	return fmt.Sprintf("%v (%v)", key, env)
+}
+
+// EOF
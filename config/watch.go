@@ -0,0 +1,79 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// What is known about a file, enough to tell whether it has changed.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime int64
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// WatchFiles calls changed whenever one or more of the files returned by
+// files is changed, created or removed, until the context is cancelled.
+// The files are checked every interval, and asked for again each time as
+// they can change with the configuration. Polling works everywhere,
+// including for files bind mounted into a container and for files which
+// are replaced rather than written to (as Kubernetes does with ConfigMaps).
+func WatchFiles(ctx context.Context, interval time.Duration, files func() []string, changed func()) {
+	seen := map[string]fileState{}
+	for _, path := range files() {
+		seen[path] = statFile(path)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := map[string]fileState{}
+		modified := false
+
+		for _, path := range files() {
+			state := statFile(path)
+			current[path] = state
+
+			// A file that wasn't watched before isn't a change in itself.
+			if previous, known := seen[path]; known && previous != state {
+				modified = true
+			}
+		}
+
+		seen = current
+
+		if modified {
+			changed()
+		}
+	}
+}
+
+// The files the configuration was read from, which should be watched for
+// changes: the configuration file itself and the files it refers to.
+func (c *Config) Files() []string {
+	files := []string{}
+	for _, path := range []string{c.File, c.Omada.RulesFile, c.Gotify.RoutesFile, c.EndpointsFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// EOF
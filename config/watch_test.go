@@ -0,0 +1,65 @@
+package config_test
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/config"
+)
+
+func TestWatchFiles(t *testing.T) {
+	path := writeFile(t, "config.yaml", "port: 8080\n")
+
+	var changes atomic.Int32
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go config.WatchFiles(ctx, 5*time.Millisecond, func() []string { return []string{path} }, func() { changes.Add(1) })
+
+	// Nothing changes at first.
+	time.Sleep(30 * time.Millisecond)
+	if n := changes.Load(); n != 0 {
+		t.Fatalf("Expected no changes yet, got %d", n)
+	}
+
+	if err := os.WriteFile(path, []byte("port: 9090\n"), 0o600); err != nil {
+		t.Fatalf("Could not change the file: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for changes.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the change to be noticed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Could not remove the file: %v", err)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for changes.Load() == 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the removal to be noticed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConfig_Files(t *testing.T) {
+	cfg := config.Default()
+	cfg.File = "/etc/omada-to-gotify.yaml"
+	cfg.Omada.RulesFile = "/etc/omada-rules.yaml"
+
+	files := cfg.Files()
+	if len(files) != 2 || files[0] != cfg.File || files[1] != cfg.Omada.RulesFile {
+		t.Errorf("Unexpected files %q", files)
+	}
+}
+
+// EOF
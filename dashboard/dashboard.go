@@ -0,0 +1,174 @@
+// Package dashboard serves a web page showing the messages received lately,
+// what became of them, and which links of each site are down or flapping
+// right now; so it can be checked whether an alert came through without
+// going through the logs. The page is built into the program.
+package dashboard
+
+import (
+	"cmp"
+	"context"
+	_ "embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/linkstate"
+)
+
+// The number of messages shown unless asked otherwise.
+const DefaultLimit = 50
+
+//go:embed dashboard.html
+var page string
+
+var pageTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"since": func(t time.Time) string {
+		return time.Since(t).Round(time.Second).String()
+	},
+}).Parse(page))
+
+// Dashboard shows what is in the history, and the state the outage tracker
+// and flap detector keep; any of them may be nil.
+type Dashboard struct {
+	History *history.Store
+	Outages *linkstate.OutageTracker
+	Flaps   *linkstate.FlapDetector
+
+	// Sends the message with the ID in the history again, to the notification
+	// services it went to before; without it, messages can't be sent again.
+	Resend func(ctx context.Context, id string) error
+
+	Logger *slog.Logger
+}
+
+// The state of one site: the links down, and those flapping.
+type site struct {
+	Endpoint   string
+	Controller string
+	Site       string
+	Down       []linkstate.DownLink
+	Flapping   []linkstate.FlappingLink
+}
+
+// Handler serves the page on /dashboard/, which takes the same parameters
+// as the history API (see history.ParseQuery), and sends messages again
+// on POST to /dashboard/resend/<id>. Posts from other sites are refused.
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dashboard/{$}", d.page)
+	mux.HandleFunc("POST /dashboard/resend/{id}", d.resend)
+
+	return http.NewCrossOriginProtection().Handler(mux)
+}
+
+func (d *Dashboard) page(w http.ResponseWriter, r *http.Request) {
+	q, err := history.ParseQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+
+	entries := d.History.Query(q)
+
+	data := struct {
+		Entries   []history.Entry
+		Sites     []*site
+		Query     url.Values
+		Resent    string
+		Failed    string
+		CanResend bool
+		History   bool
+	}{
+		Entries:   entries,
+		Sites:     d.sites(entries),
+		Query:     r.URL.Query(),
+		Resent:    r.URL.Query().Get("resent"),
+		Failed:    r.URL.Query().Get("failed"),
+		CanResend: d.Resend != nil,
+		History:   d.History != nil,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := pageTemplate.Execute(w, data); err != nil {
+		d.Logger.Error("Could not show the dashboard", "error", err)
+	}
+}
+
+// The sites with links down or flapping, followed by the other sites the
+// messages shown came from (all of whose links are up, as far as known).
+func (d *Dashboard) sites(entries []history.Entry) []*site {
+	sites := []*site{}
+	find := func(endpoint, controller, name string) *site {
+		for _, s := range sites {
+			if s.Endpoint == endpoint && strings.EqualFold(s.Controller, controller) && strings.EqualFold(s.Site, name) {
+				return s
+			}
+		}
+		s := &site{Endpoint: endpoint, Controller: controller, Site: name}
+		sites = append(sites, s)
+		return s
+	}
+
+	if d.Outages != nil {
+		for _, link := range d.Outages.Down() {
+			s := find(link.Key.Endpoint, link.Key.Controller, link.Key.Site)
+			s.Down = append(s.Down, link)
+		}
+	}
+
+	if d.Flaps != nil {
+		for _, link := range d.Flaps.Flapping() {
+			s := find(link.Key.Endpoint, link.Key.Controller, link.Key.Site)
+			s.Flapping = append(s.Flapping, link)
+		}
+	}
+
+	slices.SortStableFunc(sites, func(a, b *site) int {
+		return cmp.Or(
+			cmp.Compare(strings.ToLower(a.Controller), strings.ToLower(b.Controller)),
+			cmp.Compare(strings.ToLower(a.Site), strings.ToLower(b.Site)),
+		)
+	})
+
+	for _, entry := range entries {
+		find(entry.Endpoint, entry.Controller, entry.Site)
+	}
+
+	return sites
+}
+
+// Sends the message again, and goes back to the page, saying how it went.
+func (d *Dashboard) resend(w http.ResponseWriter, r *http.Request) {
+	if d.Resend == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := r.PathValue("id")
+	result := url.Values{}
+
+	if err := d.Resend(r.Context(), id); err != nil {
+		d.Logger.Error("Could not send the message again", "id", id, "error", err)
+		result.Set("failed", id)
+	} else {
+		result.Set("resent", id)
+	}
+
+	http.Redirect(w, r, "/dashboard/?"+result.Encode(), http.StatusSeeOther)
+}
+
+// EOF
@@ -0,0 +1,128 @@
+package dashboard_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/dashboard"
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/linkstate"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+const offline = "[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline."
+
+func TestDashboard(t *testing.T) {
+	store, _ := history.New("", slog.Default())
+	outages := linkstate.NewOutageTracker(slog.Default())
+
+	down := &omada.OmadaMessage{Controller: "Controller", Site: "Home", Text: []string{offline}, Timestamp: 1758852904877}
+	outages.Observe(down)
+	store.Add(down, []byte(`{"Controller":"Controller","Site":"Home","text":["`+offline+`"]}`))
+	store.Record(down.ID, history.Delivery{Notifier: "gotify", Result: history.ResultFailed, Error: "gotify is down"})
+
+	other := &omada.OmadaMessage{Controller: "Controller", Site: "Office", Text: []string{"<b>Something</b> happened."}}
+	store.Add(other, nil)
+
+	resent := []string{}
+	d := &dashboard.Dashboard{
+		History: store,
+		Outages: outages,
+		Logger:  slog.Default(),
+		Resend: func(ctx context.Context, id string) error {
+			resent = append(resent, id)
+			if id != down.ID {
+				return errors.New("no such message")
+			}
+			return nil
+		},
+	}
+
+	handler := d.Handler()
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	response := serve(httptest.NewRequest(http.MethodGet, "/dashboard/", nil))
+	page := response.Body.String()
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected the page, got %d: %s", response.Code, page)
+	}
+
+	for _, want := range []string{
+		down.Title(),
+		"gotify is down",
+		`class="result failed"`,
+		"2.5G WAN1",
+		"is down, for",
+		"All links up", // the office
+		`action="/dashboard/resend/` + down.ID + `"`,
+		"&lt;b&gt;Something&lt;/b&gt;",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected the page to contain %q", want)
+		}
+	}
+
+	// Made up messages can't be sent again.
+	if strings.Contains(page, "/dashboard/resend/"+other.ID) {
+		t.Errorf("Expected no button to send a message without its payload again")
+	}
+
+	if response := serve(httptest.NewRequest(http.MethodGet, "/dashboard/?site=office", nil)); strings.Contains(response.Body.String(), down.Title()) {
+		t.Errorf("Expected the messages of other sites to be left out")
+	}
+
+	if response := serve(httptest.NewRequest(http.MethodGet, "/dashboard/?limit=none", nil)); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid query to be refused, got %d", response.Code)
+	}
+
+	response = serve(httptest.NewRequest(http.MethodPost, "/dashboard/resend/"+down.ID, nil))
+	if response.Code != http.StatusSeeOther || response.Header().Get("Location") != "/dashboard/?resent="+down.ID {
+		t.Errorf("Expected to be sent back to the page, got %d to %q", response.Code, response.Header().Get("Location"))
+	}
+
+	response = serve(httptest.NewRequest(http.MethodPost, "/dashboard/resend/unknown", nil))
+	if response.Header().Get("Location") != "/dashboard/?failed=unknown" {
+		t.Errorf("Expected to be told it failed, got %q", response.Header().Get("Location"))
+	}
+
+	// A form on another site can't make the browser send messages again.
+	request := httptest.NewRequest(http.MethodPost, "/dashboard/resend/"+down.ID, nil)
+	request.Header.Set("Sec-Fetch-Site", "cross-site")
+	if response := serve(request); response.Code != http.StatusForbidden {
+		t.Errorf("Expected a cross-site post to be refused, got %d", response.Code)
+	}
+
+	if strings.Join(resent, ",") != down.ID+",unknown" {
+		t.Errorf("Expected two messages to be sent again, got %v", resent)
+	}
+}
+
+func TestDashboard_Empty(t *testing.T) {
+	d := &dashboard.Dashboard{Logger: slog.Default()}
+
+	response := httptest.NewRecorder()
+	d.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/dashboard/", nil))
+
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "The history is off") {
+		t.Errorf("Expected a page without history, got %d: %s", response.Code, response.Body)
+	}
+
+	response = httptest.NewRecorder()
+	d.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/dashboard/resend/abc", nil))
+
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected nothing to send again without a way to, got %d", response.Code)
+	}
+}
+
+// EOF
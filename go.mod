@@ -5,6 +5,7 @@ go 1.25.1
 require (
 	github.com/go-test/deep v1.1.1
 	github.com/gotify/go-api-client/v2 v2.0.4
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -45,5 +46,4 @@ require (
 	go.opentelemetry.io/otel/trace v1.38.0 // indirect
 	golang.org/x/sync v0.17.0 // indirect
 	golang.org/x/sys v0.36.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
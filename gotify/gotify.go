@@ -1,9 +1,12 @@
 package gotify
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/go-openapi/runtime"
 	"github.com/gotify/go-api-client/v2/auth"
@@ -11,6 +14,7 @@ import (
 	"github.com/gotify/go-api-client/v2/client/message"
 	"github.com/gotify/go-api-client/v2/gotify"
 	"github.com/gotify/go-api-client/v2/models"
+	"github.com/leeft/omada-to-gotify/logging"
 	"github.com/leeft/omada-to-gotify/omada"
 )
 
@@ -22,27 +26,59 @@ type GotifyClientMessage interface {
 type GotifyClient struct {
 	GotifyURL string
 	Token     string
-	Logger    *log.Logger
+	Logger    *slog.Logger
+
+	// How the message body is formatted; Markdown unless set to FormatPlain.
+	Format string
 }
 
+// The formats messages can be sent to Gotify in. Markdown is nicer to look at,
+// but not every client renders it well (some show the formatting characters).
+const (
+	FormatMarkdown = "markdown"
+	FormatPlain    = "plain"
+)
+
 // Private method to turn an `OmadaMessage` into a `CreateMessageParams` that the gotify client
 // code can work with.
-func (msg GotifyClient) parameters(payload *omada.OmadaMessage) *message.CreateMessageParams {
-	params := message.NewCreateMessageParams()
+//
+// The type of message and the entities found in it are passed along as extras, so that
+// clients (or Gotify plugins) can make use of them without having to parse the text.
+func (msg GotifyClient) parameters(ctx context.Context, payload *omada.OmadaMessage) *message.CreateMessageParams {
+	extras := map[string]interface{}{
+		"omada::type":     payload.TypeName(),
+		"omada::entities": payload.Entities(),
+	}
+
+	body := payload.Body()
+
+	if msg.Format != FormatPlain {
+		body = payload.MarkdownBody()
+		extras["client::display"] = map[string]interface{}{
+			"contentType": "text/markdown",
+		}
+	}
+
+	params := message.NewCreateMessageParamsWithContext(ctx)
 	params.Body = &models.MessageExternal{
 		Title:    payload.Title(),
-		Message:  payload.Body(),
+		Message:  body,
 		Date:     payload.Date(),
 		Priority: payload.Priority(),
+		Extras:   extras,
 	}
 	return params
 }
 
+// How long a request to Gotify may take at most, whatever the context given;
+// a server that stops answering mustn't hold up the deliveries behind it.
+const requestTimeout = 30 * time.Second
+
 // Public method to build and return a `GotifyREST` client, which is passed to the Send method.
 // With this separation it's MUCH easier to mock and test the Send method.
 func (msg GotifyClient) Client() *client.GotifyREST {
 	myURL, _ := url.Parse(msg.GotifyURL)
-	client := gotify.NewClient(myURL, &http.Client{})
+	client := gotify.NewClient(myURL, &http.Client{Timeout: requestTimeout})
 	return client
 }
 
@@ -52,16 +88,90 @@ func (msg GotifyClient) Client() *client.GotifyREST {
 //
 // If successful, it prints a confirmation message and returns nil. If there's
 // an error during client creation or message sending, it logs the error and returns the error.
-func (msg GotifyClient) Send(cl GotifyClientMessage, payload *omada.OmadaMessage) error {
-	_, err := cl.CreateMessage(msg.parameters(payload), auth.TokenAuth(msg.Token))
+// The request is given up on when the context is done.
+func (msg GotifyClient) Send(ctx context.Context, cl GotifyClientMessage, payload *omada.OmadaMessage) error {
+	_, err := cl.CreateMessage(msg.parameters(ctx, payload), auth.TokenAuth(msg.Token))
 
+	logger := logging.WithRequestID(msg.Logger, payload.RequestID)
 	if err != nil {
-		msg.Logger.Printf("Could not send message to gotify: %v", err)
+		logger.Error("Could not send message to gotify", "error", err)
 		return err
 	}
 
-	msg.Logger.Println("Message sent to gotify")
+	logger.Info("Message sent to gotify")
 	return nil
 }
 
+// Notifier delivers messages through a GotifyClient, so it can be used as
+// one of the notifiers (see the notify package) next to other services.
+//
+// With Routes set, each message is sent to the Gotify application (and
+// server) its route picks; the client's URL and token are the default.
+type Notifier struct {
+	Client  GotifyClient
+	Message GotifyClientMessage
+	Routes  *RouteSet
+
+	mu      sync.Mutex
+	servers map[string]GotifyClientMessage
+}
+
+// NewNotifier builds a Notifier using the Gotify REST client for the client's URL.
+func NewNotifier(gc GotifyClient) *Notifier {
+	return &Notifier{
+		Client:  gc,
+		Message: gc.Client().Message,
+	}
+}
+
+func (n *Notifier) Name() string {
+	return "gotify"
+}
+
+// RouteOf names the route the message takes, and whether it is dropped.
+func (n *Notifier) RouteOf(payload *omada.OmadaMessage) (string, bool) {
+	dest := n.Routes.Resolve(n.Client, payload)
+	return dest.Route, dest.Drop
+}
+
+// Notify sends the message to Gotify, giving up when the context is done.
+func (n *Notifier) Notify(ctx context.Context, payload *omada.OmadaMessage) error {
+	dest := n.Routes.Resolve(n.Client, payload)
+
+	if dest.Drop {
+		logger := logging.WithRequestID(n.Client.Logger, payload.RequestID)
+		logger.Info("Dropping the message as per its route", "type", payload.TypeName(), "route", dest.Route)
+		return nil
+	}
+
+	gc := n.Client
+	gc.GotifyURL = dest.URL
+	gc.Token = dest.Token
+
+	return gc.Send(ctx, n.server(gc), payload)
+}
+
+// The API client for the server the message is routed to; one is created
+// (and kept) for each server other than the default one.
+func (n *Notifier) server(gc GotifyClient) GotifyClientMessage {
+	if gc.GotifyURL == n.Client.GotifyURL {
+		return n.Message
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.servers == nil {
+		n.servers = map[string]GotifyClientMessage{}
+	}
+
+	cl, ok := n.servers[gc.GotifyURL]
+	if !ok {
+		cl = gc.Client().Message
+		n.servers[gc.GotifyURL] = cl
+	}
+
+	return cl
+}
+
 // EOF
@@ -1,10 +1,13 @@
 package gotify_test
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-openapi/runtime"
 	"github.com/gotify/go-api-client/v2/client"
@@ -18,11 +21,13 @@ import (
 
 type GotifyClientMessageMock struct {
 	Calls       int
+	Params      *message.CreateMessageParams
 	returnError error
 }
 
 func (mock *GotifyClientMessageMock) CreateMessage(params *message.CreateMessageParams, authInfo runtime.ClientAuthInfoWriter) (*message.CreateMessageOK, error) {
 	mock.Calls += 1
+	mock.Params = params
 	return nil, mock.returnError
 }
 
@@ -65,10 +70,7 @@ func TestGotifyClient_Send(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		var (
-			buf    bytes.Buffer
-			logger = log.New(&buf, "logger: ", log.Lshortfile)
-		)
+		logger := testLogger(t)
 
 		t.Run(tt.name, func(t *testing.T) {
 
@@ -84,7 +86,7 @@ func TestGotifyClient_Send(t *testing.T) {
 				mock.returnError = errors.New("test induced error")
 			}
 
-			gotErr := cl.Send(mock, tt.payload)
+			gotErr := cl.Send(context.Background(), mock, tt.payload)
 
 			if mock.Calls != tt.calls {
 				t.Fatalf("Expected %d calls to have been made to the mocked method but got %d", tt.calls, mock.Calls)
@@ -111,6 +113,100 @@ func TestGotifyClient_Send(t *testing.T) {
 	}
 }
 
+func TestGotifyClient_Extras(t *testing.T) {
+	logger := testLogger(t)
+
+	cl := gotify.GotifyClient{
+		GotifyURL: "http://localhost:8081",
+		Token:     "doesnotmatter",
+		Logger:    logger,
+	}
+
+	mock := &GotifyClientMessageMock{}
+
+	payload := &omada.OmadaMessage{
+		Controller: "Omada Controller NNNNNN",
+		Site:       "Test Site",
+		Text:       []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\r"},
+		Timestamp:  1758852904877,
+	}
+
+	if err := cl.Send(context.Background(), mock, payload); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+
+	extras := mock.Params.Body.Extras
+
+	if got := extras["omada::type"]; got != "offline" {
+		t.Errorf("Expected the omada::type extra to be `offline`, got `%v`", got)
+	}
+
+	entities, ok := extras["omada::entities"].(omada.Entities)
+	if !ok {
+		t.Fatalf("Expected the omada::entities extra to hold the entities, got `%v`", extras["omada::entities"])
+	}
+
+	if entities.Port() != "2.5G WAN1" {
+		t.Errorf("Expected the entities to include the port, got `%+v`", entities)
+	}
+}
+
+func TestGotifyClient_Format(t *testing.T) {
+	payload := &omada.OmadaMessage{
+		Text: []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\r"},
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		markdown bool
+	}{
+		{name: "Markdown by default", format: "", markdown: true},
+		{name: "Markdown", format: gotify.FormatMarkdown, markdown: true},
+		{name: "Plain text", format: gotify.FormatPlain, markdown: false},
+	}
+
+	for _, tt := range tests {
+		logger := testLogger(t)
+
+		t.Run(tt.name, func(t *testing.T) {
+			cl := gotify.GotifyClient{
+				GotifyURL: "http://localhost:8081",
+				Token:     "doesnotmatter",
+				Logger:    logger,
+				Format:    tt.format,
+			}
+
+			mock := &GotifyClientMessageMock{}
+
+			if err := cl.Send(context.Background(), mock, payload); err != nil {
+				t.Fatalf("Send() failed: %v", err)
+			}
+
+			body := mock.Params.Body
+			display, hasDisplay := body.Extras["client::display"]
+
+			if tt.markdown {
+				if !hasDisplay || display.(map[string]interface{})["contentType"] != "text/markdown" {
+					t.Errorf("Expected the client::display extra to ask for markdown, got `%v`", display)
+				}
+
+				if body.Message != payload.MarkdownBody() {
+					t.Errorf("Expected the markdown body, got %q", body.Message)
+				}
+			} else {
+				if hasDisplay {
+					t.Errorf("Expected no client::display extra, got `%v`", display)
+				}
+
+				if body.Message != payload.Body() {
+					t.Errorf("Expected the plain body, got %q", body.Message)
+				}
+			}
+		})
+	}
+}
+
 func TestGotifyClient_Client(t *testing.T) {
 
 	// I know, with one test it doesn't NEED to be a loop. But who knows
@@ -126,10 +222,7 @@ func TestGotifyClient_Client(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		var (
-			buf    bytes.Buffer
-			logger = log.New(&buf, "logger: ", log.Lshortfile)
-		)
+		logger := testLogger(t)
 
 		gcl := gotify.GotifyClient{
 			GotifyURL: "http://localhost:8081",
@@ -150,4 +243,41 @@ func TestGotifyClient_Client(t *testing.T) {
 	}
 }
 
+// A Gotify server that stops answering is given up on once the context is
+// done, rather than holding up the deliveries behind it.
+func TestNotifier_GivesUpWithContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	notifier := gotify.NewNotifier(gotify.GotifyClient{
+		GotifyURL: server.URL,
+		Token:     "doesnotmatter",
+		Logger:    testLogger(t),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := notifier.Notify(ctx, &omada.OmadaMessage{Site: "Hanging", Text: []string{"Anything"}})
+
+	if err == nil {
+		t.Fatal("Notify() succeeded against a server that never answers")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Notify() took %v to give up", elapsed)
+	}
+}
+
+// A logger for the tests, writing to the output of the test so the log
+// shows up along with any failures (and with -v).
+func testLogger(t *testing.T) *slog.Logger {
+	return slog.New(slog.NewTextHandler(t.Output(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
 // EOF
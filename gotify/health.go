@@ -0,0 +1,84 @@
+package gotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gotify/go-api-client/v2/auth"
+	"github.com/gotify/go-api-client/v2/client/message"
+	"github.com/gotify/go-api-client/v2/client/version"
+	"github.com/gotify/go-api-client/v2/models"
+)
+
+// CheckReachable checks that the Gotify server answers, by asking for its
+// version; that doesn't need a token.
+func (msg GotifyClient) CheckReachable(ctx context.Context) error {
+	_, err := msg.Client().Version.GetVersion(version.NewGetVersionParamsWithContext(ctx))
+	return err
+}
+
+// CheckToken checks that Gotify accepts the application token, without
+// actually sending a message. Application tokens can't be used for
+// anything but sending messages, so there is no call that only reads to
+// check them with; instead an empty message is sent, which Gotify refuses.
+//
+// This relies on Gotify checking the token before it checks the message,
+// which it doesn't promise but has always done (up to at least 2.6): an
+// empty message is refused as a bad request when the token is fine, and as
+// unauthorised (or forbidden) when it isn't. Should a version check the
+// message first, every token passes; should it accept empty messages, one
+// shows up in the application.
+func (msg GotifyClient) CheckToken(ctx context.Context) error {
+	params := message.NewCreateMessageParamsWithContext(ctx)
+	params.Body = &models.MessageExternal{}
+
+	_, err := msg.Client().Message.CreateMessage(params, auth.TokenAuth(msg.Token))
+
+	var (
+		badRequest   *message.CreateMessageBadRequest
+		unauthorized *message.CreateMessageUnauthorized
+		forbidden    *message.CreateMessageForbidden
+	)
+
+	switch {
+	case err == nil, errors.As(err, &badRequest):
+		return nil
+	case errors.As(err, &unauthorized), errors.As(err, &forbidden):
+		return errors.New("the application token was refused")
+	}
+
+	return err
+}
+
+// CheckTokens checks every application token the notifiers send with: the
+// default token of each and those of its routes, each token on each server
+// only once. The errors name the routes whose token was refused.
+func CheckTokens(ctx context.Context, notifiers ...*Notifier) error {
+	type serverToken struct{ url, token string }
+
+	checked := map[serverToken]bool{}
+	errs := []error{}
+
+	for _, n := range notifiers {
+		for _, dest := range n.Routes.Destinations(n.Client) {
+			key := serverToken{dest.URL, dest.Token}
+			if checked[key] {
+				continue
+			}
+			checked[key] = true
+
+			gc := n.Client
+			gc.GotifyURL = dest.URL
+			gc.Token = dest.Token
+
+			if err := gc.CheckToken(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("route %q to %v: %w", dest.Route, dest.URL, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// EOF
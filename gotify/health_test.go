@@ -0,0 +1,132 @@
+package gotify_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/gotify"
+)
+
+// A stand-in for the parts of Gotify the checks use; only "good-token" is
+// accepted, and messages without text are refused just like Gotify does.
+// Counts the messages it is offered.
+func healthStandIn(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	offered := &atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/message" {
+			offered.Add(1)
+		}
+
+		switch {
+		case r.URL.Path == "/version":
+			w.Write([]byte(`{"version":"2.6.3","commit":"abc","buildDate":"2025-06-01"}`))
+		case r.URL.Path == "/message" && r.Header.Get("X-Gotify-Key") != "good-token":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Unauthorized","errorCode":401,"errorDescription":"you need to provide a valid access token"}`))
+		case r.URL.Path == "/message":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"Bad Request","errorCode":400,"errorDescription":"Field 'message' is required"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, offered
+}
+
+func TestGotifyClient_Checks(t *testing.T) {
+	logger := testLogger(t)
+
+	server, _ := healthStandIn(t)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name      string
+		url       string
+		token     string
+		reachable bool
+		tokenOK   bool
+	}{
+		{"All is well", server.URL + "/", "good-token", true, true},
+		{"Revoked token", server.URL + "/", "revoked-token", true, false},
+		{"Server is down", down.URL + "/", "good-token", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gc := gotify.GotifyClient{GotifyURL: tt.url, Token: tt.token, Logger: logger}
+
+			if err := gc.CheckReachable(context.Background()); (err == nil) != tt.reachable {
+				t.Errorf("Expected reachable to be %v, got %v", tt.reachable, err)
+			}
+
+			err := gc.CheckToken(context.Background())
+			if (err == nil) != tt.tokenOK {
+				t.Errorf("Expected the token check to pass: %v, got %v", tt.tokenOK, err)
+			}
+
+			if !tt.tokenOK && tt.reachable && !strings.Contains(err.Error(), "token was refused") {
+				t.Errorf("Expected the token to be refused, got %v", err)
+			}
+		})
+	}
+}
+
+// The tokens of the routes and of other notifiers (such as those of the
+// endpoints) are checked too, each only once.
+func TestCheckTokens(t *testing.T) {
+	logger := testLogger(t)
+	server, offered := healthStandIn(t)
+
+	routes, err := gotify.ParseRoutes([]byte(`
+routes:
+  - name: Security
+    match:
+      type: ^intrusion$
+    token: revoked-token
+  - name: Internet
+    match:
+      type: ^(offline|online)$
+    token: good-token
+  - name: Noise
+    match:
+      type: ^client-roaming$
+    drop: true
+`))
+	if err != nil {
+		t.Fatalf("ParseRoutes() failed: %v", err)
+	}
+
+	main := gotify.NewNotifier(gotify.GotifyClient{GotifyURL: server.URL + "/", Token: "good-token", Logger: logger})
+	main.Routes = routes
+
+	endpoint := gotify.NewNotifier(gotify.GotifyClient{GotifyURL: server.URL + "/", Token: "good-token", Logger: logger})
+
+	err = gotify.CheckTokens(context.Background(), main, endpoint)
+	if err == nil || !strings.Contains(err.Error(), `route "Security"`) {
+		t.Fatalf("Expected the token of the Security route to be refused, got %v", err)
+	}
+
+	if strings.Contains(err.Error(), `"Internet"`) || strings.Contains(err.Error(), `"default"`) {
+		t.Errorf("Only the Security route should fail, got %v", err)
+	}
+
+	if got := offered.Load(); got != 2 {
+		t.Errorf("Expected each token to be checked once, Gotify was offered %d messages", got)
+	}
+
+	if err := gotify.CheckTokens(context.Background(), endpoint); err != nil {
+		t.Errorf("CheckTokens() failed: %v", err)
+	}
+}
+
+// EOF
@@ -0,0 +1,351 @@
+package gotify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/leeft/omada-to-gotify/omada"
+	"gopkg.in/yaml.v3"
+)
+
+// A Route sends the messages it matches to a particular Gotify application,
+// optionally on another Gotify server, or drops them. Routes are normally
+// loaded from a file with LoadRoutes, in YAML or in JSON:
+//
+//	routes:
+//	  - name: Internet connection
+//	    match:
+//	      type: ^(offline|online|link-flapping|link-settled)$
+//	    token: AbCdEf123
+//	  - name: Security
+//	    match:
+//	      type: ^(intrusion|rogue-ap|login-failed)$
+//	    token: GhIjKl456
+//	    url: https://gotify.example.com/
+//	  - name: Nobody cares about roaming
+//	    match:
+//	      type: ^client-roaming$
+//	    drop: true
+//	  - name: Guest network
+//	    match:
+//	      ssid: ^Guest
+//	    token: StUvWx012
+//	default:
+//	  token: MnOpQr789
+//
+// The match conditions are regular expressions, all of those given must
+// match; type is matched against the name of the message type (see the
+// README for those), text matches when any one line of the text does.
+// The others match the entities mentioned in the message (see
+// omada.Entities), and match when any one of those does: device the name
+// and role the role of a device, mac a MAC address, ip an IPv4 or IPv6
+// address, port a port or interface and ssid the name of a wireless
+// network. Messages not matched by any route take the default route, which unless
+// given sends them to GOTIFY_URL with GOTIFY_APP_TOKEN.
+type Route struct {
+	Name  string     `yaml:"name" json:"name"`
+	Match RouteMatch `yaml:"match" json:"match"`
+	URL   string     `yaml:"url" json:"url"`
+	Token string     `yaml:"token" json:"token"`
+	Drop  bool       `yaml:"drop" json:"drop"`
+
+	messageType *regexp.Regexp
+	controller  *regexp.Regexp
+	site        *regexp.Regexp
+	description *regexp.Regexp
+	text        *regexp.Regexp
+	device      *regexp.Regexp
+	role        *regexp.Regexp
+	mac         *regexp.Regexp
+	ip          *regexp.Regexp
+	port        *regexp.Regexp
+	ssid        *regexp.Regexp
+}
+
+type RouteMatch struct {
+	Type        string `yaml:"type" json:"type"`
+	Controller  string `yaml:"controller" json:"controller"`
+	Site        string `yaml:"site" json:"site"`
+	Description string `yaml:"description" json:"description"`
+	Text        string `yaml:"text" json:"text"`
+	Device      string `yaml:"device" json:"device"`
+	Role        string `yaml:"role" json:"role"`
+	MAC         string `yaml:"mac" json:"mac"`
+	IP          string `yaml:"ip" json:"ip"`
+	Port        string `yaml:"port" json:"port"`
+	SSID        string `yaml:"ssid" json:"ssid"`
+}
+
+// An ordered list of routes; the first route to match a message wins.
+type RouteSet struct {
+	Routes  []*Route `yaml:"routes" json:"routes"`
+	Default *Route   `yaml:"default" json:"default"`
+}
+
+// LoadRoutes reads, parses and validates a routes file.
+func LoadRoutes(path string) (*RouteSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read routes file: %w", err)
+	}
+
+	rs, err := ParseRoutes(data)
+	if err != nil {
+		return nil, fmt.Errorf("routes file %v: %w", path, err)
+	}
+
+	return rs, nil
+}
+
+// ParseRoutes parses and validates routes given as YAML or JSON. Unknown
+// fields are rejected to catch typos.
+func ParseRoutes(data []byte) (*RouteSet, error) {
+	rs := &RouteSet{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(rs); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse routes: %w", err)
+	}
+
+	if err := rs.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// Validate checks the routes and prepares them for use. ParseRoutes does
+// this already; it's needed for routes decoded as part of another file.
+func (rs *RouteSet) Validate() error {
+	return rs.compile()
+}
+
+// Validates every route and prepares it for use, collecting all the errors
+// so they can be fixed in one go.
+func (rs *RouteSet) compile() error {
+	errs := []error{}
+
+	for i, route := range rs.Routes {
+		if route == nil {
+			errs = append(errs, fmt.Errorf("route %d is empty", i+1))
+			continue
+		}
+
+		label := fmt.Sprintf("route %d", i+1)
+		if route.Name != "" {
+			label += fmt.Sprintf(" (%q)", route.Name)
+		}
+
+		if route.Match == (RouteMatch{}) {
+			errs = append(errs, fmt.Errorf("%v: no match conditions given", label))
+		}
+
+		for _, err := range route.compile() {
+			errs = append(errs, fmt.Errorf("%v: %w", label, err))
+		}
+	}
+
+	if rs.Default != nil {
+		if rs.Default.Match != (RouteMatch{}) {
+			errs = append(errs, errors.New("default route: can't have match conditions"))
+		}
+
+		for _, err := range rs.Default.compile() {
+			errs = append(errs, fmt.Errorf("default route: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (route *Route) compile() []error {
+	errs := []error{}
+
+	pattern := func(field, expr string) *regexp.Regexp {
+		if expr == "" {
+			return nil
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %v pattern: %w", field, err))
+		}
+		return re
+	}
+
+	route.messageType = pattern("type", route.Match.Type)
+	route.controller = pattern("controller", route.Match.Controller)
+	route.site = pattern("site", route.Match.Site)
+	route.description = pattern("description", route.Match.Description)
+	route.text = pattern("text", route.Match.Text)
+	route.device = pattern("device", route.Match.Device)
+	route.role = pattern("role", route.Match.Role)
+	route.mac = pattern("mac", route.Match.MAC)
+	route.ip = pattern("ip", route.Match.IP)
+	route.port = pattern("port", route.Match.Port)
+	route.ssid = pattern("ssid", route.Match.SSID)
+
+	// A token belongs to a server, so another server needs its own token.
+	if route.Drop && (route.Token != "" || route.URL != "") {
+		errs = append(errs, errors.New("a route that drops messages can't have a token or URL"))
+	} else if !route.Drop && route.URL != "" && route.Token == "" {
+		errs = append(errs, errors.New("a route with a URL needs a token as well"))
+	}
+
+	return errs
+}
+
+func (route *Route) matches(msg *omada.OmadaMessage) bool {
+	if route.messageType != nil && !route.messageType.MatchString(msg.TypeName()) {
+		return false
+	}
+
+	if route.controller != nil && !route.controller.MatchString(msg.Controller) {
+		return false
+	}
+
+	if route.site != nil && !route.site.MatchString(msg.Site) {
+		return false
+	}
+
+	if route.description != nil && !route.description.MatchString(msg.Description) {
+		return false
+	}
+
+	if route.text != nil && !matchesAny(route.text, msg.Text) {
+		return false
+	}
+
+	return route.matchesEntities(msg)
+}
+
+// Whether the entities mentioned in the message match; they are only looked
+// for when the route has a condition on them.
+func (route *Route) matchesEntities(msg *omada.OmadaMessage) bool {
+	if route.device == nil && route.role == nil && route.mac == nil && route.ip == nil && route.port == nil && route.ssid == nil {
+		return true
+	}
+
+	entities := msg.Entities()
+
+	names, roles := []string{}, []string{}
+	for _, device := range entities.Devices {
+		names = append(names, device.Name)
+		roles = append(roles, device.Role)
+	}
+
+	for _, condition := range []struct {
+		re     *regexp.Regexp
+		values []string
+	}{
+		{route.device, names},
+		{route.role, roles},
+		{route.mac, entities.MACs},
+		{route.ip, append(append([]string{}, entities.IPv4...), entities.IPv6...)},
+		{route.port, entities.Ports},
+		{route.ssid, entities.SSIDs},
+	} {
+		if condition.re != nil && !matchesAny(condition.re, condition.values) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether the pattern matches any one of the values.
+func matchesAny(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Where a message goes: the Gotify server and application token to send it
+// with, unless it is to be dropped.
+type Destination struct {
+	Route string
+	URL   string
+	Token string
+	Drop  bool
+}
+
+// Resolve finds the destination of the message. The URL and token of the
+// given client are used for whatever the matching route leaves out.
+func (rs *RouteSet) Resolve(gc GotifyClient, msg *omada.OmadaMessage) Destination {
+	if rs == nil {
+		return destination(gc, nil, "default")
+	}
+
+	for i, r := range rs.Routes {
+		if r.matches(msg) {
+			return destination(gc, r, routeName(r, i))
+		}
+	}
+
+	return destination(gc, rs.Default, "default")
+}
+
+// Destinations lists every destination the routes can send messages to,
+// including the default one, but not the routes dropping them.
+func (rs *RouteSet) Destinations(gc GotifyClient) []Destination {
+	dests := []Destination{}
+
+	if rs != nil {
+		for i, r := range rs.Routes {
+			dests = append(dests, destination(gc, r, routeName(r, i)))
+		}
+	}
+
+	var fallback *Route
+	if rs != nil {
+		fallback = rs.Default
+	}
+	dests = append(dests, destination(gc, fallback, "default"))
+
+	kept := dests[:0]
+	for _, dest := range dests {
+		if !dest.Drop {
+			kept = append(kept, dest)
+		}
+	}
+
+	return kept
+}
+
+// The name of the route in the logs, the history and the metrics.
+func routeName(route *Route, i int) string {
+	if route.Name != "" {
+		return route.Name
+	}
+	return fmt.Sprintf("route %d", i+1)
+}
+
+// The destination of the route, which uses the URL and token of the given
+// client for whatever it leaves out; nil for no route at all.
+func destination(gc GotifyClient, route *Route, name string) Destination {
+	dest := Destination{Route: name, URL: gc.GotifyURL, Token: gc.Token}
+
+	if route == nil {
+		return dest
+	}
+
+	dest.Drop = route.Drop
+	if route.URL != "" {
+		dest.URL = route.URL
+	}
+	if route.Token != "" {
+		dest.Token = route.Token
+	}
+
+	return dest
+}
+
+// EOF
@@ -0,0 +1,304 @@
+package gotify_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+const testRoutes = `
+routes:
+  - name: Internet connection
+    match:
+      type: ^(offline|online)$
+    token: wan-token
+  - name: Lab
+    match:
+      site: ^Lab$
+    drop: true
+  - match:
+      text: rogue AP
+    token: security-token
+    url: %v
+default:
+  token: everything-else
+`
+
+func TestRouteSet_Resolve(t *testing.T) {
+	routes, err := gotify.ParseRoutes([]byte(strings.Replace(testRoutes, "%v", "https://gotify.example.com/", 1)))
+	if err != nil {
+		t.Fatalf("ParseRoutes() failed: %v", err)
+	}
+
+	gc := gotify.GotifyClient{GotifyURL: "http://gotify.local/", Token: "app-token"}
+
+	tests := []struct {
+		name    string
+		routes  *gotify.RouteSet
+		message *omada.OmadaMessage
+		want    gotify.Destination
+	}{
+		{
+			name:    "No routes",
+			message: &omada.OmadaMessage{Site: "Home"},
+			want:    gotify.Destination{Route: "default", URL: "http://gotify.local/", Token: "app-token"},
+		},
+		{
+			name:   "Routed by type",
+			routes: routes,
+			message: &omada.OmadaMessage{
+				Site: "Lab",
+				Text: []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [WAN2] was offline."},
+			},
+			want: gotify.Destination{Route: "Internet connection", URL: "http://gotify.local/", Token: "wan-token"},
+		},
+		{
+			name:    "Dropped",
+			routes:  routes,
+			message: &omada.OmadaMessage{Site: "Lab", Text: []string{"Something else entirely."}},
+			want:    gotify.Destination{Route: "Lab", URL: "http://gotify.local/", Token: "app-token", Drop: true},
+		},
+		{
+			name:    "Routed to another server",
+			routes:  routes,
+			message: &omada.OmadaMessage{Site: "Home", Text: []string{"A rogue AP was detected."}},
+			want:    gotify.Destination{Route: "route 3", URL: "https://gotify.example.com/", Token: "security-token"},
+		},
+		{
+			name:    "Default route",
+			routes:  routes,
+			message: &omada.OmadaMessage{Site: "Home", Text: []string{"Something else entirely."}},
+			want:    gotify.Destination{Route: "default", URL: "http://gotify.local/", Token: "everything-else"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.routes.Resolve(gc, tt.message); got != tt.want {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+const entityRoutes = `
+routes:
+  - name: Core switch
+    match:
+      role: ^switch$
+      device: ^Core
+    token: core-token
+  - name: Office AP
+    match:
+      mac: ^60-A4-B7-1C-2D-3E$
+    token: ap-token
+  - name: Servers
+    match:
+      ip: ^(192\.168\.0\.1\d|2001:db8::)
+    token: server-token
+  - name: Uplinks
+    match:
+      port: WAN
+    token: wan-token
+  - name: Guests
+    match:
+      ssid: ^Guest
+    drop: true
+`
+
+func TestRouteSet_ResolveEntities(t *testing.T) {
+	routes, err := gotify.ParseRoutes([]byte(entityRoutes))
+	if err != nil {
+		t.Fatalf("ParseRoutes() failed: %v", err)
+	}
+
+	gc := gotify.GotifyClient{GotifyURL: "http://gotify.local/", Token: "app-token"}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "Device role and name",
+			text: "[switch:Core-1:B0-19-21-AA-BB-CC]: The PoE power of [Port 5] exceeds the power budget.",
+			want: "Core switch",
+		},
+		{
+			name: "Device role without the name",
+			text: "[switch:Edge-2:B0-19-21-AA-BB-DD] was upgraded successfully.",
+			want: "default",
+		},
+		{
+			name: "MAC address",
+			text: "[ap:60-A4-B7-1C-2D-3E] EAP245 Office was disconnected.",
+			want: "Office AP",
+		},
+		{
+			name: "IPv4 address",
+			text: "IPS blocked an attack from 198.51.100.23 to 192.168.0.10.",
+			want: "Servers",
+		},
+		{
+			name: "IPv6 address",
+			text: "IDS detected a suspicious connection from 198.51.100.23 to 2001:db8::10.",
+			want: "Servers",
+		},
+		{
+			name: "Port",
+			text: "[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.",
+			want: "Uplinks",
+		},
+		{
+			name: "SSID",
+			text: "[client:Pixel-8:D2-41-5F-0A-9B-11] is roaming from [ap:60-A4-B7-1C-2D-4F] to [ap:60-A4-B7-1C-2D-50] with SSID [Guest WiFi].",
+			want: "Guests",
+		},
+		{
+			name: "Nothing mentioned",
+			text: "Something else entirely.",
+			want: "default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := routes.Resolve(gc, &omada.OmadaMessage{Site: "Office", Text: []string{tt.text}})
+			if got.Route != tt.want {
+				t.Errorf("Resolve() took route %q, want %q", got.Route, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRoutes_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		want   []string
+	}{
+		{
+			name:   "Unknown field",
+			routes: "routes:\n  - match: {type: offline}\n    tokn: abc\n",
+			want:   []string{"field tokn not found"},
+		},
+		{
+			name: "Every problem is reported",
+			routes: `
+routes:
+  - name: Nothing
+    token: abc
+  - name: Bad
+    match:
+      site: "("
+      mac: "["
+    drop: true
+    token: abc
+  - match:
+      type: offline
+    url: https://gotify.example.com/
+default:
+  match:
+    site: Home
+`,
+			want: []string{
+				`route 1 ("Nothing"): no match conditions given`,
+				`route 2 ("Bad"): invalid site pattern`,
+				`route 2 ("Bad"): invalid mac pattern`,
+				`route 2 ("Bad"): a route that drops messages can't have a token or URL`,
+				`route 3: a route with a URL needs a token as well`,
+				`default route: can't have match conditions`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := gotify.ParseRoutes([]byte(tt.routes))
+			if err == nil {
+				t.Fatal("ParseRoutes() succeeded, expected an error")
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// A stand-in Gotify server, remembering the application tokens it was sent
+// messages with.
+type gotifyStandIn struct {
+	mu     sync.Mutex
+	tokens []string
+}
+
+func (g *gotifyStandIn) start(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		g.tokens = append(g.tokens, r.Header.Get("X-Gotify-Key"))
+		g.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1,"appid":1,"message":"","date":"2025-09-26T02:15:04Z"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestNotifier_Routes(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	)
+
+	local, remote := &gotifyStandIn{}, &gotifyStandIn{}
+	localServer, remoteServer := local.start(t), remote.start(t)
+
+	routes, err := gotify.ParseRoutes([]byte(strings.Replace(testRoutes, "%v", remoteServer.URL+"/", 1)))
+	if err != nil {
+		t.Fatalf("ParseRoutes() failed: %v", err)
+	}
+
+	notifier := gotify.NewNotifier(gotify.GotifyClient{GotifyURL: localServer.URL + "/", Token: "app-token", Logger: logger})
+	notifier.Routes = routes
+
+	for _, text := range []string{
+		"[gateway:98-03-8E-3A-8D-53]: The online detection result of [WAN2] was offline.",
+		"A rogue AP was detected.",
+		"Something else entirely.",
+	} {
+		if err := notifier.Notify(context.Background(), &omada.OmadaMessage{Site: "Home", Text: []string{text}}); err != nil {
+			t.Fatalf("Notify() failed: %v", err)
+		}
+	}
+
+	if err := notifier.Notify(context.Background(), &omada.OmadaMessage{Site: "Lab", Text: []string{"Dropped."}}); err != nil {
+		t.Fatalf("Notify() failed for a dropped message: %v", err)
+	}
+
+	if got := strings.Join(local.tokens, ","); got != "wan-token,everything-else" {
+		t.Errorf("The local server got messages for %q", got)
+	}
+
+	if got := strings.Join(remote.tokens, ","); got != "security-token" {
+		t.Errorf("The remote server got messages for %q", got)
+	}
+
+	if !strings.Contains(buf.String(), "route=Lab") {
+		t.Errorf("Expected the dropped message to be logged, got %q", buf.String())
+	}
+}
+
+// EOF
@@ -0,0 +1,120 @@
+// Package health serves the liveness and readiness reports of the program:
+// a JSON document with the outcome of each check and how long it took.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A Check is a single test of something the program depends on; it
+// returns an error describing what's wrong, or nil when all is well.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// The outcome of a single check.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// The outcome of all checks; the status is only "ok" when all of them are.
+type Report struct {
+	Status  string    `json:"status"`
+	Checked time.Time `json:"checked"`
+	Checks  []Result  `json:"checks"`
+}
+
+// Checker runs its checks and serves the report, which is kept for CacheFor
+// so that frequent probes don't turn into a stream of requests to whatever
+// is checked. Requests arriving while the checks run wait for their report.
+type Checker struct {
+	Checks   []Check
+	CacheFor time.Duration
+
+	// How long each check may take before it counts as failed.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	report *Report
+}
+
+// Report runs the checks, unless the last report is recent enough.
+func (c *Checker) Report(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.report != nil && time.Since(c.report.Checked) < c.CacheFor {
+		return *c.report
+	}
+
+	report := &Report{Status: StatusOK, Checked: time.Now(), Checks: []Result{}}
+
+	// One after the other; there are only a few, and they're quick when all
+	// is well.
+	for _, check := range c.Checks {
+		result := c.run(ctx, check)
+		if result.Status != StatusOK {
+			report.Status = StatusFailing
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	c.report = report
+	return *report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := check.Run(ctx)
+
+	result := Result{
+		Name:      check.Name,
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// ServeHTTP responds with the report; with status 200 when all checks pass,
+// and 503 when any of them fails.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Not the request's context: a probe giving up shouldn't leave a failed
+	// report in the cache for everyone else.
+	report := c.Report(context.WithoutCancel(r.Context()))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// EOF
@@ -0,0 +1,83 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/health"
+)
+
+func TestChecker(t *testing.T) {
+	runs := 0
+	failure := errors.New("the token was refused")
+	var fail error
+
+	checker := &health.Checker{
+		CacheFor: time.Hour,
+		Checks: []health.Check{
+			{Name: "reachable", Run: func(context.Context) error { runs++; return nil }},
+			{Name: "token", Run: func(context.Context) error { return fail }},
+		},
+	}
+
+	get := func() (int, health.Report) {
+		response := httptest.NewRecorder()
+		checker.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		report := health.Report{}
+		if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+			t.Fatalf("The report is not valid JSON: %v", err)
+		}
+		return response.Code, report
+	}
+
+	code, report := get()
+	if code != http.StatusOK || report.Status != health.StatusOK || len(report.Checks) != 2 {
+		t.Fatalf("Expected a passing report with two checks, got %d: %+v", code, report)
+	}
+
+	if report.Checks[0].Name != "reachable" || report.Checks[0].Status != health.StatusOK || report.Checks[0].LatencyMS < 0 {
+		t.Errorf("Unexpected result for the first check: %+v", report.Checks[0])
+	}
+
+	// The report is cached, so the failure doesn't show yet.
+	fail = failure
+	if code, _ := get(); code != http.StatusOK || runs != 1 {
+		t.Errorf("Expected the cached report, got %d after %d runs", code, runs)
+	}
+
+	checker.CacheFor = 0
+
+	code, report = get()
+	if code != http.StatusServiceUnavailable || report.Status != health.StatusFailing {
+		t.Fatalf("Expected a failing report, got %d: %+v", code, report)
+	}
+
+	if report.Checks[0].Status != health.StatusOK || report.Checks[1].Status != health.StatusFailing || report.Checks[1].Error != failure.Error() {
+		t.Errorf("Expected only the token check to fail, got %+v", report.Checks)
+	}
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	checker := &health.Checker{
+		Timeout: 10 * time.Millisecond,
+		Checks: []health.Check{
+			{Name: "slow", Run: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}},
+		},
+	}
+
+	report := checker.Report(context.Background())
+	if report.Status != health.StatusFailing || report.Checks[0].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Expected the slow check to time out, got %+v", report)
+	}
+}
+
+// EOF
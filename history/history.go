@@ -0,0 +1,451 @@
+// Package history keeps a record of the messages received, how they were
+// classified, where they were routed and whether they were delivered. It is
+// kept in memory, and when given a file also in that file, one JSON object
+// a line, so it survives restarts without needing a database.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// What became of a message for one notifier.
+const (
+	ResultDelivered = "delivered"
+	ResultFailed    = "failed" // queued messages are retried after this
+	ResultQueued    = "queued"
+	ResultDropped   = "dropped"   // the route says so
+	ResultHeldBack  = "held-back" // its link is flapping
+	ResultGivenUp   = "given-up"  // the queue stopped trying
+)
+
+// The defaults for how long, and how many, entries are kept.
+const (
+	DefaultMaxAge     = 30 * 24 * time.Hour
+	DefaultMaxEntries = 10000
+)
+
+// Entry is one message, as it was received and classified, along with what
+// happened to it since.
+type Entry struct {
+	ID         string    `json:"id"`
+	Received   time.Time `json:"received"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Endpoint   string    `json:"endpoint,omitempty"`
+	Controller string    `json:"controller"`
+	Site       string    `json:"site"`
+	Type       string    `json:"type"`
+	Priority   int       `json:"priority"`
+	Rule       string    `json:"rule,omitempty"`
+	Title      string    `json:"title"`
+	Body       string    `json:"body"`
+
+	// The message as it was received, with the shared secret masked; not
+	// there for messages this program made up itself (e.g. flap summaries).
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// Delivery is the latest outcome for one of the notifiers.
+type Delivery struct {
+	Notifier string    `json:"notifier"`
+	Route    string    `json:"route,omitempty"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+	Attempts int       `json:"attempts"`
+	At       time.Time `json:"at"`
+}
+
+// A line in the file: an entry, or a delivery of the entry with the ID.
+type record struct {
+	Entry    *Entry    `json:"entry,omitempty"`
+	ID       string    `json:"id,omitempty"`
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// Store holds the history. Its methods do nothing on a nil Store, so code
+// recording history doesn't have to care whether it's kept.
+type Store struct {
+	// Where the history is kept; in memory only when empty.
+	Path string
+
+	// Entries older than MaxAge are removed, as are the oldest ones when
+	// there are more than MaxEntries.
+	MaxAge     time.Duration
+	MaxEntries int
+
+	Logger *slog.Logger
+
+	mu      sync.RWMutex
+	entries []*Entry // oldest first
+	byID    map[string]*Entry
+	file    *os.File
+	records int // lines in the file
+}
+
+// New opens the history file when a path is given, loading the history
+// kept in it.
+func New(path string, logger *slog.Logger) (*Store, error) {
+	s := &Store{
+		Path:       path,
+		MaxAge:     DefaultMaxAge,
+		MaxEntries: DefaultMaxEntries,
+		Logger:     logger,
+		byID:       map[string]*Entry{},
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("could not create the history directory: %w", err)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the history file: %w", err)
+	}
+	s.file = file
+
+	if len(s.entries) > 0 {
+		logger.Info("Loaded the history", "entries", len(s.entries), "file", path)
+	}
+
+	return s, nil
+}
+
+// Add records a newly received message along with its payload (nil for a
+// message that wasn't received as such), giving it an ID.
+func (s *Store) Add(msg *omada.OmadaMessage, payload []byte) {
+	if s == nil {
+		return
+	}
+
+	msg.ID = newID()
+
+	entry := &Entry{
+		ID:         msg.ID,
+		Received:   time.Now().UTC(),
+		RequestID:  msg.RequestID,
+		Endpoint:   msg.Endpoint,
+		Controller: msg.Controller,
+		Site:       msg.Site,
+		Type:       msg.TypeName(),
+		Priority:   msg.Priority(),
+		Rule:       msg.RuleName(),
+		Title:      msg.Title(),
+		Body:       msg.Body(),
+		Deliveries: []Delivery{},
+	}
+
+	if json.Valid(payload) {
+		entry.Payload = json.RawMessage(omada.Sanitise(payload))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, entry)
+	s.byID[entry.ID] = entry
+
+	if s.MaxEntries > 0 && len(s.entries) > s.MaxEntries {
+		s.remove(len(s.entries) - s.MaxEntries)
+	}
+
+	s.write(record{Entry: entry})
+}
+
+func newID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// Record keeps the outcome for one notifier of the message with the ID;
+// the message may have been removed from the history since.
+func (s *Store) Record(id string, d Delivery) {
+	if s == nil || id == "" {
+		return
+	}
+
+	if d.At.IsZero() {
+		d.At = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.byID[id]
+	if !ok {
+		return
+	}
+
+	d = entry.apply(d)
+	s.write(record{ID: id, Delivery: &d})
+}
+
+// Keeps the delivery, counting the attempts; returns it as kept.
+func (e *Entry) apply(d Delivery) Delivery {
+	attempted := d.Result == ResultDelivered || d.Result == ResultFailed
+
+	for i, existing := range e.Deliveries {
+		if existing.Notifier != d.Notifier {
+			continue
+		}
+
+		if d.Route == "" {
+			d.Route = existing.Route
+		}
+
+		d.Attempts = existing.Attempts
+		if attempted {
+			d.Attempts++
+		}
+
+		e.Deliveries[i] = d
+		return d
+	}
+
+	if attempted {
+		d.Attempts = 1
+	}
+
+	e.Deliveries = append(e.Deliveries, d)
+	return d
+}
+
+// Get returns (a copy of) the entry with the ID.
+func (s *Store) Get(id string) (Entry, bool) {
+	if s == nil {
+		return Entry{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.byID[id]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return entry.copy(), true
+}
+
+func (e *Entry) copy() Entry {
+	c := *e
+	c.Deliveries = append([]Delivery{}, e.Deliveries...)
+	return c
+}
+
+// Run removes what is past its retention every interval, until the context
+// is cancelled.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	s.Prune(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.Prune(now)
+		}
+	}
+}
+
+// Prune removes the entries past their retention, and rewrites the file
+// when it holds much more than the history does.
+func (s *Store) Prune(now time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := 0
+	if s.MaxAge > 0 {
+		for old < len(s.entries) && now.Sub(s.entries[old].Received) > s.MaxAge {
+			old++
+		}
+	}
+	if s.MaxEntries > 0 {
+		old = max(old, len(s.entries)-s.MaxEntries)
+	}
+
+	s.remove(old)
+
+	// Every delivery adds a line, and removed entries leave theirs behind.
+	if s.file != nil && s.records > 2*len(s.entries)+100 {
+		if err := s.rewrite(); err != nil {
+			s.Logger.Error("Could not rewrite the history file", "error", err)
+		}
+	}
+}
+
+// Close closes the history file.
+func (s *Store) Close() error {
+	if s == nil || s.file == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// Removes the n oldest entries. Must be called with the lock held.
+func (s *Store) remove(n int) {
+	if n <= 0 {
+		return
+	}
+
+	for _, entry := range s.entries[:n] {
+		delete(s.byID, entry.ID)
+	}
+	s.entries = append([]*Entry{}, s.entries[n:]...)
+}
+
+// Appends a line to the file; the history in memory is kept even when
+// that fails. Must be called with the lock held.
+func (s *Store) write(r record) {
+	if s.file == nil {
+		return
+	}
+
+	data, err := json.Marshal(r)
+	if err == nil {
+		_, err = s.file.Write(append(data, '\n'))
+	}
+
+	if err != nil {
+		s.Logger.Error("Could not write to the history file", "error", err)
+		return
+	}
+
+	s.records++
+}
+
+// Writes the history as it is to a new file, which then replaces the old
+// one. Must be called with the lock held.
+func (s *Store) rewrite() error {
+	tmp := s.Path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, entry := range s.entries {
+		if err := encoder.Encode(record{Entry: entry}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := errors.Join(w.Flush(), file.Sync(), file.Close()); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, s.Path); err != nil {
+		return err
+	}
+
+	s.file.Close()
+	s.file, err = os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND, 0o600)
+	s.records = len(s.entries)
+	return err
+}
+
+// Reads the file, replaying the deliveries onto their entries. A line that
+// can't be read (say, the last one after a crash) is skipped, however long
+// it is; and should reading fail halfway, what was read is kept. The history
+// is never a reason not to start.
+func (s *Store) load() error {
+	file, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read the history file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			s.records++
+			s.replay(line)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			s.Logger.Warn("Could not read the rest of the history file, keeping what was read", "lines", s.records, "error", err)
+			return nil
+		}
+	}
+}
+
+// Takes in one line of the file.
+func (s *Store) replay(line []byte) {
+	var r record
+	if err := json.Unmarshal(line, &r); err != nil {
+		s.Logger.Warn("Skipping an unreadable line in the history file", "line", s.records, "error", err)
+		return
+	}
+
+	switch {
+	case r.Entry != nil:
+		if r.Entry.Deliveries == nil {
+			r.Entry.Deliveries = []Delivery{}
+		}
+		s.entries = append(s.entries, r.Entry)
+		s.byID[r.Entry.ID] = r.Entry
+	case r.Delivery != nil:
+		if entry, ok := s.byID[r.ID]; ok {
+			replaceDelivery(entry, *r.Delivery)
+		}
+	}
+}
+
+// Deliveries in the file are stored as they were kept, attempts included.
+func replaceDelivery(e *Entry, d Delivery) {
+	for i, existing := range e.Deliveries {
+		if existing.Notifier == d.Notifier {
+			e.Deliveries[i] = d
+			return
+		}
+	}
+	e.Deliveries = append(e.Deliveries, d)
+}
+
+// EOF
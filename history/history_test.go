@@ -0,0 +1,270 @@
+package history_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+func message(controller, site, text string) *omada.OmadaMessage {
+	return &omada.OmadaMessage{Controller: controller, Site: site, Text: []string{text}, Timestamp: 1758852904877}
+}
+
+const offline = "[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline."
+
+func TestStore(t *testing.T) {
+	logger := testLogger(t)
+
+	path := filepath.Join(t.TempDir(), "history", "history.jsonl")
+
+	store, err := history.New(path, logger)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	msg := message("Controller", "Home", offline)
+	msg.RequestID = "request-1"
+	store.Add(msg, []byte(`{"Controller":"Controller","shardSecret":"vewySecwet"}`))
+
+	if msg.ID == "" {
+		t.Fatal("Expected the message to get an ID")
+	}
+
+	store.Record(msg.ID, history.Delivery{Notifier: "gotify", Route: "WAN", Result: history.ResultQueued})
+	store.Record(msg.ID, history.Delivery{Notifier: "gotify", Result: history.ResultFailed, Error: "down"})
+	store.Record(msg.ID, history.Delivery{Notifier: "gotify", Result: history.ResultDelivered})
+	store.Record(msg.ID, history.Delivery{Notifier: "ntfy", Result: history.ResultDelivered})
+	store.Record("unknown", history.Delivery{Notifier: "gotify", Result: history.ResultDelivered})
+	store.Close()
+
+	// Everything is read back from the file.
+	reopened, err := history.New(path, logger)
+	if err != nil {
+		t.Fatalf("New() failed to reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	entry, ok := reopened.Get(msg.ID)
+	if !ok {
+		t.Fatalf("Expected the entry to be kept in the file")
+	}
+
+	if entry.Type != "offline" || entry.Priority != msg.Priority() || entry.Title != msg.Title() || entry.RequestID != "request-1" {
+		t.Errorf("Expected the classification to be kept, got %+v", entry)
+	}
+
+	if strings.Contains(string(entry.Payload), "vewySecwet") || !json.Valid(entry.Payload) {
+		t.Errorf("Expected the payload without its secret, got %s", entry.Payload)
+	}
+
+	if len(entry.Deliveries) != 2 {
+		t.Fatalf("Expected a delivery for each notifier, got %+v", entry.Deliveries)
+	}
+
+	gotify := entry.Deliveries[0]
+	if gotify.Result != history.ResultDelivered || gotify.Route != "WAN" || gotify.Attempts != 2 || gotify.Error != "" {
+		t.Errorf("Expected the second attempt to be delivered by the WAN route, got %+v", gotify)
+	}
+}
+
+// However long a line is, or however broken, the history is read all the
+// same; it is never a reason not to start.
+func TestStore_LongLines(t *testing.T) {
+	logger := testLogger(t)
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := history.New(path, logger)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	large := message("Controller", "Home", offline)
+	store.Add(large, []byte(`{"text":["`+strings.Repeat("x", 5*1024*1024)+`"]}`))
+
+	small := message("Controller", "Office", offline)
+	store.Add(small, []byte(`{}`))
+	store.Close()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatalf("Could not open the history file: %v", err)
+	}
+	file.WriteString(`{"entry":{"id":"` + strings.Repeat("y", 5*1024*1024))
+	file.Close()
+
+	reopened, err := history.New(path, logger)
+	if err != nil {
+		t.Fatalf("New() failed to reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	for _, id := range []string{large.ID, small.ID} {
+		if _, ok := reopened.Get(id); !ok {
+			t.Errorf("Expected entry %v to be read back", id)
+		}
+	}
+}
+
+func TestStore_Query(t *testing.T) {
+	store, _ := history.New("", slog.Default())
+
+	store.Add(message("Controller", "Home", offline), nil)
+	store.Add(message("Controller", "Office", offline), nil)
+	store.Add(message("Other", "Home", "Something happened."), nil)
+
+	priority := message("", "", offline).Priority()
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string // the sites, newest first
+	}{
+		{"Everything", "", []string{"Home", "Office", "Home"}},
+		{"Controller", "controller=controller", []string{"Office", "Home"}},
+		{"Site", "site=Home", []string{"Home", "Home"}},
+		{"Type", "type=offline&site=office", []string{"Office"}},
+		{"Priority", "min_priority=" + strconv.Itoa(priority), []string{"Office", "Home"}},
+		{"Exact priority", "priority=" + strconv.Itoa(priority+1), []string{}},
+		{"Limit", "limit=1", []string{"Home"}},
+		{"Since", "since=1h", []string{"Home", "Office", "Home"}},
+		{"Until", "until=2020-01-01T00:00:00Z", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			q, err := history.ParseQuery(values, time.Now())
+			if err != nil {
+				t.Fatalf("ParseQuery() failed: %v", err)
+			}
+
+			got := []string{}
+			for _, entry := range store.Query(q) {
+				got = append(got, entry.Site)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	for _, query := range []string{"since=yesterday", "priority=high", "limit=0", "limit=5000"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := history.ParseQuery(values, time.Now()); err == nil {
+			t.Errorf("Expected %q to be refused", query)
+		}
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	store, err := history.New(path, slog.Default())
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer store.Close()
+
+	store.MaxEntries = 3
+
+	ids := []string{}
+	for range 5 {
+		msg := message("Controller", "Home", offline)
+		store.Add(msg, nil)
+		ids = append(ids, msg.ID)
+	}
+
+	if _, ok := store.Get(ids[1]); ok {
+		t.Errorf("Expected the oldest entries to make room for new ones")
+	}
+
+	// A day from now, all of them are more than a day old.
+	store.MaxAge = 24 * time.Hour
+	store.Prune(time.Now().Add(25 * time.Hour))
+
+	if len(store.Query(history.Query{})) != 0 {
+		t.Errorf("Expected the entries past their age to be removed")
+	}
+
+	// The file is only rewritten once it's grown well past the history.
+	for range 200 {
+		store.Add(message("Controller", "Home", offline), nil)
+	}
+	store.Prune(time.Now())
+
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("Expected the file to be rewritten with the 3 entries kept, got %d lines", lines)
+	}
+
+	// Entries are added after a rewrite, too.
+	store.Add(message("Controller", "Home", offline), nil)
+
+	data, _ = os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("Expected 4 lines after adding another entry, got %d", lines)
+	}
+}
+
+func TestStore_Handler(t *testing.T) {
+	store, _ := history.New("", slog.Default())
+
+	msg := message("Controller", "Home", offline)
+	store.Add(msg, nil)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/history", store.Handler())
+	mux.Handle("GET /api/history/{id}", store.Handler())
+
+	get := func(target string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, httptest.NewRequest(http.MethodGet, target, nil))
+		return response
+	}
+
+	response := get("/api/history?site=home")
+	var list struct {
+		Count   int             `json:"count"`
+		Entries []history.Entry `json:"entries"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("Expected a list of entries, got %d: %s", response.Code, response.Body)
+	}
+
+	if list.Count != 1 || list.Entries[0].ID != msg.ID {
+		t.Errorf("Expected the entry, got %+v", list)
+	}
+
+	if response := get("/api/history/" + msg.ID); response.Code != http.StatusOK || !strings.Contains(response.Body.String(), msg.ID) {
+		t.Errorf("Expected the entry, got %d: %s", response.Code, response.Body)
+	}
+
+	if response := get("/api/history/unknown"); response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown entry not to be found, got %d", response.Code)
+	}
+
+	if response := get("/api/history?limit=none"); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid query to be refused, got %d", response.Code)
+	}
+}
+
+// A logger for the tests, writing to the output of the test so the log
+// shows up along with any failures (and with -v).
+func testLogger(t *testing.T) *slog.Logger {
+	return slog.New(slog.NewTextHandler(t.Output(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// EOF
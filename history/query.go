@@ -0,0 +1,183 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The number of entries returned unless asked otherwise, and the most that
+// can be asked for.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Query selects entries; empty fields select everything. Controller and
+// site are compared without regard to case.
+type Query struct {
+	Since, Until time.Time
+	Controller   string
+	Site         string
+	Type         string
+	MinPriority  *int
+	MaxPriority  *int
+
+	// At most this many entries are returned, the newest ones.
+	Limit int
+}
+
+// Matches tells whether the query selects the entry; the limit is left to
+// whoever is going through the entries.
+func (q Query) Matches(e *Entry) bool {
+	switch {
+	case !q.Since.IsZero() && e.Received.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && e.Received.After(q.Until):
+		return false
+	case q.Controller != "" && !strings.EqualFold(q.Controller, e.Controller):
+		return false
+	case q.Site != "" && !strings.EqualFold(q.Site, e.Site):
+		return false
+	case q.Type != "" && q.Type != e.Type:
+		return false
+	case q.MinPriority != nil && e.Priority < *q.MinPriority:
+		return false
+	case q.MaxPriority != nil && e.Priority > *q.MaxPriority:
+		return false
+	}
+	return true
+}
+
+// Query returns (copies of) the entries selected, newest first.
+func (s *Store) Query(q Query) []Entry {
+	result := []Entry{}
+	if s == nil {
+		return result
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.entries) - 1; i >= 0 && len(result) < limit; i-- {
+		if q.Matches(s.entries[i]) {
+			result = append(result, s.entries[i].copy())
+		}
+	}
+
+	return result
+}
+
+// ParseQuery reads a query from URL parameters: since and until (a time as
+// in 2025-09-26T04:15:00Z, or a duration such as 24h meaning that long ago),
+// controller, site, type, priority (exactly), min_priority, max_priority
+// and limit.
+func ParseQuery(values url.Values, now time.Time) (Query, error) {
+	q := Query{
+		Controller: values.Get("controller"),
+		Site:       values.Get("site"),
+		Type:       values.Get("type"),
+	}
+
+	var err error
+	if q.Since, err = parseTime(values.Get("since"), now); err != nil {
+		return Query{}, fmt.Errorf("since: %w", err)
+	}
+	if q.Until, err = parseTime(values.Get("until"), now); err != nil {
+		return Query{}, fmt.Errorf("until: %w", err)
+	}
+
+	for _, p := range []struct {
+		name   string
+		target []**int
+	}{
+		{"priority", []**int{&q.MinPriority, &q.MaxPriority}},
+		{"min_priority", []**int{&q.MinPriority}},
+		{"max_priority", []**int{&q.MaxPriority}},
+	} {
+		value := values.Get(p.name)
+		if value == "" {
+			continue
+		}
+
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return Query{}, fmt.Errorf("%v must be a whole number, not %q", p.name, value)
+		}
+		for _, target := range p.target {
+			*target = &priority
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return Query{}, fmt.Errorf("limit must be a number from 1 to %d, not %q", MaxLimit, value)
+		}
+		q.Limit = limit
+	}
+
+	return q, nil
+}
+
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a time (like 2025-09-26T04:15:00Z) nor a duration (like 24h)", value)
+	}
+	return t, nil
+}
+
+// Handler serves the history as JSON: GET on the path itself queries it
+// (see ParseQuery), GET on path/<id> returns one entry.
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if id := r.PathValue("id"); id != "" {
+			entry, ok := s.Get(id)
+			if !ok {
+				http.Error(w, "Not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, entry)
+			return
+		}
+
+		q, err := ParseQuery(r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries := s.Query(q)
+		writeJSON(w, struct {
+			Count   int     `json:"count"`
+			Entries []Entry `json:"entries"`
+		}{len(entries), entries})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// EOF
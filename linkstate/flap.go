@@ -0,0 +1,292 @@
+package linkstate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// FlapDetector keeps an unstable link from flooding the phones with offline
+// and online notifications. When a link changes state more than Threshold
+// times within Window it is considered to be flapping: a single "link is
+// flapping" message is sent, and the offline/online messages for that link
+// are held back. Once the link has not changed state for Settle, a summary
+// is sent with the number of changes and the state it ended up in.
+type FlapDetector struct {
+	Threshold int
+	Window    time.Duration
+	Settle    time.Duration
+
+	// Called with the messages the detector generates itself, and the
+	// context to deliver them in.
+	Notify func(ctx context.Context, msg *omada.OmadaMessage)
+	Logger *slog.Logger
+
+	mu    sync.Mutex
+	links map[Key]*flapState
+}
+
+type flapState struct {
+	device      omada.Device
+	state       omada.OmadaMessageType
+	changes     []time.Time // recent changes of state, within the window
+	last        omada.OmadaMessage
+	lastChange  time.Time
+	flapping    bool
+	flapSince   time.Time
+	flapChanges int
+}
+
+// A link that is currently flapping.
+type FlappingLink struct {
+	Key     Key       `json:"key"`
+	Since   time.Time `json:"since"`
+	Changes int       `json:"changes"`
+}
+
+func NewFlapDetector(threshold int, window, settle time.Duration, notify func(context.Context, *omada.OmadaMessage), logger *slog.Logger) *FlapDetector {
+	return &FlapDetector{
+		Threshold: threshold,
+		Window:    window,
+		Settle:    settle,
+		Notify:    notify,
+		Logger:    logger,
+		links:     map[Key]*flapState{},
+	}
+}
+
+// Observe takes note of offline and online messages, and returns whether
+// the message should be delivered (false while its link is flapping).
+func (d *FlapDetector) Observe(msg *omada.OmadaMessage) bool {
+	msgType := msg.Type()
+	if msgType != omada.OmadaOfflineMessage && msgType != omada.OmadaOnlineMessage {
+		return true
+	}
+
+	key, ok := KeyFor(msg)
+	if !ok {
+		return true
+	}
+
+	deliver, flapping := d.observe(key, msgType, msg)
+
+	// Notify outside of the lock, delivery can take a while.
+	if flapping != nil {
+		d.notify(context.Background(), flapping)
+	}
+
+	return deliver
+}
+
+func (d *FlapDetector) observe(key Key, msgType omada.OmadaMessageType, msg *omada.OmadaMessage) (bool, *omada.OmadaMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st, known := d.links[key]
+	if !known {
+		device, _ := msg.Entities().Device()
+		st = &flapState{device: device}
+		d.links[key] = st
+	}
+
+	st.last = *msg
+
+	// Omada may repeat itself; only a different state is a change.
+	if known && st.state == msgType {
+		return !st.flapping, nil
+	}
+
+	now := time.Now()
+	st.state = msgType
+	st.lastChange = now
+
+	if st.flapping {
+		st.flapChanges++
+		return false, nil
+	}
+
+	st.changes = append(st.changes, now)
+	for len(st.changes) > 0 && now.Sub(st.changes[0]) > d.Window {
+		st.changes = st.changes[1:]
+	}
+
+	if len(st.changes) <= d.Threshold {
+		return true, nil
+	}
+
+	st.flapping = true
+	st.flapSince = st.changes[0]
+	st.flapChanges = len(st.changes)
+
+	logger := logging.WithRequestID(d.Logger, msg.RequestID)
+	logger.Warn("A link is flapping, holding back its messages until it settles", "link", key)
+
+	return false, d.flappingMessage(key, st)
+}
+
+// Run checks for flapping links that have settled down until the context
+// is cancelled. A summary being sent at that moment is allowed to finish.
+func (d *FlapDetector) Run(ctx context.Context) {
+	notifyCtx := context.WithoutCancel(ctx)
+
+	ticker := time.NewTicker(max(d.Settle/4, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.check(notifyCtx, now)
+		}
+	}
+}
+
+func (d *FlapDetector) check(ctx context.Context, now time.Time) {
+	d.mu.Lock()
+	settled := []*omada.OmadaMessage{}
+
+	for key, st := range d.links {
+		if st.flapping && now.Sub(st.lastChange) >= d.Settle {
+			d.Logger.Info("A flapping link has settled", "link", key, "changes", st.flapChanges)
+			settled = append(settled, d.settledMessage(key, st, now))
+			delete(d.links, key)
+			continue
+		}
+
+		// Links which haven't changed for a while are of no interest anymore.
+		if !st.flapping && now.Sub(st.lastChange) > d.Window {
+			delete(d.links, key)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, msg := range settled {
+		d.notify(ctx, msg)
+	}
+}
+
+// Flush sends a summary for each link which is flapping right now, for
+// when the program is about to stop; the summary it would send once the
+// link settles would be lost otherwise, along with the state the link is
+// in. Run must have returned already. The summaries are sent in the given
+// context, so they can't hold up the program stopping for longer than that.
+func (d *FlapDetector) Flush(ctx context.Context) {
+	d.mu.Lock()
+	pending := []*omada.OmadaMessage{}
+
+	for key, st := range d.links {
+		if st.flapping {
+			d.Logger.Info("Sending the summary of a link that is still flapping", "link", key, "changes", st.flapChanges)
+			pending = append(pending, d.unsettledMessage(key, st, time.Now()))
+		}
+		delete(d.links, key)
+	}
+	d.mu.Unlock()
+
+	for _, msg := range pending {
+		d.notify(ctx, msg)
+	}
+}
+
+// The links currently flapping, the longest flapping first.
+func (d *FlapDetector) Flapping() []FlappingLink {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	links := []FlappingLink{}
+	for key, st := range d.links {
+		if st.flapping {
+			links = append(links, FlappingLink{Key: key, Since: st.flapSince, Changes: st.flapChanges})
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Since.Before(links[j].Since)
+	})
+
+	return links
+}
+
+func (d *FlapDetector) notify(ctx context.Context, msg *omada.OmadaMessage) {
+	if d.Notify != nil {
+		d.Notify(ctx, msg)
+	}
+}
+
+// The generated messages are written the way Omada writes its messages, so
+// they read (and can be matched by rules) like any other. Their type is set
+// rather than found in the text, which anyone could send.
+
+func (d *FlapDetector) flappingMessage(key Key, st *flapState) *omada.OmadaMessage {
+	text := fmt.Sprintf("%v is flapping, it changed state %d times in %v; further changes are held back until it settles.",
+		linkName(key, st.device), st.flapChanges, roundDuration(d.Window))
+
+	return d.generated(st, omada.LinkFlappingMessage, text, time.Now())
+}
+
+func (d *FlapDetector) settledMessage(key Key, st *flapState, now time.Time) *omada.OmadaMessage {
+	text := fmt.Sprintf("%v has settled and is %v, it changed state %d times in %v.",
+		linkName(key, st.device), stateName(st.state), st.flapChanges, roundDuration(st.lastChange.Sub(st.flapSince)))
+
+	return d.generated(st, omada.LinkSettledMessage, text, now)
+}
+
+func (d *FlapDetector) unsettledMessage(key Key, st *flapState, now time.Time) *omada.OmadaMessage {
+	text := fmt.Sprintf("%v is still flapping and was last %v, it changed state %d times in %v; its further changes won't be known until omada-to-gotify runs again.",
+		linkName(key, st.device), stateName(st.state), st.flapChanges, roundDuration(st.lastChange.Sub(st.flapSince)))
+
+	return d.generated(st, omada.LinkFlappingMessage, text, now)
+}
+
+func (d *FlapDetector) generated(st *flapState, t omada.OmadaMessageType, text string, at time.Time) *omada.OmadaMessage {
+	msg := &omada.OmadaMessage{
+		Controller: st.last.Controller,
+		Site:       st.last.Site,
+		Text:       []string{text},
+		Timestamp:  at.UnixMilli(),
+		Endpoint:   st.last.Endpoint,
+	}
+	msg.SetType(t)
+	return msg
+}
+
+func stateName(state omada.OmadaMessageType) string {
+	if state == omada.OmadaOfflineMessage {
+		return "offline"
+	}
+	return "online"
+}
+
+// e.g. `[gateway:98-03-8E-3A-8D-53]: [2.5G WAN1]`
+func linkName(key Key, device omada.Device) string {
+	parts := []string{}
+
+	if device.Role != "" && device.MAC != "" {
+		parts = append(parts, fmt.Sprintf("[%v:%v]:", device.Role, device.MAC))
+	}
+
+	if key.Interface != "" {
+		parts = append(parts, fmt.Sprintf("[%v]", key.Interface))
+	} else {
+		parts = append(parts, "[link]")
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Second)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// EOF
@@ -0,0 +1,162 @@
+package linkstate_test
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/linkstate"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+type notifications struct {
+	mu       sync.Mutex
+	messages []*omada.OmadaMessage
+	contexts []context.Context
+}
+
+func (n *notifications) notify(ctx context.Context, msg *omada.OmadaMessage) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, msg)
+	n.contexts = append(n.contexts, ctx)
+}
+
+// The context the nth message was sent in.
+func (n *notifications) context(i int) context.Context {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.contexts[i]
+}
+
+func (n *notifications) get() []*omada.OmadaMessage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*omada.OmadaMessage{}, n.messages...)
+}
+
+func TestFlapDetector(t *testing.T) {
+	var (
+		logger = testLogger(t)
+		sent   = &notifications{}
+	)
+
+	detector := linkstate.NewFlapDetector(3, time.Minute, 50*time.Millisecond, sent.notify, logger)
+
+	states := []string{"offline", "online", "offline", "online", "offline", "online"}
+	delivered := []bool{}
+
+	for i, state := range states {
+		delivered = append(delivered, detector.Observe(wanMessage("Home", "2.5G WAN1", state, int64(1758852904877+i))))
+	}
+
+	// A repeated message while flapping is held back too
+	delivered = append(delivered, detector.Observe(wanMessage("Home", "2.5G WAN1", "online", 1758852904900)))
+
+	want := []bool{true, true, true, false, false, false, false}
+	for i := range want {
+		if delivered[i] != want[i] {
+			t.Errorf("Message %d: delivered is %v, want %v", i, delivered[i], want[i])
+		}
+	}
+
+	// Other links and other messages are not affected
+	if !detector.Observe(wanMessage("Home", "WAN2", "offline", 1758852904877)) {
+		t.Error("A message for another link was held back")
+	}
+
+	if !detector.Observe(&omada.OmadaMessage{Text: []string{"Something else"}}) {
+		t.Error("An unrelated message was held back")
+	}
+
+	got := sent.get()
+	if len(got) != 1 {
+		t.Fatalf("Expected only the flapping message to be sent, got %d messages", len(got))
+	}
+
+	if got[0].Type() != omada.LinkFlappingMessage || !strings.Contains(got[0].Body(), "changed state 4 times") {
+		t.Errorf("Unexpected flapping message %v: %q", got[0].Type(), got[0].Body())
+	}
+
+	if entities := got[0].Entities(); entities.Port() != "2.5G WAN1" {
+		t.Errorf("The flapping message does not mention the port: %+v", entities)
+	}
+
+	flapping := detector.Flapping()
+	if len(flapping) != 1 || flapping[0].Key.Interface != "2.5G WAN1" || flapping[0].Changes != 6 {
+		t.Errorf("Unexpected flapping links: %+v", flapping)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go detector.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sent.get()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the link to settle")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	settled := sent.get()[1]
+	if settled.Type() != omada.LinkSettledMessage {
+		t.Errorf("Expected a settled message, got %v", settled.Type())
+	}
+
+	if body := settled.Body(); !strings.Contains(body, "is online, it changed state 6 times") {
+		t.Errorf("Unexpected settled message: %q", body)
+	}
+
+	if len(detector.Flapping()) != 0 {
+		t.Errorf("The link is still flapping: %+v", detector.Flapping())
+	}
+
+	// Once settled, messages are delivered again
+	if !detector.Observe(wanMessage("Home", "2.5G WAN1", "offline", 1758852905877)) {
+		t.Error("A message was held back after the link settled")
+	}
+}
+
+func TestFlapDetector_Flush(t *testing.T) {
+	sent := &notifications{}
+	detector := linkstate.NewFlapDetector(2, time.Minute, time.Hour, sent.notify, slog.New(slog.DiscardHandler))
+
+	for i, state := range []string{"offline", "online", "offline", "online", "offline"} {
+		detector.Observe(wanMessage("Home", "2.5G WAN1", state, int64(1758852904877+i)))
+	}
+
+	// Not flapping, so there's nothing to tell about it.
+	detector.Observe(wanMessage("Home", "WAN2", "offline", 1758852904877))
+
+	if len(sent.get()) != 1 {
+		t.Fatalf("Expected only the flapping message to be sent, got %d messages", len(sent.get()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	detector.Flush(ctx)
+
+	got := sent.get()
+	if len(got) != 2 {
+		t.Fatalf("Expected Flush() to send a summary of the flapping link, got %d messages", len(got))
+	}
+
+	if got[1].Type() != omada.LinkFlappingMessage || !strings.Contains(got[1].Body(), "[2.5G WAN1] is still flapping and was last offline") {
+		t.Errorf("Unexpected summary %v: %q", got[1].Type(), got[1].Body())
+	}
+
+	if sent.context(1) != ctx {
+		t.Error("Expected the summary to be sent in the context given to Flush()")
+	}
+
+	if len(detector.Flapping()) != 0 {
+		t.Errorf("Links are still flapping after Flush(): %+v", detector.Flapping())
+	}
+}
+
+// EOF
@@ -0,0 +1,135 @@
+package linkstate
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// Identifies a link: the interface of a device at a site of a controller
+// (behind a webhook endpoint). Either the MAC or the interface can be empty
+// when the message doesn't mention it, but never both.
+type Key struct {
+	Endpoint   string `json:"endpoint,omitempty"`
+	Controller string `json:"controller"`
+	Site       string `json:"site"`
+	MAC        string `json:"mac"`
+	Interface  string `json:"interface"`
+}
+
+// Logged as a group of its fields, leaving out the empty ones.
+func (k Key) LogValue() slog.Value {
+	attrs := []slog.Attr{}
+	for _, field := range []struct{ name, value string }{
+		{"endpoint", k.Endpoint}, {"controller", k.Controller}, {"site", k.Site}, {"mac", k.MAC}, {"interface", k.Interface},
+	} {
+		if field.value != "" {
+			attrs = append(attrs, slog.String(field.name, field.value))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// KeyFor works out which link the message is about, using the first device
+// and port mentioned in it.
+func KeyFor(msg *omada.OmadaMessage) (Key, bool) {
+	entities := msg.Entities()
+	device, _ := entities.Device()
+
+	key := Key{
+		Endpoint:   msg.Endpoint,
+		Controller: msg.Controller,
+		Site:       msg.Site,
+		MAC:        device.MAC,
+		Interface:  entities.Port(),
+	}
+
+	return key, key.MAC != "" || key.Interface != ""
+}
+
+// A link that is currently down, and since when.
+type DownLink struct {
+	Key   Key       `json:"key"`
+	Since time.Time `json:"since"`
+}
+
+// OutageTracker pairs up offline and online messages for the same link.
+// Offline messages are remembered, and when the online message for that
+// link comes in it gets the details of the outage attached to it, so the
+// notification can say how long the link was down.
+//
+// State is only kept in memory; an outage spanning a restart of this
+// program is reported without its duration.
+type OutageTracker struct {
+	Logger *slog.Logger
+
+	mu   sync.Mutex
+	down map[Key]time.Time
+}
+
+func NewOutageTracker(logger *slog.Logger) *OutageTracker {
+	return &OutageTracker{
+		Logger: logger,
+		down:   map[Key]time.Time{},
+	}
+}
+
+// Observe records the message when it reports a link going offline, and
+// attaches the outage to it when it reports one coming back online.
+func (t *OutageTracker) Observe(msg *omada.OmadaMessage) {
+	msgType := msg.Type()
+	if msgType != omada.OmadaOfflineMessage && msgType != omada.OmadaOnlineMessage {
+		return
+	}
+
+	key, ok := KeyFor(msg)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if msgType == omada.OmadaOfflineMessage {
+		// Omada may repeat itself while the link stays down; the outage
+		// started with the first message.
+		if _, known := t.down[key]; !known {
+			t.down[key] = msg.Date()
+		}
+		return
+	}
+
+	start, known := t.down[key]
+	if !known {
+		return
+	}
+
+	delete(t.down, key)
+
+	msg.Outage = &omada.Outage{Start: start, End: msg.Date()}
+	logger := logging.WithRequestID(t.Logger, msg.RequestID)
+	logger.Info("A link is back up", "link", key, "down_for", msg.Outage.Duration().Round(time.Second).String())
+}
+
+// The links currently known to be down, the longest down first.
+func (t *OutageTracker) Down() []DownLink {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	links := make([]DownLink, 0, len(t.down))
+	for key, since := range t.down {
+		links = append(links, DownLink{Key: key, Since: since})
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Since.Before(links[j].Since)
+	})
+
+	return links
+}
+
+// EOF
@@ -0,0 +1,82 @@
+package linkstate_test
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/linkstate"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+func wanMessage(site string, port string, state string, timestamp int64) *omada.OmadaMessage {
+	return &omada.OmadaMessage{
+		Controller: "Omada Controller_347044",
+		Site:       site,
+		Text:       []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [" + port + "] was " + state + ".\r"},
+		Timestamp:  timestamp,
+	}
+}
+
+func TestOutageTracker(t *testing.T) {
+	logger := testLogger(t)
+
+	tracker := linkstate.NewOutageTracker(logger)
+
+	const start = int64(1758852904877)
+
+	tracker.Observe(wanMessage("Home", "2.5G WAN1", "offline", start))
+	// A repeated offline message must not move the start of the outage
+	tracker.Observe(wanMessage("Home", "2.5G WAN1", "offline", start+60_000))
+	tracker.Observe(wanMessage("Home", "WAN2", "offline", start+1_000))
+
+	down := tracker.Down()
+	if len(down) != 2 {
+		t.Fatalf("Expected 2 links to be down, got %+v", down)
+	}
+
+	if down[0].Key.Interface != "2.5G WAN1" || !down[0].Since.Equal(time.UnixMilli(start)) {
+		t.Errorf("Expected WAN1 to be down the longest, got %+v", down[0])
+	}
+
+	// Same port, but at another site: not the same link
+	other := wanMessage("Office", "2.5G WAN1", "online", start+120_000)
+	tracker.Observe(other)
+	if other.Outage != nil {
+		t.Errorf("An online message for another site got an outage: %+v", other.Outage)
+	}
+
+	online := wanMessage("Home", "2.5G WAN1", "online", start+754_000)
+	tracker.Observe(online)
+
+	if online.Outage == nil {
+		t.Fatal("The online message did not get the outage attached")
+	}
+
+	if got := online.Outage.Duration(); got != 754*time.Second {
+		t.Errorf("Expected the outage to have lasted 12m34s, got %v", got)
+	}
+
+	if !online.Outage.Start.Equal(time.UnixMilli(start)) {
+		t.Errorf("Expected the outage to start at the first offline message, got %v", online.Outage.Start)
+	}
+
+	if len(tracker.Down()) != 1 {
+		t.Errorf("Expected only WAN2 to still be down, got %+v", tracker.Down())
+	}
+
+	// Coming online a second time isn't the end of another outage
+	again := wanMessage("Home", "2.5G WAN1", "online", start+800_000)
+	tracker.Observe(again)
+	if again.Outage != nil {
+		t.Errorf("A repeated online message got an outage: %+v", again.Outage)
+	}
+}
+
+// A logger for the tests, writing to the output of the test so the log
+// shows up along with any failures (and with -v).
+func testLogger(t *testing.T) *slog.Logger {
+	return slog.New(slog.NewTextHandler(t.Output(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// EOF
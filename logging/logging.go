@@ -0,0 +1,71 @@
+// Package logging sets up the structured logger used throughout this
+// program, writing either JSON (the default) or plain text, at a level that
+// can be changed while the program runs.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// The formats a log can be written in.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// The attribute connecting everything logged about one webhook request.
+const RequestIDKey = "request_id"
+
+// ParseLevel turns debug, info, warn or error (in any case) into a level;
+// without a level, info is assumed.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", level)
+}
+
+// New returns a logger writing to w in the given format, leaving out what
+// is below the level. Set the level through a slog.LevelVar to be able to
+// change it later.
+func New(w io.Writer, format string, level slog.Leveler) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+
+	if format == FormatText {
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+// WithRequestID returns a logger adding the request ID to everything it
+// logs; without an ID (say, for a message that didn't come in through a
+// webhook) it returns the logger itself.
+func WithRequestID(logger *slog.Logger, id string) *slog.Logger {
+	if id == "" {
+		return logger
+	}
+	return logger.With(RequestIDKey, id)
+}
+
+// NewRequestID returns a random ID for a request which didn't bring one.
+func NewRequestID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// EOF
@@ -0,0 +1,91 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/logging"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"", slog.LevelInfo, false},
+		{"debug", slog.LevelDebug, false},
+		{"INFO", slog.LevelInfo, false},
+		{"Warn", slog.LevelWarn, false},
+		{"warning", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", slog.LevelInfo, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			got, err := logging.ParseLevel(tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+
+	level := new(slog.LevelVar)
+	logger := logging.New(&buf, logging.FormatJSON, level)
+
+	logger.Debug("Not shown")
+	logging.WithRequestID(logger, "abc").Info("Shown")
+	logging.WithRequestID(logger, "").Info("Without an ID")
+
+	level.Set(slog.LevelDebug)
+	logger.Debug("Shown now")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", buf.String())
+	}
+
+	for i, want := range []map[string]any{
+		{"msg": "Shown", logging.RequestIDKey: "abc"},
+		{"msg": "Without an ID"},
+		{"msg": "Shown now"},
+	} {
+		entry := map[string]any{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("Expected JSON, got %q: %v", lines[i], err)
+		}
+
+		if entry["msg"] != want["msg"] || entry[logging.RequestIDKey] != want[logging.RequestIDKey] {
+			t.Errorf("Expected %v, got %q", want, lines[i])
+		}
+	}
+
+	buf.Reset()
+	logging.New(&buf, logging.FormatText, slog.LevelInfo).Info("Plain", "key", "value")
+
+	if !strings.Contains(buf.String(), `msg=Plain key=value`) {
+		t.Errorf("Expected text, got %q", buf.String())
+	}
+}
+
+func TestNewRequestID(t *testing.T) {
+	first, second := logging.NewRequestID(), logging.NewRequestID()
+
+	if len(first) != 16 || first == second {
+		t.Errorf("Expected two different IDs of 16 characters, got %q and %q", first, second)
+	}
+}
+
+// EOF
@@ -1,65 +1,980 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
-	"log"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/leeft/omada-to-gotify/config"
+	"github.com/leeft/omada-to-gotify/dashboard"
 	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/health"
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/linkstate"
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/metrics"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
+	"github.com/leeft/omada-to-gotify/replay"
+	"github.com/leeft/omada-to-gotify/tlsconfig"
 	"github.com/leeft/omada-to-gotify/webhook"
 )
 
 var version = "development"
 
+// The exit codes, besides 0 for a clean shutdown.
+const (
+	exitFailure         = 1 // could not start, or the server failed
+	exitShutdownTimeout = 2 // stopped before requests and deliveries were done
+)
+
+// What is printed for -h, or an unknown command.
+const usage = `Usage: omada-to-gotify [-config file] [command] [flags]
+
+Commands:
+  serve            receive webhooks and deliver them (the default)
+  send-test        send a test message to Gotify, to check its URL and token
+  validate-config  check the configuration, along with its rules, routes and endpoints
+  parse            show how a message (in a file, or on standard input) is classified and routed
+  replay           run messages received earlier through classification and routing again
+
+Run omada-to-gotify <command> -h for the flags of the command.
+`
+
 func main() {
-	logger := log.Default()
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	flag.Parse()
+
+	// Without a command, the program serves; as it always has.
+	command, args := "serve", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		os.Exit(serveCommand(args))
+	case "send-test":
+		os.Exit(sendTestCommand(args))
+	case "validate-config":
+		os.Exit(validateConfigCommand(args))
+	case "parse":
+		os.Exit(parseCommand(args))
+	case "replay":
+		os.Exit(replayCommand(args))
+	}
+
+	fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n\n", command)
+	flag.Usage()
+	os.Exit(exitFailure)
+}
+
+// The flags of a command, -config among them, so it can be given before
+// the command as well as after it.
+func commandFlags(name, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(configFlag, "config", *configFlag, "the YAML configuration `file` to use (or set CONFIG_FILE)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: omada-to-gotify %v [flags]%v\n\nFlags:\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Parses the flags of a command taking at most maxArgs arguments after
+// them; like flag.Parse, it exits on -h and on flags it doesn't know.
+func parseFlags(flags *flag.FlagSet, args []string, maxArgs int) bool {
+	flags.Parse(args)
+
+	if flags.NArg() > maxArgs {
+		fmt.Fprintf(flags.Output(), "Too many arguments: %v\n\n", strings.Join(flags.Args()[maxArgs:], " "))
+		flags.Usage()
+		return false
+	}
 
-	_, server, port, err := InitMain(logger)
+	return true
+}
+
+func serveCommand(args []string) int {
+	if !parseFlags(commandFlags("serve", ""), args, 0) {
+		return exitFailure
+	}
+
+	cfg, err := config.Load(configPath())
 	if err != nil {
-		logger.Fatal(err.Error())
+		logging.New(os.Stderr, logging.FormatJSON, slog.LevelInfo).Error("Could not start", "error", err)
+		return exitFailure
 	}
 
-	logger.Printf("omada-to-gotify %s server starting on port %s ...", version, port)
+	// The level can be changed by reloading the configuration.
+	level := new(slog.LevelVar)
+	level.Set(cfg.LogLevel())
+	logger := logging.New(os.Stderr, cfg.Log.Format, level)
 
-	logger.Fatal(http.ListenAndServe(":"+port, server))
+	_, server, err := initServer(cfg, logger)
+	if err != nil {
+		logger.Error("Could not start", "error", err)
+		return exitFailure
+	}
+
+	return serve(cfg, server, logger, level)
 }
 
-func InitMain(logger *log.Logger) (gc gotify.GotifyClient, s *webhook.WebhookServer, p string, err error) {
-	gotifyURL := os.Getenv("GOTIFY_URL")
-	if gotifyURL == "" {
-		return gotify.GotifyClient{}, nil, "", errors.New("GOTIFY_URL environment variable is required")
+// Runs the server until it fails, or until SIGINT or SIGTERM asks it to
+// stop; then it stops accepting connections, and waits (for up to the
+// shutdown timeout) for the requests being handled and the deliveries in
+// progress to finish. Returns the exit code.
+func serve(cfg *config.Config, server *webhook.WebhookServer, logger *slog.Logger, level *slog.LevelVar) int {
+	// With a certificate, webhooks come in over HTTPS. The certificate is
+	// reloaded when its files change, e.g. when it has been renewed.
+	var certs *tlsconfig.Reloader
+	if cfg.TLS.CertFile != "" {
+		var err error
+		certs, err = tlsconfig.New(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, logger)
+		if err != nil {
+			logger.Error("Could not start", "error", err)
+			return exitFailure
+		}
+	}
+
+	// Caught before anything starts, so a signal arriving while starting up
+	// still stops the program gracefully.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	reloader := &reloader{server: server, logger: logger, level: level, cfg: cfg}
+	go reloader.run(ctx)
+
+	queueDone := make(chan struct{})
+	if server.Queue != nil {
+		go func() {
+			server.Queue.Run(ctx)
+			close(queueDone)
+		}()
+	} else {
+		close(queueDone)
 	}
 
-	applicationToken := os.Getenv("GOTIFY_APP_TOKEN")
-	if applicationToken == "" {
-		return gotify.GotifyClient{}, nil, "", errors.New("GOTIFY_APP_TOKEN environment variable is required")
+	flapsDone := make(chan struct{})
+	if server.Flaps != nil {
+		go func() {
+			server.Flaps.Run(ctx)
+			close(flapsDone)
+		}()
+	} else {
+		close(flapsDone)
 	}
 
-	sharedSecret := os.Getenv("OMADA_SHARED_SECRET")
-	if sharedSecret == "" {
-		return gotify.GotifyClient{}, nil, "", errors.New("OMADA_SHARED_SECRET environment variable is required")
+	if server.History != nil {
+		go server.History.Run(ctx, historyPruneInterval)
+		defer server.History.Close()
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	httpServer := &http.Server{
+		Addr:     ":" + cfg.Port,
+		Handler:  handler(server, reloader),
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
 
-	gotifyClient := gotify.GotifyClient{
-		GotifyURL: gotifyURL,
-		Token:     applicationToken,
-		Logger:    logger,
+	if certs != nil {
+		httpServer.TLSConfig = certs.Config()
+		go certs.Run(ctx, reloadInterval)
 	}
 
+	failed := make(chan error, 2)
+	go func() {
+		if certs != nil {
+			failed <- httpServer.ListenAndServeTLS("", "")
+		} else {
+			failed <- httpServer.ListenAndServe()
+		}
+	}()
+
+	scheme := "HTTP"
+	if certs != nil {
+		scheme = "HTTPS"
+	}
+	logger.Info("omada-to-gotify server starting", "version", version, "port", cfg.Port, "scheme", scheme)
+
+	// On a listener of its own, so it can be kept away from the controllers.
+	var metricsServer *http.Server
+	if cfg.Metrics.Port != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.Default.Handler(cfg.Metrics.Token))
+
+		metricsServer = &http.Server{
+			Addr:    ":" + cfg.Metrics.Port,
+			Handler: mux,
+		}
+
+		go func() {
+			failed <- metricsServer.ListenAndServe()
+		}()
+
+		logger.Info("Serving metrics", "port", cfg.Metrics.Port)
+	}
+
+	// A failed listener stops the program the same way a signal does, so
+	// the other listener's requests and the deliveries are seen through.
+	code := 0
+	select {
+	case err := <-failed:
+		logger.Error("The server failed, shutting down ...", "error", err)
+		code = exitFailure
+	case sig := <-signals:
+		logger.Info("Shutting down ...", "signal", sig.String())
+	}
+
+	// Not running out of time is no excuse to hide that the server failed.
+	timedOut := func() {
+		if code == 0 {
+			code = exitShutdownTimeout
+		}
+	}
+
+	timeout := reloader.config().ShutdownTimeout
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Requests were still being handled when the shutdown timeout passed", "timeout", timeout.String(), "error", err)
+		timedOut()
+	}
+
+	// Nothing left to wait for there, a scrape can just as well be cut short.
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+
+	// Stops the queue, flap detector and reloader; a delivery in progress
+	// is allowed to finish.
+	stop()
+
+	select {
+	case <-queueDone:
+	case <-shutdownCtx.Done():
+		logger.Warn("A delivery was still in progress when the shutdown timeout passed", "timeout", timeout.String())
+		timedOut()
+		return code
+	}
+
+	// The messages of links still flapping are held back until they settle,
+	// which won't happen now; their summaries go into the queue (or out) now,
+	// for no longer than the shutdown timeout allows.
+	<-flapsDone
+	if server.Flaps != nil {
+		server.Flaps.Flush(shutdownCtx)
+		if shutdownCtx.Err() != nil {
+			timedOut()
+		}
+	}
+
+	if server.Queue != nil {
+		if left := server.Queue.Drain(shutdownCtx); left > 0 {
+			logger.Info("Messages left in the queue will be delivered once the server runs again", "messages", left)
+		}
+
+		if shutdownCtx.Err() != nil {
+			timedOut()
+		}
+	}
+
+	logger.Info("Shut down")
+	return code
+}
+
+// The configuration file to use, when any; CONFIG_FILE works as well.
+var configFlag = flag.String("config", "", "the YAML configuration `file` to use (or set CONFIG_FILE)")
+
+func configPath() string {
+	if *configFlag != "" {
+		return *configFlag
+	}
+	return os.Getenv("CONFIG_FILE")
+}
+
+func InitMain(logger *slog.Logger) (gc gotify.GotifyClient, s *webhook.WebhookServer, p string, err error) {
+	cfg, err := config.Load(configPath())
+	if err != nil {
+		return gotify.GotifyClient{}, nil, "", err
+	}
+
+	gotifyClient, server, err := initServer(cfg, logger)
+	if err != nil {
+		return gotify.GotifyClient{}, nil, "", err
+	}
+
+	return gotifyClient, server, cfg.Port, nil
+}
+
+func initServer(cfg *config.Config, logger *slog.Logger) (gotify.GotifyClient, *webhook.WebhookServer, error) {
+	if cfg.File != "" {
+		logger.Info("Loaded the configuration", "file", cfg.File)
+	}
+
+	gotifyClient, settings, err := buildSettings(cfg, logger)
+	if err != nil {
+		return gotify.GotifyClient{}, nil, err
+	}
+
+	// The built-in classification is used as-is without rules.
+	omada.SetRules(settings.Rules)
+
 	server := &webhook.WebhookServer{
-		GotifyClient:        gotifyClient,
-		GotifyClientMessage: gotifyClient.Client().Message,
-		SharedSecret:        sharedSecret,
-		Logger:              logger,
+		Logger:  logger,
+		Outages: linkstate.NewOutageTracker(logger),
+	}
+	server.Apply(settings)
+
+	// On unless the number of entries is set to 0.
+	if cfg.History.MaxEntries > 0 {
+		store, err := history.New(cfg.History.File, logger)
+		if err != nil {
+			return gotify.GotifyClient{}, nil, err
+		}
+
+		store.MaxAge = cfg.History.MaxAge
+		store.MaxEntries = cfg.History.MaxEntries
+		server.History = store
+	}
+
+	// Optional, but without it a message is lost when Gotify can't be reached.
+	if cfg.Queue.Dir != "" {
+		queue, err := webhook.NewDeliveryQueue(cfg.Queue.Dir, server.DeliverTo, logger)
+		if err != nil {
+			return gotify.GotifyClient{}, nil, err
+		}
+
+		queue.MaxAge = cfg.Queue.MaxAge
+		queue.History = server.History
+		server.Queue = queue
+	}
+
+	// On unless the threshold is set to 0.
+	if cfg.Flap.Threshold > 0 {
+		notify := func(ctx context.Context, msg *omada.OmadaMessage) {
+			// Errors are logged by Deliver, there's no one else to tell.
+			_ = server.Deliver(ctx, msg)
+		}
+
+		server.Flaps = linkstate.NewFlapDetector(cfg.Flap.Threshold, cfg.Flap.Window, cfg.Flap.Settle, notify, logger)
+	}
+
+	return gotifyClient, server, nil
+}
+
+// Builds everything that can be changed by reloading the configuration:
+// the notifiers, secrets, endpoints and the classification rules.
+func buildSettings(cfg *config.Config, logger *slog.Logger) (gotify.GotifyClient, webhook.Settings, error) {
+	rules, err := cfg.Rules()
+	if err != nil {
+		return gotify.GotifyClient{}, webhook.Settings{}, err
+	}
+
+	if rules != nil {
+		rules.Logger = logger
+		logger.Info("Loaded classification rules", "rules", len(rules.Rules))
+	}
+
+	gotifyClient := newGotifyClient(cfg, logger)
+
+	notifiers, err := buildNotifiers(cfg, gotifyClient)
+	if err != nil {
+		return gotify.GotifyClient{}, webhook.Settings{}, err
+	}
+
+	endpoints, err := cfg.WebhookEndpoints(gotifyClient)
+	if err != nil {
+		return gotify.GotifyClient{}, webhook.Settings{}, err
+	}
+
+	auth, err := cfg.Authenticators()
+	if err != nil {
+		return gotify.GotifyClient{}, webhook.Settings{}, err
+	}
+
+	allow, err := cfg.AllowedNetworks()
+	if err != nil {
+		return gotify.GotifyClient{}, webhook.Settings{}, err
+	}
+
+	proxies, err := cfg.ProxyNetworks()
+	if err != nil {
+		return gotify.GotifyClient{}, webhook.Settings{}, err
+	}
+
+	perIP, perController := cfg.RateLimits()
+
+	if len(endpoints) > 0 {
+		logger.Info("Loaded endpoints", "endpoints", len(endpoints))
+	}
+
+	settings := webhook.Settings{
+		Notifiers:       notifiers,
+		SharedSecret:    cfg.Omada.SharedSecret,
+		Auth:            auth,
+		Endpoints:       endpoints,
+		Allow:           allow,
+		TrustedProxies:  proxies,
+		IPLimit:         perIP,
+		ControllerLimit: perController,
+		MaxBodySize:     cfg.MaxBodySize,
+		Strict:          cfg.Omada.Strict,
+		Rules:           rules,
+	}
+
+	return gotifyClient, settings, nil
+}
+
+func newGotifyClient(cfg *config.Config, logger *slog.Logger) gotify.GotifyClient {
+	return gotify.GotifyClient{
+		GotifyURL: cfg.Gotify.URL,
+		Token:     cfg.Gotify.Token,
+		Logger:    logger,
+		Format:    cfg.Gotify.Format,
+	}
+}
+
+// The text of the message send-test sends; recognised as a test message.
+const testMessage = "This is a webhook test message. Please ignore this; it was sent by omada-to-gotify send-test."
+
+// Sends a test message to Gotify with its URL and token (leaving out the
+// routes), to find out whether those work. Returns the exit code.
+func sendTestCommand(args []string) int {
+	flags := commandFlags("send-test", "")
+	text := flags.String("text", testMessage, "the `text` of the message")
+	if !parseFlags(flags, args, 0) {
+		return exitFailure
+	}
+
+	cfg, logger, err := loadCommandConfig()
+	if err != nil {
+		return commandFailed("send a test message", err)
+	}
+
+	msg := &omada.OmadaMessage{
+		Controller: "omada-to-gotify",
+		Site:       "send-test",
+		Text:       []string{*text},
+		Timestamp:  time.Now().UnixMilli(),
+	}
+
+	gotifyClient := newGotifyClient(cfg, logger)
+	if err := gotifyClient.Send(context.Background(), gotifyClient.Client().Message, msg); err != nil {
+		return commandFailed("send a test message", err)
+	}
+
+	fmt.Printf("Sent a test message to Gotify at %v\n", cfg.Gotify.URL)
+	return 0
+}
+
+// Checks the configuration, and everything it refers to: the rules, routes
+// and endpoints, and the TLS certificate. Returns the exit code.
+func validateConfigCommand(args []string) int {
+	if !parseFlags(commandFlags("validate-config", ""), args, 0) {
+		return exitFailure
+	}
+
+	cfg, logger, err := loadCommandConfig()
+	if err != nil {
+		return commandFailed("validate the configuration", err)
+	}
+
+	_, settings, err := buildSettings(cfg, logger)
+	if err != nil {
+		return commandFailed("validate the configuration", err)
+	}
+
+	if cfg.TLS.CertFile != "" {
+		if _, err := tlsconfig.New(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, logger); err != nil {
+			return commandFailed("validate the configuration", err)
+		}
+	}
+
+	source := "the environment"
+	if cfg.File != "" {
+		source = cfg.File + " and the environment"
+	}
+	fmt.Printf("The configuration in %v is valid.\n", source)
+
+	ruleCount, routeCount := 0, 0
+	if settings.Rules != nil {
+		ruleCount = len(settings.Rules.Rules)
+	}
+
+	names := []string{}
+	for _, notifier := range settings.Notifiers {
+		names = append(names, notifier.Name())
+		if n, ok := notifier.(*gotify.Notifier); ok && n.Routes != nil {
+			routeCount = len(n.Routes.Routes)
+		}
+	}
+
+	fmt.Printf("Classification rules: %d\n", ruleCount)
+	fmt.Printf("Gotify routes: %d\n", routeCount)
+	fmt.Printf("Endpoints: %d\n", len(settings.Endpoints))
+	fmt.Printf("Notifying: %v\n", strings.Join(names, ", "))
+	return 0
+}
+
+// Reads a message as Omada sends it, from the file given or standard input,
+// and shows how it is classified, and routed to Gotify. Returns the exit
+// code.
+func parseCommand(args []string) int {
+	flags := commandFlags("parse", " [file]")
+	endpoint := flags.String("endpoint", "", "route the message as if it came in on this `endpoint` (default: the default one)")
+	if !parseFlags(flags, args, 1) {
+		return exitFailure
+	}
+
+	cfg, logger, err := loadCommandConfig()
+	if err != nil {
+		return commandFailed("parse the message", err)
+	}
+
+	server, settings, err := idleServer(cfg, logger)
+	if err != nil {
+		return commandFailed("parse the message", err)
+	}
+
+	if *endpoint != "" && !slices.ContainsFunc(settings.Endpoints, func(e *webhook.Endpoint) bool { return e.Name == *endpoint }) {
+		return commandFailed("parse the message", fmt.Errorf("there is no endpoint %q", *endpoint))
+	}
+
+	body, err := readInput(flags.Arg(0))
+	if err != nil {
+		return commandFailed("parse the message", err)
+	}
+
+	message := replay.Message{Payload: body, Endpoint: *endpoint}
+	result := server.Replay(context.Background(), []replay.Message{message}, history.Query{}, false)[0]
+	if result.Error != "" {
+		return commandFailed("parse the message", errors.New(result.Error))
+	}
+
+	rule, route := "none", result.Route
+	if result.Rule != "" {
+		rule = result.Rule
+	}
+	switch {
+	case result.Dropped:
+		route += " (dropped)"
+	case route == "":
+		route = "none, its endpoint doesn't send to Gotify"
+	}
+
+	fmt.Printf("Title:    %v\n", result.Title)
+	fmt.Printf("Type:     %v\n", result.Type)
+	fmt.Printf("Priority: %d\n", result.Priority)
+	fmt.Printf("Rule:     %v\n", rule)
+	fmt.Printf("Route:    %v\n", route)
+	if fields := omada.UnknownFields(body); len(fields) > 0 {
+		fmt.Printf("Unknown:  %v\n", strings.Join(fields, ", "))
+	}
+	fmt.Println("Body:")
+	for _, line := range strings.Split(result.Body, "\n") {
+		fmt.Printf("  %v\n", line)
+	}
+	return 0
+}
+
+// Runs the payloads in a file (or else the history file) through parsing,
+// classification and routing with the configuration as it is now, showing
+// the results; with -deliver, it sends them to Gotify as well. Returns the
+// exit code, which is a failure when any of them couldn't be replayed.
+func replayCommand(args []string) int {
+	flags := commandFlags("replay", "")
+	file := flags.String("file", "", "the `file` to replay: JSON lines of request bodies, or a history file; - for standard input (default: the history file)")
+	deliver := flags.Bool("deliver", false, "send the messages to Gotify, rather than only showing what would be sent")
+	changed := flags.Bool("changed", false, "only show the messages classified or routed differently now")
+
+	query := url.Values{}
+	for _, name := range []string{"since", "until", "controller", "site", "type", "min_priority", "max_priority", "limit"} {
+		flags.Func(name, "only replay the messages with this "+name+" (see the history API)", func(value string) error {
+			query.Set(name, value)
+			return nil
+		})
+	}
+
+	if !parseFlags(flags, args, 0) {
+		return exitFailure
+	}
+
+	q, err := history.ParseQuery(query, time.Now())
+	if err != nil {
+		return commandFailed("replay", err)
+	}
+
+	cfg, logger, err := loadCommandConfig()
+	if err != nil {
+		return commandFailed("replay", err)
+	}
+
+	server, _, err := idleServer(cfg, logger)
+	if err != nil {
+		return commandFailed("replay", err)
+	}
+
+	path := *file
+	if path == "" {
+		path = cfg.History.File
+	}
+	if path == "" {
+		return commandFailed("replay", errors.New("there is no history file, give the file to replay with -file"))
+	}
+
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return commandFailed("replay", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	messages, err := replay.Read(input)
+	if err != nil {
+		return commandFailed("replay", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := server.Replay(ctx, messages, q, *deliver)
+
+	shown, failed := 0, 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+		if *changed && len(result.Changes) == 0 {
+			continue
+		}
+		result.Print(os.Stdout)
+		shown++
+	}
+
+	fmt.Printf("%d replayed, %d shown, %d could not be replayed or delivered\n", len(results), shown, failed)
+
+	if failed > 0 {
+		return exitFailure
+	}
+	return 0
+}
+
+// Loads the configuration for a command other than serve, along with a
+// logger leaving out the routine messages; the command says how it went.
+func loadCommandConfig() (*config.Config, *slog.Logger, error) {
+	cfg, err := config.Load(configPath())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, logging.New(os.Stderr, cfg.Log.Format, max(cfg.LogLevel(), slog.LevelWarn)), nil
+}
+
+// A server built from the configuration which isn't started, for the
+// commands parsing and routing messages the way it would.
+func idleServer(cfg *config.Config, logger *slog.Logger) (*webhook.WebhookServer, webhook.Settings, error) {
+	_, settings, err := buildSettings(cfg, logger)
+	if err != nil {
+		return nil, webhook.Settings{}, err
+	}
+
+	omada.SetRules(settings.Rules)
+
+	server := &webhook.WebhookServer{Logger: logger}
+	server.Apply(settings)
+	return server, settings, nil
+}
+
+// Reads the file, or standard input when there's no file (or it's -).
+func readInput(path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// Tells why a command failed, returning the exit code for that.
+func commandFailed(what string, err error) int {
+	fmt.Fprintf(os.Stderr, "Could not %v: %v\n", what, err)
+	return exitFailure
+}
+
+// How often the history is checked for entries past their retention.
+const historyPruneInterval = time.Hour
+
+// How long a health report is reused, and how long each check may take.
+const (
+	healthCacheFor = 10 * time.Second
+	healthTimeout  = 5 * time.Second
+)
+
+// The webhooks, plus /healthz to tell whether the program is alive, and
+// /readyz to tell whether it can deliver messages to Gotify. Those check
+// the configuration in use at the time, so they follow reloads; as do the
+// API token guarding /api/history and /api/replay, and the login guarding
+// /dashboard/.
+func handler(server *webhook.WebhookServer, r *reloader) http.Handler {
+	liveness := &health.Checker{CacheFor: healthCacheFor, Timeout: healthTimeout}
+	if server.Queue != nil {
+		liveness.Checks = append(liveness.Checks, health.Check{
+			Name: "queue",
+			Run: func(context.Context) error {
+				_, err := os.ReadDir(server.Queue.Dir)
+				return err
+			},
+		})
+	}
+
+	readiness := &health.Checker{
+		CacheFor: healthCacheFor,
+		Timeout:  healthTimeout,
+		Checks: []health.Check{
+			{Name: "gotify-reachable", Run: func(ctx context.Context) error {
+				return newGotifyClient(r.config(), r.logger).CheckReachable(ctx)
+			}},
+			// Every token in use, the routes' and endpoints' as well.
+			{Name: "gotify-token", Run: func(ctx context.Context) error {
+				notifiers := []*gotify.Notifier{}
+				for _, notifier := range server.AllNotifiers() {
+					if n, ok := notifier.(*gotify.Notifier); ok {
+						notifiers = append(notifiers, n)
+					}
+				}
+				return gotify.CheckTokens(ctx, notifiers...)
+			}},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", liveness)
+	mux.Handle("GET /readyz", readiness)
+	mux.Handle("/", server)
+
+	if server.History != nil {
+		api := withToken(func() string { return r.config().API.Token }, server.History.Handler())
+		mux.Handle("GET /api/history", api)
+		mux.Handle("GET /api/history/{id}", api)
+	}
+
+	mux.Handle("POST /api/replay", withToken(func() string { return r.config().API.Token }, server.ReplayHandler()))
+
+	dash := &dashboard.Dashboard{
+		History: server.History,
+		Outages: server.Outages,
+		Flaps:   server.Flaps,
+		Logger:  r.logger,
+		Resend: func(ctx context.Context, id string) error {
+			return server.Resend(ctx, id)
+		},
+	}
+	login := func() (string, string) {
+		cfg := r.config()
+		return cfg.Dashboard.User, cfg.Dashboard.Password
+	}
+	mux.Handle("/dashboard/", withLogin(login, dash.Handler()))
+
+	return mux
+}
+
+// Only lets through requests with an "Authorization: Bearer <token>" header
+// for the current token; as if there's nothing there without a token.
+func withToken(token func() string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		want := token()
+		if want == "" {
+			http.NotFound(w, req)
+			return
+		}
+
+		given, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(want)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Only lets through requests logging in (with HTTP basic authentication)
+// as the current user; as if there's nothing there without a password.
+func withLogin(login func() (user, password string), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		wantUser, wantPassword := login()
+		if wantPassword == "" {
+			http.NotFound(w, req)
+			return
+		}
+
+		user, password, _ := req.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword)) == 1
+		if !userOK || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dashboard", charset="UTF-8"`)
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// How often the configuration files are checked for changes.
+const reloadInterval = 5 * time.Second
+
+// The reloader reloads the configuration on SIGHUP, and when one of its
+// files changes. A configuration that turns out to be invalid is logged and
+// otherwise ignored; the server keeps running with the one it has.
+type reloader struct {
+	server *webhook.WebhookServer
+	logger *slog.Logger
+	level  *slog.LevelVar
+
+	mu  sync.Mutex
+	cfg *config.Config
+}
+
+func (r *reloader) run(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	go config.WatchFiles(ctx, reloadInterval, r.files, func() { r.reload("a configuration file changed") })
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			r.reload("SIGHUP")
+		}
+	}
+}
+
+// The configuration currently in use.
+func (r *reloader) config() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+func (r *reloader) files() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg.Files()
+}
+
+func (r *reloader) reload(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logger.Info("Reloading the configuration", "reason", reason)
+
+	cfg, err := config.Load(configPath())
+	if err != nil {
+		r.logger.Error("Keeping the current configuration, the new one is invalid", "error", err)
+		return
+	}
+
+	_, settings, err := buildSettings(cfg, r.logger)
+	if err != nil {
+		r.logger.Error("Keeping the current configuration, the new one is invalid", "error", err)
+		return
+	}
+
+	// The listeners, queue and flap detector stay as they are, along with
+	// what they know; changing those needs a restart. (The certificate
+	// files are watched by the listener itself.)
+	if cfg.Port != r.cfg.Port || cfg.TLS != r.cfg.TLS || cfg.Metrics != r.cfg.Metrics || cfg.Queue != r.cfg.Queue || cfg.Flap != r.cfg.Flap || cfg.History != r.cfg.History || cfg.Log.Format != r.cfg.Log.Format {
+		r.logger.Warn("Changes to the port, TLS, metrics, queue, flap detection, history and log format settings take effect after a restart")
+		cfg.Port, cfg.TLS, cfg.Metrics, cfg.Queue, cfg.Flap, cfg.History, cfg.Log.Format = r.cfg.Port, r.cfg.TLS, r.cfg.Metrics, r.cfg.Queue, r.cfg.Flap, r.cfg.History, r.cfg.Log.Format
+	}
+
+	// The server classifies each message with the rules of the settings it
+	// handles the request with, which are swapped in one go; so a request
+	// is handled entirely with either the old or the new rules and settings.
+	// The active rules follow for the messages made up outside of requests.
+	omada.SetRules(settings.Rules)
+	r.server.Apply(settings)
+	r.level.Set(cfg.LogLevel())
+	r.cfg = cfg
+
+	r.logger.Info("Reloaded the configuration")
+}
+
+// Gotify is always used; the other notifiers are used when configured.
+func buildNotifiers(cfg *config.Config, gotifyClient gotify.GotifyClient) ([]notify.Notifier, error) {
+	gotifyNotifier := gotify.NewNotifier(gotifyClient)
+
+	// Optional, everything goes to the application's token without it.
+	routes, err := cfg.Routes()
+	if err != nil {
+		return nil, err
+	}
+
+	if routes != nil {
+		gotifyNotifier.Routes = routes
+		gotifyClient.Logger.Info("Loaded routes", "routes", len(routes.Routes))
+	}
+
+	notifiers := []notify.Notifier{gotifyNotifier}
+
+	if cfg.Ntfy.URL != "" {
+		notifiers = append(notifiers, &notify.Ntfy{
+			URL:      cfg.Ntfy.URL,
+			Token:    cfg.Ntfy.Token,
+			Markdown: gotifyClient.Format != gotify.FormatPlain,
+		})
+	}
+
+	if cfg.Webhook.URL != "" {
+		notifiers = append(notifiers, &notify.JSONWebhook{URL: cfg.Webhook.URL, Headers: cfg.Webhook.Headers})
+	}
+
+	if cfg.Matrix.Homeserver != "" {
+		notifiers = append(notifiers, &notify.Matrix{
+			Homeserver:  cfg.Matrix.Homeserver,
+			AccessToken: cfg.Matrix.AccessToken,
+			RoomID:      cfg.Matrix.RoomID,
+		})
+	}
+
+	if cfg.SMTP.Host != "" {
+		notifiers = append(notifiers, &notify.Email{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+			To:       cfg.SMTP.To,
+		})
 	}
 
-	return gotifyClient, server, port, nil
+	return notifiers, nil
 }
 
 // EOF
@@ -2,7 +2,7 @@ package main_test
 
 import (
 	"bytes"
-	"log"
+	"log/slog"
 	"os"
 	"testing"
 
@@ -13,14 +13,14 @@ func TestInitMain(t *testing.T) {
 
 	var (
 		buf    bytes.Buffer
-		logger = log.New(&buf, "logger: ", log.Lshortfile)
+		logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	)
 
 	t.Run("GOTIFY_URL is required", func(t *testing.T) {
 		buf.Reset()
 		_, _, _, err := main.InitMain(logger)
 		if err.Error() != "GOTIFY_URL environment variable is required" {
-			logger.Fatalf("Failed test whether GOTIFY_URL is required; log is `%v`", buf.String())
+			t.Fatalf("Failed test whether GOTIFY_URL is required; log is `%v`", buf.String())
 		}
 	})
 
@@ -30,7 +30,7 @@ func TestInitMain(t *testing.T) {
 		buf.Reset()
 		_, _, _, err := main.InitMain(logger)
 		if err.Error() != "GOTIFY_APP_TOKEN environment variable is required" {
-			logger.Fatalf("Failed test whether GOTIFY_APP_TOKEN is required; log is `%v`", buf.String())
+			t.Fatalf("Failed test whether GOTIFY_APP_TOKEN is required; log is `%v`", buf.String())
 		}
 	})
 
@@ -40,7 +40,7 @@ func TestInitMain(t *testing.T) {
 		buf.Reset()
 		_, _, _, err := main.InitMain(logger)
 		if err.Error() != "OMADA_SHARED_SECRET environment variable is required" {
-			logger.Fatalf("Failed test whether OMADA_SHARED_SECRET is required; log is `%v`", buf.String())
+			t.Fatalf("Failed test whether OMADA_SHARED_SECRET is required; log is `%v`", buf.String())
 		}
 	})
 
@@ -52,19 +52,19 @@ func TestInitMain(t *testing.T) {
 		gotifyClient, server, port, err := main.InitMain(logger)
 
 		if err != nil {
-			logger.Fatalf("Still failed to initialize main; log is %v", buf.String())
+			t.Fatalf("Still failed to initialize main; log is %v", buf.String())
 		}
 
 		if gotifyClient.GotifyURL != "http://foo:1337/" {
-			logger.Fatalf("Failed to initialize gotify client properly; GotifyURL is `%v`", gotifyClient.GotifyURL)
+			t.Fatalf("Failed to initialize gotify client properly; GotifyURL is `%v`", gotifyClient.GotifyURL)
 		}
 
 		if port != "8080" {
-			logger.Fatalf("Failed to initialize server port; PORT is `%v`", port)
+			t.Fatalf("Failed to initialize server port; PORT is `%v`", port)
 		}
 
 		if server == nil {
-			logger.Fatal("The server wasn't created by the init call")
+			t.Fatal("The server wasn't created by the init call")
 		}
 	})
 }
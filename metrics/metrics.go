@@ -0,0 +1,318 @@
+// Package metrics keeps the counters, gauges and histograms describing what
+// omada-to-gotify is doing, and serves them in the Prometheus text format.
+// It is deliberately small: just what this program needs, without pulling
+// in the Prometheus client library and its dependencies.
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A metric knows how to write itself in the text exposition format.
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry is a set of metrics that can be served together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// The registry the metrics of this program are registered with.
+var Default = &Registry{}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes all the metrics, sorted by name.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name() < metrics[j].name()
+	})
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics; when token is not empty, only to requests with
+// an "Authorization: Bearer <token>" header.
+func (r *Registry) Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token != "" {
+			given, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// The values of a metric, one for each combination of label values.
+type series struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string][]string // label values by key
+}
+
+func newSeries(name, help, kind string, labels []string) series {
+	return series{metricName: name, help: help, kind: kind, labels: labels, values: map[string][]string{}}
+}
+
+// A metric without labels is reported as 0 until something happens, rather
+// than not at all.
+func (s *series) init() {
+	if len(s.labels) == 0 {
+		s.key(nil)
+	}
+}
+
+func (s *series) name() string {
+	return s.metricName
+}
+
+// The key for the given label values, remembering the values for it.
+// Must be called with the lock held.
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metric %v has %d labels, got %d values", s.metricName, len(s.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	if _, ok := s.values[key]; !ok {
+		s.values[key] = append([]string{}, values...)
+	}
+	return key
+}
+
+// The keys in a stable order. Must be called with the lock held.
+func (s *series) keys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *series) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %v %v\n", s.metricName, strings.ReplaceAll(s.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %v %v\n", s.metricName, s.kind)
+}
+
+// e.g. `{endpoint="default",code="200"}`, with extra pairs appended.
+func (s *series) labelString(values []string, extra ...string) string {
+	pairs := []string{}
+	for i, label := range s.labels {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", label, escape(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", extra[i], escape(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Label values escape only backslashes, quotes and newlines.
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return escaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up, such as the number of requests.
+type Counter struct {
+	series
+	counts map[string]float64
+}
+
+// NewCounter registers a counter with the given labels with Default.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{series: newSeries(name, help, "counter", labels), counts: map[string]float64{}}
+	c.init()
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.key(values)] += v
+}
+
+// The current value for the given label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[c.key(values)]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+	for _, key := range c.keys() {
+		fmt.Fprintf(w, "%v%v %v\n", c.metricName, c.labelString(c.values[key]), formatFloat(c.counts[key]))
+	}
+}
+
+// Gauge is a value that can go up and down, such as the length of a queue.
+type Gauge struct {
+	series
+	gauges map[string]float64
+}
+
+// NewGauge registers a gauge with the given labels with Default.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{series: newSeries(name, help, "gauge", labels), gauges: map[string]float64{}}
+	g.init()
+	Default.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.gauges[g.key(values)] = v
+}
+
+// The current value for the given label values.
+func (g *Gauge) Value(values ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.gauges[g.key(values)]
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.header(w)
+	for _, key := range g.keys() {
+		fmt.Fprintf(w, "%v%v %v\n", g.metricName, g.labelString(g.values[key]), formatFloat(g.gauges[key]))
+	}
+}
+
+// The default buckets for histograms of durations in seconds, the same as
+// those of the Prometheus client libraries.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations, such as durations, in buckets.
+type Histogram struct {
+	series
+	buckets []float64
+	counts  map[string][]uint64 // per bucket, not cumulative
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogram registers a histogram with the given buckets (upper bounds,
+// in increasing order) and labels with Default.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		series:  newSeries(name, help, "histogram", labels),
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+	h.init()
+	Default.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := h.key(values)
+	if h.counts[key] == nil {
+		h.counts[key] = make([]uint64, len(h.buckets))
+	}
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[key][i]++
+			break
+		}
+	}
+
+	h.sums[key] += v
+	h.totals[key]++
+}
+
+// The number of observations for the given label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.totals[h.key(values)]
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+	for _, key := range h.keys() {
+		values := h.values[key]
+
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			if h.counts[key] != nil {
+				cumulative += h.counts[key][i]
+			}
+			fmt.Fprintf(w, "%v_bucket%v %d\n", h.metricName, h.labelString(values, "le", formatFloat(bound)), cumulative)
+		}
+
+		fmt.Fprintf(w, "%v_bucket%v %d\n", h.metricName, h.labelString(values, "le", "+Inf"), h.totals[key])
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.metricName, h.labelString(values), formatFloat(h.sums[key]))
+		fmt.Fprintf(w, "%v_count%v %d\n", h.metricName, h.labelString(values), h.totals[key])
+	}
+}
+
+// EOF
@@ -0,0 +1,76 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/metrics"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	requests := metrics.NewCounter("test_requests_total", "Requests, by code.", "code")
+	depth := metrics.NewGauge("test_depth", "How deep.")
+	duration := metrics.NewHistogram("test_duration_seconds", "How long.", []float64{0.1, 1}, "name")
+
+	requests.Inc("200")
+	requests.Add(2, "200")
+	requests.Inc(`"odd"\value`)
+	depth.Set(3)
+	duration.Observe(0.05, "a")
+	duration.Observe(0.5, "a")
+	duration.Observe(5, "a")
+
+	want := []string{
+		"# HELP test_requests_total Requests, by code.\n# TYPE test_requests_total counter\n" +
+			"test_requests_total{code=\"\\\"odd\\\"\\\\value\"} 1\ntest_requests_total{code=\"200\"} 3\n",
+		"# TYPE test_depth gauge\ntest_depth 3\n",
+		"# TYPE test_duration_seconds histogram\n" +
+			"test_duration_seconds_bucket{name=\"a\",le=\"0.1\"} 1\n" +
+			"test_duration_seconds_bucket{name=\"a\",le=\"1\"} 2\n" +
+			"test_duration_seconds_bucket{name=\"a\",le=\"+Inf\"} 3\n" +
+			"test_duration_seconds_sum{name=\"a\"} 5.55\n" +
+			"test_duration_seconds_count{name=\"a\"} 3\n",
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		auth  string
+		code  int
+	}{
+		{"Without a token", "", "", http.StatusOK},
+		{"With the right token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"With the wrong token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"Without the token", "s3cret", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.auth != "" {
+				request.Header.Set("Authorization", tt.auth)
+			}
+
+			response := httptest.NewRecorder()
+			metrics.Default.Handler(tt.token).ServeHTTP(response, request)
+
+			if response.Code != tt.code {
+				t.Fatalf("Expected status %d, got %d", tt.code, response.Code)
+			}
+
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			for _, w := range want {
+				if !strings.Contains(response.Body.String(), w) {
+					t.Errorf("Expected the metrics to contain\n%v\ngot\n%v", w, response.Body.String())
+				}
+			}
+		})
+	}
+}
+
+// EOF
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// Email sends messages by SMTP. The connection is upgraded with STARTTLS
+// when the server offers it; authentication is only used when a username
+// is set.
+type Email struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (n *Email) Name() string {
+	return "email"
+}
+
+func (n *Email) Notify(ctx context.Context, msg *omada.OmadaMessage) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	addr := net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+
+	dialer := &net.Dialer{Timeout: requestTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// net/smtp doesn't take a context; a deadline on the connection stops
+	// a server that hangs from holding on to it, and so does the context
+	// being done.
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := n.send(conn, auth, msg); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Sends the message over the connection, the way smtp.SendMail does.
+func (n *Email) send(conn net.Conn, auth smtp.Auth, msg *omada.OmadaMessage) error {
+	client, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.Host}); err != nil {
+			return err
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.From); err != nil {
+		return err
+	}
+
+	for _, to := range n.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(n.message(msg)); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (n *Email) message(msg *omada.OmadaMessage) []byte {
+	headers := []string{
+		"From: " + n.From,
+		"To: " + strings.Join(n.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Title()),
+		"Date: " + msg.Date().Format(time.RFC1123Z),
+		"X-Priority: " + strconv.Itoa(6-FivePointPriority(msg.Priority())),
+		"X-Omada-Type: " + msg.TypeName(),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+
+	// SMTP wants CRLF line endings, and a line with just a dot would end the
+	// message early (net/smtp takes care of the latter).
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body(), "\r\n", "\n"), "\n", "\r\n")
+
+	return []byte(fmt.Sprintf("%v\r\n\r\n%v\r\n", strings.Join(headers, "\r\n"), body))
+}
+
+// EOF
@@ -0,0 +1,148 @@
+package notify_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/notify"
+)
+
+// Just enough of an SMTP server to accept a single message.
+func smtpStandIn(t *testing.T) (string, int, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 stand-in ESMTP")
+
+		var transcript strings.Builder
+		inData := false
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					reply("250 OK")
+					continue
+				}
+				transcript.WriteString(line)
+				continue
+			}
+
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 stand-in")
+			case strings.HasPrefix(command, "MAIL FROM"), strings.HasPrefix(command, "RCPT TO"):
+				transcript.WriteString(line)
+				reply("250 OK")
+			case command == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case command == "QUIT":
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	return host, portNumber, received
+}
+
+func TestEmail(t *testing.T) {
+	host, port, received := smtpStandIn(t)
+
+	n := &notify.Email{
+		Host: host,
+		Port: port,
+		From: "omada@example.com",
+		To:   []string{"ops@example.com", "oncall@example.com"},
+	}
+
+	if err := n.Notify(context.Background(), offlineMessage); err != nil {
+		t.Fatalf("Notify() failed: %v", err)
+	}
+
+	transcript := <-received
+
+	for _, want := range []string{
+		"MAIL FROM:<omada@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"RCPT TO:<oncall@example.com>",
+		"Subject: Omada Controller_347044: Home\r\n",
+		"X-Omada-Type: offline\r\n",
+		"The online detection result of [2.5G WAN1] was offline.\r\n",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("The email does not contain %q:\n%v", want, transcript)
+		}
+	}
+}
+
+// An SMTP server which accepts the connection but never answers is given
+// up on once the context is done.
+func TestEmail_Hung(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	n := &notify.Email{Host: host, Port: portNumber, From: "omada@example.com", To: []string{"ops@example.com"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := n.Notify(ctx, offlineMessage); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Notify() took %v to give up", elapsed)
+	}
+}
+
+// EOF
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// JSONWebhook posts each message as a JSON document to a URL, for anything
+// that can take a generic webhook (Home Assistant, n8n, a script, ...).
+type JSONWebhook struct {
+	URL string
+
+	// Extra headers to send along, e.g. for authentication.
+	Headers map[string]string
+
+	Client *http.Client
+}
+
+// The document posted by JSONWebhook.
+type JSONWebhookPayload struct {
+	Title      string         `json:"title"`
+	Message    string         `json:"message"`
+	Priority   int            `json:"priority"`
+	Type       string         `json:"type"`
+	Date       time.Time      `json:"date"`
+	Controller string         `json:"controller"`
+	Site       string         `json:"site"`
+	Text       []string       `json:"text"`
+	Entities   omada.Entities `json:"entities"`
+	Outage     *omada.Outage  `json:"outage,omitempty"`
+}
+
+func (n *JSONWebhook) Name() string {
+	return "webhook"
+}
+
+func (n *JSONWebhook) Notify(ctx context.Context, msg *omada.OmadaMessage) error {
+	payload := JSONWebhookPayload{
+		Title:      msg.Title(),
+		Message:    msg.Body(),
+		Priority:   msg.Priority(),
+		Type:       msg.TypeName(),
+		Date:       msg.Date(),
+		Controller: msg.Controller,
+		Site:       msg.Site,
+		Text:       msg.Text,
+		Entities:   msg.Entities(),
+		Outage:     msg.Outage,
+	}
+
+	req, err := jsonRequest(ctx, http.MethodPost, n.URL, payload)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range n.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return do(n.Client, req)
+}
+
+// EOF
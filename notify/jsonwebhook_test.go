@@ -0,0 +1,41 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/notify"
+)
+
+func TestJSONWebhook(t *testing.T) {
+	si, server := newStandIn(t, http.StatusNoContent)
+
+	n := &notify.JSONWebhook{URL: server.URL + "/hook", Headers: map[string]string{"X-Api-Key": "key"}}
+
+	if err := n.Notify(context.Background(), offlineMessage); err != nil {
+		t.Fatalf("Notify() failed: %v", err)
+	}
+
+	req := si.only(t)
+
+	if req.Header.Get("X-Api-Key") != "key" || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected headers %v", req.Header)
+	}
+
+	payload := notify.JSONWebhookPayload{}
+	if err := json.Unmarshal([]byte(req.Body), &payload); err != nil {
+		t.Fatalf("Could not decode the payload: %v", err)
+	}
+
+	if payload.Type != "offline" || payload.Priority != 10 || payload.Site != "Home" {
+		t.Errorf("Unexpected payload %+v", payload)
+	}
+
+	if payload.Entities.Port() != "2.5G WAN1" {
+		t.Errorf("Expected the entities in the payload, got %+v", payload.Entities)
+	}
+}
+
+// EOF
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// Matrix sends messages to a Matrix room, as the user the access token
+// belongs to (that user must have joined the room).
+type Matrix struct {
+	// The base URL of the homeserver, e.g. https://matrix.example.com
+	Homeserver  string
+	AccessToken string
+
+	// The ID of the room, e.g. !abcdefg:example.com
+	RoomID string
+
+	Client *http.Client
+}
+
+// Transaction IDs only need to be unique for this access token, and tell
+// the homeserver to ignore a repeated request.
+var matrixTransaction atomic.Uint64
+
+type matrixMessage struct {
+	MsgType string `json:"msgtype"`
+	Body    string `json:"body"`
+}
+
+func (n *Matrix) Name() string {
+	return "matrix"
+}
+
+func (n *Matrix) Notify(ctx context.Context, msg *omada.OmadaMessage) error {
+	txnID := fmt.Sprintf("omada-%d-%d", time.Now().UnixNano(), matrixTransaction.Add(1))
+
+	endpoint := fmt.Sprintf("%v/_matrix/client/v3/rooms/%v/send/m.room.message/%v",
+		strings.TrimRight(n.Homeserver, "/"), url.PathEscape(n.RoomID), url.PathEscape(txnID))
+
+	// Critical messages get the m.text type so they notify, the rest are
+	// sent as notices which clients tend to be quieter about.
+	msgType := "m.notice"
+	if msg.Priority() >= 8 {
+		msgType = "m.text"
+	}
+
+	req, err := jsonRequest(ctx, http.MethodPut, endpoint, matrixMessage{
+		MsgType: msgType,
+		Body:    msg.Title() + "\n" + msg.Body(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+n.AccessToken)
+
+	return do(n.Client, req)
+}
+
+// EOF
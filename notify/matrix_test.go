@@ -0,0 +1,42 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/notify"
+)
+
+func TestMatrix(t *testing.T) {
+	si, server := newStandIn(t, http.StatusOK)
+
+	n := &notify.Matrix{Homeserver: server.URL + "/", AccessToken: "syt_token", RoomID: "!room:example.com"}
+
+	if err := n.Notify(context.Background(), offlineMessage); err != nil {
+		t.Fatalf("Notify() failed: %v", err)
+	}
+
+	req := si.only(t)
+
+	if req.Method != http.MethodPut || !strings.HasPrefix(req.Path, "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/omada-") {
+		t.Errorf("Unexpected request %v %v", req.Method, req.Path)
+	}
+
+	if req.Header.Get("Authorization") != "Bearer syt_token" {
+		t.Errorf("Unexpected authorization header %q", req.Header.Get("Authorization"))
+	}
+
+	event := map[string]string{}
+	if err := json.Unmarshal([]byte(req.Body), &event); err != nil {
+		t.Fatalf("Could not decode the event: %v", err)
+	}
+
+	if event["msgtype"] != "m.text" || !strings.HasPrefix(event["body"], "Omada Controller_347044: Home\n[gateway:") {
+		t.Errorf("Unexpected event %v", event)
+	}
+}
+
+// EOF
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// A Notifier delivers messages to one notification service. Several can be
+// active at the same time; each gets every message.
+type Notifier interface {
+	// A short name for the notifier, unique amongst the active notifiers;
+	// used in the logs and to keep track of queued deliveries.
+	Name() string
+
+	// Notify delivers the message, returning an error when it could not be.
+	Notify(ctx context.Context, msg *omada.OmadaMessage) error
+}
+
+// A Router is a notifier which sends messages to one of several places, or
+// to none at all; RouteOf tells which, without sending anything.
+type Router interface {
+	RouteOf(msg *omada.OmadaMessage) (route string, drop bool)
+}
+
+// Gotify uses priorities from 0 to 10, ntfy and others go from 1 to 5 with
+// 3 as the default; this maps one onto the other.
+func FivePointPriority(priority int) int {
+	switch {
+	case priority <= 0:
+		return 1
+	case priority <= 3:
+		return 2
+	case priority <= 6:
+		return 3
+	case priority <= 8:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// How long a request to a notification service may take at most, whatever
+// the context given; a service that stops answering mustn't hold up the
+// deliveries behind it.
+const requestTimeout = 30 * time.Second
+
+// Used by the notifiers not given a client of their own.
+var defaultClient = &http.Client{Timeout: requestTimeout}
+
+// Sends the request, treating anything but a 2xx response as an error.
+func do(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = defaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%v %v returned %v: %s", req.Method, req.URL.Redacted(), res.Status, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+func jsonRequest(ctx context.Context, method string, url string, payload any) (*http.Request, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// EOF
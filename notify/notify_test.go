@@ -0,0 +1,75 @@
+package notify_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// A local stand-in for the services the notifiers talk to, remembering the
+// requests it was sent.
+type standIn struct {
+	mu       sync.Mutex
+	requests []recordedRequest
+	status   int
+}
+
+type recordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+func newStandIn(t *testing.T, status int) (*standIn, *httptest.Server) {
+	si := &standIn{status: status}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		si.mu.Lock()
+		si.requests = append(si.requests, recordedRequest{r.Method, r.URL.EscapedPath(), r.Header.Clone(), string(body)})
+		si.mu.Unlock()
+
+		w.WriteHeader(si.status)
+	}))
+	t.Cleanup(server.Close)
+
+	return si, server
+}
+
+func (si *standIn) only(t *testing.T) recordedRequest {
+	t.Helper()
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	if len(si.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(si.requests))
+	}
+	return si.requests[0]
+}
+
+var offlineMessage = &omada.OmadaMessage{
+	Controller: "Omada Controller_347044",
+	Site:       "Home",
+	Text:       []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\r"},
+	Timestamp:  1758852904877,
+}
+
+func TestFivePointPriority(t *testing.T) {
+	want := map[int]int{0: 1, 1: 2, 3: 2, 4: 3, 6: 3, 7: 4, 8: 4, 9: 5, 10: 5}
+
+	for priority, five := range want {
+		if got := notify.FivePointPriority(priority); got != five {
+			t.Errorf("FivePointPriority(%d) = %d, want %d", priority, got, five)
+		}
+	}
+}
+
+// EOF
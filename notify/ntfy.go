@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// Ntfy publishes messages to a topic on an ntfy server (https://ntfy.sh).
+type Ntfy struct {
+	// The URL of the topic, e.g. https://ntfy.sh/my-omada-alerts
+	URL string
+
+	// An access token, for servers or topics that require one.
+	Token string
+
+	// Send the body as Markdown, which the ntfy web app renders.
+	Markdown bool
+
+	Client *http.Client
+}
+
+func (n *Ntfy) Name() string {
+	return "ntfy"
+}
+
+func (n *Ntfy) Notify(ctx context.Context, msg *omada.OmadaMessage) error {
+	body := msg.Body()
+	if n.Markdown {
+		body = msg.MarkdownBody()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// Header values are ASCII on a single line; ntfy decodes RFC 2047, so
+	// a site name with accents (or a title template's line break) survives.
+	req.Header.Set("Title", mime.BEncoding.Encode("utf-8", msg.Title()))
+	req.Header.Set("Priority", strconv.Itoa(FivePointPriority(msg.Priority())))
+	req.Header.Set("Tags", msg.TypeName())
+
+	if n.Markdown {
+		req.Header.Set("Markdown", "yes")
+	}
+
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	return do(n.Client, req)
+}
+
+// EOF
@@ -0,0 +1,91 @@
+package notify_test
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+func TestNtfy(t *testing.T) {
+	si, server := newStandIn(t, http.StatusOK)
+
+	n := &notify.Ntfy{URL: server.URL + "/omada-alerts", Token: "tk_secret", Markdown: true}
+
+	if err := n.Notify(context.Background(), offlineMessage); err != nil {
+		t.Fatalf("Notify() failed: %v", err)
+	}
+
+	req := si.only(t)
+
+	if req.Method != http.MethodPost || req.Path != "/omada-alerts" {
+		t.Errorf("Unexpected request %v %v", req.Method, req.Path)
+	}
+
+	headers := map[string]string{
+		"Title":         "Omada Controller_347044: Home",
+		"Priority":      "5",
+		"Tags":          "offline",
+		"Markdown":      "yes",
+		"Authorization": "Bearer tk_secret",
+	}
+
+	for name, want := range headers {
+		if got := req.Header.Get(name); got != want {
+			t.Errorf("Header %v is %q, want %q", name, got, want)
+		}
+	}
+
+	if !strings.Contains(req.Body, "`98-03-8E-3A-8D-53`") {
+		t.Errorf("Expected the Markdown body, got %q", req.Body)
+	}
+}
+
+// Titles that aren't plain ASCII on one line are encoded, as header values
+// can't carry them as they are.
+func TestNtfy_EncodedTitle(t *testing.T) {
+	for _, site := range []string{"Café Zürich", "Home\r\nX-Injected: yes"} {
+		t.Run(site, func(t *testing.T) {
+			si, server := newStandIn(t, http.StatusOK)
+
+			n := &notify.Ntfy{URL: server.URL + "/omada-alerts"}
+
+			msg := &omada.OmadaMessage{Controller: "Omada", Site: site, Text: []string{"Anything"}}
+			if err := n.Notify(context.Background(), msg); err != nil {
+				t.Fatalf("Notify() failed: %v", err)
+			}
+
+			req := si.only(t)
+
+			if req.Header.Get("X-Injected") != "" {
+				t.Error("The title added a header of its own")
+			}
+
+			title, err := new(mime.WordDecoder).DecodeHeader(req.Header.Get("Title"))
+			if err != nil {
+				t.Fatalf("Could not decode the title %q: %v", req.Header.Get("Title"), err)
+			}
+
+			if want := msg.Title(); title != want {
+				t.Errorf("Title is %q, want %q", title, want)
+			}
+		})
+	}
+}
+
+func TestNtfy_Error(t *testing.T) {
+	_, server := newStandIn(t, http.StatusForbidden)
+
+	n := &notify.Ntfy{URL: server.URL + "/omada-alerts"}
+
+	err := n.Notify(context.Background(), offlineMessage)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a 403 error, got %v", err)
+	}
+}
+
+// EOF
@@ -0,0 +1,112 @@
+package omada
+
+import "regexp"
+
+// A messageMatcher recognises one type of message. A message is of that
+// type when the Description pattern matches the description, or the Text
+// pattern matches any one of the lines of text; a nil pattern never matches.
+type messageMatcher struct {
+	Type        OmadaMessageType
+	Description *regexp.Regexp
+	Text        *regexp.Regexp
+}
+
+func (m messageMatcher) matches(msg *OmadaMessage) bool {
+	if m.Description != nil && m.Description.MatchString(msg.Description) {
+		return true
+	}
+
+	if m.Text != nil {
+		for _, text := range msg.Text {
+			if m.Text.MatchString(text) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+var isATestMessage = regexp.MustCompile(`webhook test message[.] Please ignore`)
+var wasOnline = regexp.MustCompile(`The online detection result of \[.+\] was online`)
+var wasOffline = regexp.MustCompile(`The online detection result of \[.+\] was offline`)
+
+// The catalogue of recognised messages, checked in this order; the first
+// matcher to match decides the type. Keep the more specific patterns above
+// the more general ones (e.g. a VPN tunnel going down also "disconnects").
+// The messages the flap detector makes up aren't in here, it gives them
+// their type itself (see OmadaMessage.SetType).
+//
+// Payloads captured from real controllers live in testdata/events, please
+// add any new ones you come across there. Only the test, offline, online and
+// log-upload-failed messages have been captured; the patterns of the other
+// types are guesses after the wording of the controller's event log, as are
+// their samples in testdata/reconstructed. The guesses are kept close to that
+// wording (a device or a named tunnel, pool or port, and a fixed phrase) so
+// they would rather miss a message than take one of another kind; the
+// samples in testdata/reconstructed/unrecognised keep them to that.
+var messageCatalogue = []messageMatcher{
+	{
+		Type:        OmadaTestMessage,
+		Description: isATestMessage,
+	},
+	{
+		Type: OmadaOfflineMessage,
+		Text: wasOffline,
+	},
+	{
+		Type: OmadaOnlineMessage,
+		Text: wasOnline,
+	},
+	{
+		Type: OmadaVPNDownMessage,
+		Text: regexp.MustCompile(`(?i)\bVPN (tunnel|connection) \[[^\]]+\].* (was|is) (disconnected|down|terminated)\b`),
+	},
+	{
+		Type: OmadaVPNUpMessage,
+		Text: regexp.MustCompile(`(?i)\bVPN (tunnel|connection) \[[^\]]+\].* (was|is) (connected|established|up)\b`),
+	},
+	{
+		Type: OmadaAPDisconnectedMessage,
+		Text: regexp.MustCompile(`(?i)\[ap:[^\]]+\].*\b(was|is) (disconnected|offline|lost)\b`),
+	},
+	{
+		Type: OmadaAPConnectedMessage,
+		Text: regexp.MustCompile(`(?i)\[ap:[^\]]+\].*\b(was|is) (connected|adopted|online)\b`),
+	},
+	{
+		Type: OmadaClientRoamingMessage,
+		Text: regexp.MustCompile(`(?i)\] (is roaming|roamed) from \[ap:`),
+	},
+	{
+		Type: OmadaDHCPExhaustedMessage,
+		Text: regexp.MustCompile(`(?i)(\bDHCP server of \[[^\]]+\] (has run|ran) out of IP addresses\b|\bIP address pool of \[[^\]]+\] (is|was) (exhausted|full)\b)`),
+	},
+	{
+		Type: OmadaRogueAPMessage,
+		Text: regexp.MustCompile(`(?i)\bdetected an? rogue (AP|access point)\b`),
+	},
+	{
+		Type: OmadaPoEOverloadMessage,
+		Text: regexp.MustCompile(`(?i)\bPoE power of \[[^\]]+\] (exceeds|exceeded|is over) the power budget\b`),
+	},
+	{
+		Type: OmadaIntrusionMessage,
+		// The abbreviations only in capitals, there are "IPs" and "ids" enough.
+		Text: regexp.MustCompile(`\b(IPS|IDS) (detected|blocked)\b|(?i:\bintrusion (was )?(detected|blocked)\b)`),
+	},
+	{
+		Type: OmadaLoginFailedMessage,
+		Text: regexp.MustCompile(`(?i)\bfailed to log ?in to the controller\b`),
+	},
+	{
+		Type: OmadaFirmwareUpgradeMessage,
+		Text: regexp.MustCompile(`(?i)\[(ap|switch|gateway):[^\]]+\]:? (was|has been) upgraded\b.*\bfirmware\b`),
+	},
+	{
+		Type: OmadaLogUploadFailedMessage,
+		Text: regexp.MustCompile(`(?i)failed to send (site )?logs\b`),
+	},
+}
+
+// EOF
@@ -0,0 +1,89 @@
+package omada_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// Every sample payload in testdata/events/<type name>/ (captured) and
+// testdata/reconstructed/<type name>/ must be detected as that type. Add
+// captures of new messages to the former as they are seen.
+func TestMessageCatalogue(t *testing.T) {
+	samples := []string{}
+	for _, dir := range []string{"events", "reconstructed"} {
+		found, err := filepath.Glob(filepath.Join("testdata", dir, "*", "*.json"))
+		if err != nil {
+			t.Fatalf("Could not list the sample payloads: %v", err)
+		}
+
+		if len(found) == 0 {
+			t.Fatalf("No sample payloads found in testdata/%v", dir)
+		}
+
+		samples = append(samples, found...)
+	}
+
+	for _, sample := range samples {
+		want := filepath.Base(filepath.Dir(sample))
+
+		t.Run(filepath.Base(filepath.Dir(filepath.Dir(sample)))+"/"+want+"/"+filepath.Base(sample), func(t *testing.T) {
+			logger := testLogger(t)
+
+			body, err := os.ReadFile(sample)
+			if err != nil {
+				t.Fatalf("Could not read sample: %v", err)
+			}
+
+			msg, err := omada.ParseMessage(logger, body)
+			if err != nil {
+				t.Fatalf("Could not parse sample: %v", err)
+			}
+
+			if got := msg.TypeName(); got != want {
+				t.Errorf("Sample was detected as `%v`, want `%v`", got, want)
+			}
+		})
+	}
+}
+
+func TestMessageTypePriorities(t *testing.T) {
+	tests := []struct {
+		msgType  omada.OmadaMessageType
+		name     string
+		priority int
+	}{
+		{omada.UnrecognisedMessage, "unrecognised", 4},
+		{omada.OmadaTestMessage, "test", 0},
+		{omada.OmadaOfflineMessage, "offline", 10},
+		{omada.OmadaOnlineMessage, "online", 7},
+		{omada.OmadaAPDisconnectedMessage, "ap-disconnected", 8},
+		{omada.OmadaAPConnectedMessage, "ap-connected", 5},
+		{omada.OmadaClientRoamingMessage, "client-roaming", 1},
+		{omada.OmadaDHCPExhaustedMessage, "dhcp-exhausted", 8},
+		{omada.OmadaFirmwareUpgradeMessage, "firmware-upgrade", 3},
+		{omada.OmadaRogueAPMessage, "rogue-ap", 8},
+		{omada.OmadaPoEOverloadMessage, "poe-overload", 8},
+		{omada.OmadaVPNUpMessage, "vpn-up", 5},
+		{omada.OmadaVPNDownMessage, "vpn-down", 9},
+		{omada.OmadaIntrusionMessage, "intrusion", 9},
+		{omada.OmadaLoginFailedMessage, "login-failed", 8},
+		{omada.OmadaLogUploadFailedMessage, "log-upload-failed", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.msgType.String(); got != tt.name {
+				t.Errorf("String() = %v, want %v", got, tt.name)
+			}
+
+			if got := omada.DefaultPriority(tt.msgType); got != tt.priority {
+				t.Errorf("DefaultPriority() = %v, want %v", got, tt.priority)
+			}
+		})
+	}
+}
+
+// EOF
@@ -0,0 +1,148 @@
+package omada
+
+import (
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The entities mentioned in the text of a message, such as the devices and
+// ports involved. Omada refers to these in a fairly consistent way, e.g.:
+//
+//	[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.
+//
+// which mentions a gateway with its MAC address, and a port named 2.5G WAN1.
+// Every list holds each value only once, in the order they were found.
+type Entities struct {
+	Devices []Device `json:"devices,omitempty"`
+	MACs    []string `json:"macs,omitempty"`
+	IPv4    []string `json:"ipv4,omitempty"`
+	IPv6    []string `json:"ipv6,omitempty"`
+	Ports   []string `json:"ports,omitempty"`
+	SSIDs   []string `json:"ssids,omitempty"`
+	Clients []string `json:"clients,omitempty"`
+}
+
+// A device as referred to by Omada, e.g. `[gateway:98-03-8E-3A-8D-53]` or
+// `[client:Pixel-8:D2-41-5F-0A-9B-11]`. Either the name or the MAC address
+// can be missing.
+type Device struct {
+	Role string `json:"role"`
+	Name string `json:"name,omitempty"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+// The first device mentioned, which is usually the one the message is about.
+func (e Entities) Device() (Device, bool) {
+	if len(e.Devices) == 0 {
+		return Device{}, false
+	}
+	return e.Devices[0], true
+}
+
+// The first port mentioned, which is usually the one the message is about.
+func (e Entities) Port() string {
+	if len(e.Ports) == 0 {
+		return ""
+	}
+	return e.Ports[0]
+}
+
+// The entities mentioned in the text (and description) of the message.
+func (msg OmadaMessage) Entities() Entities {
+	return ExtractEntities(append([]string{msg.Description}, msg.Text...)...)
+}
+
+var (
+	deviceRe = regexp.MustCompile(`\[(gateway|switch|ap|client|olt|controller):([^\]]+)\]`)
+	macRe    = regexp.MustCompile(`\b[0-9A-Fa-f]{2}(?:[-:][0-9A-Fa-f]{2}){5}\b`)
+	ipv4Re   = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Re   = regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f:.]*:[0-9A-Fa-f.]*`)
+	ssidRe   = regexp.MustCompile(`(?i)\b(?:SSID|rogue AP)\s*\[([^\]]+)\]`)
+
+	// Any other name in brackets is a port or interface when it looks like one.
+	bracketRe = regexp.MustCompile(`\[([^\]:]+)\]`)
+	portRe    = regexp.MustCompile(`(?i)(\bWAN|\bLAN|\bport\b|\bSFP|\bVLAN\b|\buplink\b|^(eth|ge|xe|te)\d)`)
+)
+
+// ExtractEntities finds the entities mentioned in the given lines of text.
+func ExtractEntities(lines ...string) Entities {
+	e := Entities{}
+
+	for _, line := range lines {
+		for _, match := range deviceRe.FindAllStringSubmatch(line, -1) {
+			device := parseDevice(match[1], match[2])
+			if !slices.Contains(e.Devices, device) {
+				e.Devices = append(e.Devices, device)
+			}
+			if device.Role == "client" && device.Name != "" {
+				e.Clients = appendUnique(e.Clients, device.Name)
+			}
+		}
+
+		for _, mac := range macRe.FindAllString(line, -1) {
+			e.MACs = appendUnique(e.MACs, normaliseMAC(mac))
+		}
+
+		for _, ip := range ipv4Re.FindAllString(line, -1) {
+			if parsed := net.ParseIP(ip); parsed != nil {
+				e.IPv4 = appendUnique(e.IPv4, ip)
+			}
+		}
+
+		for _, candidate := range ipv6Re.FindAllString(line, -1) {
+			candidate = strings.TrimRight(candidate, ".:")
+			if macRe.MatchString(candidate) {
+				continue
+			}
+			if parsed := net.ParseIP(candidate); parsed != nil && parsed.To4() == nil {
+				e.IPv6 = appendUnique(e.IPv6, candidate)
+			}
+		}
+
+		ssids := []string{}
+		for _, match := range ssidRe.FindAllStringSubmatch(line, -1) {
+			ssids = append(ssids, match[1])
+			e.SSIDs = appendUnique(e.SSIDs, match[1])
+		}
+
+		for _, match := range bracketRe.FindAllStringSubmatch(line, -1) {
+			if !slices.Contains(ssids, match[1]) && portRe.MatchString(match[1]) {
+				e.Ports = appendUnique(e.Ports, match[1])
+			}
+		}
+	}
+
+	return e
+}
+
+// The part after the role is either a MAC address, a name, or a name and
+// a MAC address separated by a colon or in parentheses.
+func parseDevice(role, value string) Device {
+	device := Device{Role: role}
+
+	if mac := macRe.FindString(value); mac != "" {
+		device.MAC = normaliseMAC(mac)
+		value = strings.Replace(value, mac, "", 1)
+	}
+
+	device.Name = strings.Trim(value, " :()")
+
+	return device
+}
+
+// Omada writes MAC addresses in uppercase with dashes; use that everywhere
+// so the same address is always written the same way.
+func normaliseMAC(mac string) string {
+	return strings.ToUpper(strings.ReplaceAll(mac, ":", "-"))
+}
+
+func appendUnique(list []string, value string) []string {
+	if slices.Contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
+// EOF
@@ -0,0 +1,96 @@
+package omada_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+func TestExtractEntities(t *testing.T) {
+	tests := []struct {
+		name string
+		text []string
+		want omada.Entities
+	}{
+		{
+			name: "WAN offline",
+			text: []string{
+				"[2.5G WAN1] of [gateway:98-03-8E-3A-8D-53] is down.\r",
+				"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\r",
+			},
+			want: omada.Entities{
+				Devices: []omada.Device{{Role: "gateway", MAC: "98-03-8E-3A-8D-53"}},
+				MACs:    []string{"98-03-8E-3A-8D-53"},
+				Ports:   []string{"2.5G WAN1"},
+			},
+		},
+		{
+			name: "Client roaming",
+			text: []string{"[client:Pixel-8:D2-41-5F-0A-9B-11] is roaming from [ap:60-A4-B7-1C-2D-3E] to [ap:60-a4-b7-1c-2d-4f] with SSID [Office WiFi].\r"},
+			want: omada.Entities{
+				Devices: []omada.Device{
+					{Role: "client", Name: "Pixel-8", MAC: "D2-41-5F-0A-9B-11"},
+					{Role: "ap", MAC: "60-A4-B7-1C-2D-3E"},
+					{Role: "ap", MAC: "60-A4-B7-1C-2D-4F"},
+				},
+				MACs:    []string{"D2-41-5F-0A-9B-11", "60-A4-B7-1C-2D-3E", "60-A4-B7-1C-2D-4F"},
+				SSIDs:   []string{"Office WiFi"},
+				Clients: []string{"Pixel-8"},
+			},
+		},
+		{
+			name: "Addresses",
+			text: []string{"IDS detected a suspicious connection from 198.51.100.23 to 2001:db8::10 (aa:bb:cc:dd:ee:ff) on [Port 5]."},
+			want: omada.Entities{
+				MACs:  []string{"AA-BB-CC-DD-EE-FF"},
+				IPv4:  []string{"198.51.100.23"},
+				IPv6:  []string{"2001:db8::10"},
+				Ports: []string{"Port 5"},
+			},
+		},
+		{
+			name: "Named device and rogue AP",
+			text: []string{"[ap:EAP245 Office(60-A4-B7-1C-2D-3E)] detected a rogue AP [FreeWiFi] on channel 6, firmware 1.0.3."},
+			want: omada.Entities{
+				Devices: []omada.Device{{Role: "ap", Name: "EAP245 Office", MAC: "60-A4-B7-1C-2D-3E"}},
+				MACs:    []string{"60-A4-B7-1C-2D-3E"},
+				SSIDs:   []string{"FreeWiFi"},
+			},
+		},
+		{
+			name: "Nothing to find",
+			text: []string{"Something happened at 10:15:04."},
+			want: omada.Entities{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := omada.ExtractEntities(tt.text...)
+
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Errorf("ExtractEntities() test failed: %v", diff)
+			}
+		})
+	}
+}
+
+func TestOmadaMessage_Entities(t *testing.T) {
+	msg := omada.OmadaMessage{
+		Text: []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\r"},
+	}
+
+	entities := msg.Entities()
+
+	device, ok := entities.Device()
+	if !ok || device.Role != "gateway" || device.MAC != "98-03-8E-3A-8D-53" {
+		t.Errorf("Device() = %+v, %v", device, ok)
+	}
+
+	if port := entities.Port(); port != "2.5G WAN1" {
+		t.Errorf("Port() = %q", port)
+	}
+}
+
+// EOF
@@ -0,0 +1,148 @@
+package omada_test
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+func TestParseGoogleChatMessage(t *testing.T) {
+	t.Setenv("TZ", "UTC")
+
+	tests := []struct {
+		name     string
+		body     []byte
+		want     *omada.OmadaMessage
+		wantType omada.OmadaMessageType
+		wantErr  bool
+	}{
+		{
+			name: "offline message",
+			body: []byte(`{"text":"*Omada Controller_347044*\nSite: Home\nTime: 2025-09-26 02:15:04\n[2.5G WAN1] of [gateway:98-03-8E-3A-8D-53] is down.\n[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\n"}`),
+			want: &omada.OmadaMessage{
+				Controller: "Omada Controller_347044",
+				Site:       "Home",
+				Text: []string{
+					"[2.5G WAN1] of [gateway:98-03-8E-3A-8D-53] is down.",
+					"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.",
+				},
+				Timestamp: time.Date(2025, 9, 26, 2, 15, 4, 0, time.Local).UnixMilli(),
+			},
+			wantType: omada.OmadaOfflineMessage,
+		},
+		{
+			name: "online message with labelled controller",
+			body: []byte(`{"text":"Controller: Omada Controller_347044\nSite: Home\n[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was online."}`),
+			want: &omada.OmadaMessage{
+				Controller: "Omada Controller_347044",
+				Site:       "Home",
+				Text:       []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was online."},
+			},
+			wantType: omada.OmadaOnlineMessage,
+		},
+		{
+			name: "test message",
+			body: []byte(`{"text":"This is a webhook test message. Please ignore this"}`),
+			want: &omada.OmadaMessage{
+				Description: "This is a webhook test message. Please ignore this",
+			},
+			wantType: omada.OmadaTestMessage,
+		},
+		{
+			name:    "invalid JSON",
+			body:    []byte(`{"text": "unterminated`),
+			want:    &omada.OmadaMessage{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		logger := testLogger(t)
+
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := omada.ParseGoogleChatMessage(logger, tt.body)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGoogleChatMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Errorf("ParseGoogleChatMessage() test failed: %v", diff)
+			}
+
+			if !tt.wantErr && got.Type() != tt.wantType {
+				t.Errorf("Type() = %v, want %v", got.Type(), tt.wantType)
+			}
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want *omada.OmadaMessage
+
+		// The parser ParseMessage should have picked for the format.
+		parser func(*slog.Logger, []byte) (*omada.OmadaMessage, error)
+	}{
+		{
+			name: "Omada format",
+			body: []byte(`{"Site":"Home","text":["Alert occurred"],"Controller":"Test Controller"}`),
+			want: &omada.OmadaMessage{
+				Controller: "Test Controller",
+				Site:       "Home",
+				Text:       []string{"Alert occurred"},
+			},
+			parser: omada.ParseOmadaMessage,
+		},
+		{
+			name: "Omada format without text",
+			body: []byte(`{"description":"This is a webhook test message. Please ignore this","shardSecret":"xxyyzz"}`),
+			want: &omada.OmadaMessage{
+				Description: "This is a webhook test message. Please ignore this",
+			},
+			parser: omada.ParseOmadaMessage,
+		},
+		{
+			name: "Google Chat format",
+			body: []byte(`{"text":"*Test Controller*\nSite: Home\nAlert occurred"}`),
+			want: &omada.OmadaMessage{
+				Controller: "Test Controller",
+				Site:       "Home",
+				Text:       []string{"Alert occurred"},
+			},
+			parser: omada.ParseGoogleChatMessage,
+		},
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := omada.ParseMessage(logger, tt.body)
+			if err != nil {
+				t.Fatalf("ParseMessage() failed: %v", err)
+			}
+
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Errorf("ParseMessage() test failed: %v", diff)
+			}
+
+			// Only the parser for the detected format gives the same result.
+			want, err := tt.parser(logger, tt.body)
+			if err != nil {
+				t.Fatalf("Expected the %v parser to be used, it fails on the message: %v", tt.name, err)
+			}
+
+			if diff := deep.Equal(got, want); diff != nil {
+				t.Errorf("Expected the %v parser to be used: %v", tt.name, diff)
+			}
+		})
+	}
+}
+
+// EOF
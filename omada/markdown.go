@@ -0,0 +1,166 @@
+package omada
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MarkdownBody is the Body formatted as Markdown, for clients that render
+// it (Gotify does when told to through the `client::display` extra). MAC
+// and IP addresses are put in inline code, devices are shown in bold with
+// their address, and the time of the event becomes a footer.
+//
+// Anything else which could be taken for formatting is escaped, except in
+// the body template of a matching rule: that is written in Markdown, and
+// gets the values from the message escaped (and .Body formatted as above).
+func (msg OmadaMessage) MarkdownBody() string {
+	c := msg.classification()
+	paragraphs := markdownParagraphs(msg.defaultText(c.Type))
+
+	if c.Rule != nil && c.Rule.body != nil {
+		data := msg.templateData(c).markdown()
+		data.Body = strings.Join(paragraphs, "\n\n")
+		paragraphs = []string{c.rules.render(c.Rule, c.Rule.body, data, data.Body)}
+	}
+
+	if msg.Outage != nil {
+		paragraphs = append(paragraphs, escapeMarkdown(msg.Outage.Summary()))
+	}
+
+	if msg.Timestamp > 0 {
+		t := time.UnixMilli(msg.Timestamp)
+		paragraphs = append(paragraphs, "---", fmt.Sprintf("*%v*", escapeMarkdown(HumanReadableTimestamp(t))))
+	}
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// Everything worth formatting in a line: devices, MAC addresses and both
+// kinds of IP addresses (the latter matched loosely and checked later).
+var markdownTokenRe = regexp.MustCompile(strings.Join([]string{
+	deviceRe.String(),
+	macRe.String(),
+	ipv4Re.String(),
+	ipv6Re.String(),
+}, "|"))
+
+// A paragraph for each line of text, leaving out the empty ones.
+func markdownParagraphs(lines []string) []string {
+	paragraphs := []string{}
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			paragraphs = append(paragraphs, markdownLine(line))
+		}
+	}
+	return paragraphs
+}
+
+func markdownLine(line string) string {
+	var out strings.Builder
+	last := 0
+
+	for _, loc := range markdownTokenRe.FindAllStringIndex(line, -1) {
+		out.WriteString(escapeMarkdown(line[last:loc[0]]))
+		out.WriteString(markdownToken(line[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+
+	out.WriteString(escapeMarkdown(line[last:]))
+
+	return out.String()
+}
+
+func markdownToken(token string) string {
+	if match := deviceRe.FindStringSubmatch(token); match != nil {
+		device := parseDevice(match[1], match[2])
+
+		name := device.Name
+		if name == "" {
+			name = device.Role
+		}
+
+		if device.MAC == "" {
+			return fmt.Sprintf("**%v**", escapeMarkdown(name))
+		}
+		return fmt.Sprintf("**%v** `%v`", escapeMarkdown(name), device.MAC)
+	}
+
+	if macRe.MatchString(token) {
+		return "`" + token + "`"
+	}
+
+	// The IPv6 pattern can match more than an address, such as a trailing
+	// colon; only the address itself goes into the code span.
+	trimmed := strings.TrimRight(token, ".:")
+	if net.ParseIP(trimmed) != nil {
+		return "`" + trimmed + "`" + escapeMarkdown(token[len(trimmed):])
+	}
+
+	return escapeMarkdown(token)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`#`, `\#`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`(`, `\(`,
+	`)`, `\)`,
+	`<`, `&lt;`,
+	`>`, `&gt;`,
+)
+
+// Escapes the characters that would otherwise change the formatting, or
+// make a link.
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+func escapeMarkdownAll(list []string) []string {
+	if list == nil {
+		return nil
+	}
+
+	escaped := make([]string, len(list))
+	for i, text := range list {
+		escaped[i] = escapeMarkdown(text)
+	}
+	return escaped
+}
+
+// The template data with everything that came from the message escaped,
+// for templates written in Markdown.
+func (data RuleTemplateData) markdown() RuleTemplateData {
+	data.Controller = escapeMarkdown(data.Controller)
+	data.Site = escapeMarkdown(data.Site)
+	data.Description = escapeMarkdown(data.Description)
+	data.Text = escapeMarkdownAll(data.Text)
+	data.Title = escapeMarkdown(data.Title)
+	data.Body = escapeMarkdown(data.Body)
+
+	e := data.Entities
+	var devices []Device
+	for _, device := range e.Devices {
+		devices = append(devices, Device{Role: escapeMarkdown(device.Role), Name: escapeMarkdown(device.Name), MAC: escapeMarkdown(device.MAC)})
+	}
+
+	data.Entities = Entities{
+		Devices: devices,
+		MACs:    escapeMarkdownAll(e.MACs),
+		IPv4:    escapeMarkdownAll(e.IPv4),
+		IPv6:    escapeMarkdownAll(e.IPv6),
+		Ports:   escapeMarkdownAll(e.Ports),
+		SSIDs:   escapeMarkdownAll(e.SSIDs),
+		Clients: escapeMarkdownAll(e.Clients),
+	}
+
+	return data
+}
+
+// EOF
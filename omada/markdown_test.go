@@ -0,0 +1,103 @@
+package omada_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+func TestOmadaMessage_MarkdownBody(t *testing.T) {
+	t.Setenv("TZ", "UTC")
+
+	tests := []struct {
+		name    string
+		message *omada.OmadaMessage
+		want    string
+	}{
+		{
+			name: "WAN offline",
+			message: &omada.OmadaMessage{
+				Text: []string{
+					"[2.5G WAN1] of [gateway:98-03-8E-3A-8D-53] is down.\r",
+					"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\r",
+				},
+				Timestamp: 1758852904877,
+			},
+			want: fmt.Sprintf("\\[2.5G WAN1\\] of **gateway** `98-03-8E-3A-8D-53` is down.\n\n"+
+				"**gateway** `98-03-8E-3A-8D-53`: The online detection result of \\[2.5G WAN1\\] was offline.\n\n"+
+				"---\n\n*%v*", omada.HumanReadableTimestamp(time.UnixMilli(1758852904877))),
+		},
+		{
+			name: "Addresses and a named device",
+			message: &omada.OmadaMessage{
+				Text: []string{"[client:my_phone:D2-41-5F-0A-9B-11] got 192.168.0.23 and 2001:db8::10 from AA:BB:CC:DD:EE:FF."},
+			},
+			want: "**my\\_phone** `D2-41-5F-0A-9B-11` got `192.168.0.23` and `2001:db8::10` from `AA:BB:CC:DD:EE:FF`.",
+		},
+		{
+			name: "Test message",
+			message: &omada.OmadaMessage{
+				Description: "This is a webhook test message. Please ignore this",
+			},
+			want: "This is a webhook test message. Please ignore this",
+		},
+		{
+			name: "Formatting characters are escaped",
+			message: &omada.OmadaMessage{
+				Text: []string{"*Not* bold, `not` code at 10:15:04"},
+			},
+			want: "\\*Not\\* bold, \\`not\\` code at 10:15:04",
+		},
+		{
+			name: "Links are escaped",
+			message: &omada.OmadaMessage{
+				Text: []string{"Click [here](http://example.com/) or ![this](http://example.com/x.png)"},
+			},
+			want: "Click \\[here\\]\\(http://example.com/\\) or !\\[this\\]\\(http://example.com/x.png\\)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.message.MarkdownBody(); got != tt.want {
+				t.Errorf("MarkdownBody() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOmadaMessage_MarkdownBodyRuleTemplate(t *testing.T) {
+	rules, err := omada.ParseRules([]byte(`
+rules:
+  - match:
+      text: UPS
+    body: "**On battery** at {{ .Site }}: {{ .Body }} ([status](http://ups.local/))"
+`))
+	if err != nil {
+		t.Fatalf("ParseRules() failed: %v", err)
+	}
+
+	omada.SetRules(rules)
+	t.Cleanup(func() { omada.SetRules(nil) })
+
+	msg := &omada.OmadaMessage{
+		Site: "Rack_1",
+		Text: []string{"UPS [x](http://evil.example/) on battery"},
+	}
+
+	// The Markdown of the template is kept, what came from Omada is escaped.
+	want := "**On battery** at Rack\\_1: UPS \\[x\\]\\(http://evil.example/\\) on battery ([status](http://ups.local/))"
+	if got := msg.MarkdownBody(); got != want {
+		t.Errorf("MarkdownBody() = %q, want %q", got, want)
+	}
+
+	// Nothing is escaped in plain text.
+	wantPlain := "**On battery** at Rack_1: UPS [x](http://evil.example/) on battery ([status](http://ups.local/))"
+	if got := msg.Body(); got != wantPlain {
+		t.Errorf("Body() = %q, want %q", got, wantPlain)
+	}
+}
+
+// EOF
@@ -3,7 +3,7 @@ package omada
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"regexp"
 	"strings"
 	"time"
@@ -21,36 +21,121 @@ const (
 	OmadaTestMessage
 	OmadaOfflineMessage
 	OmadaOnlineMessage
+	OmadaAPDisconnectedMessage
+	OmadaAPConnectedMessage
+	OmadaClientRoamingMessage
+	OmadaDHCPExhaustedMessage
+	OmadaFirmwareUpgradeMessage
+	OmadaRogueAPMessage
+	OmadaPoEOverloadMessage
+	OmadaVPNUpMessage
+	OmadaVPNDownMessage
+	OmadaIntrusionMessage
+	OmadaLoginFailedMessage
+	OmadaLogUploadFailedMessage
+	LinkFlappingMessage
+	LinkSettledMessage
 )
 
 var omadaMessageTypeName = map[OmadaMessageType]string{
-	UnrecognisedMessage: "unrecognised",
-	OmadaTestMessage:    "test",
-	OmadaOfflineMessage: "offline",
-	OmadaOnlineMessage:  "online",
+	UnrecognisedMessage:         "unrecognised",
+	OmadaTestMessage:            "test",
+	OmadaOfflineMessage:         "offline",
+	OmadaOnlineMessage:          "online",
+	OmadaAPDisconnectedMessage:  "ap-disconnected",
+	OmadaAPConnectedMessage:     "ap-connected",
+	OmadaClientRoamingMessage:   "client-roaming",
+	OmadaDHCPExhaustedMessage:   "dhcp-exhausted",
+	OmadaFirmwareUpgradeMessage: "firmware-upgrade",
+	OmadaRogueAPMessage:         "rogue-ap",
+	OmadaPoEOverloadMessage:     "poe-overload",
+	OmadaVPNUpMessage:           "vpn-up",
+	OmadaVPNDownMessage:         "vpn-down",
+	OmadaIntrusionMessage:       "intrusion",
+	OmadaLoginFailedMessage:     "login-failed",
+	OmadaLogUploadFailedMessage: "log-upload-failed",
+	LinkFlappingMessage:         "link-flapping",
+	LinkSettledMessage:          "link-settled",
+}
+
+// The name of the message type as used in the logs (and configuration).
+// Only the rules that add a type know its name, see OmadaMessage.TypeName.
+func (t OmadaMessageType) String() string {
+	if name, ok := omadaMessageTypeName[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type-%d", int(t))
 }
 
 // Priorities were discussed by the Gotify author at:
 // https://github.com/gotify/android/issues/18#issuecomment-437403888
 var messageTypeToPriority = map[OmadaMessageType]int{
-	OmadaTestMessage:    0,  // Test messages are not important
-	UnrecognisedMessage: 4,  // Not specifically recognised, but still make it trigger a notification
-	OmadaOfflineMessage: 10, // Going offline seems important
-	OmadaOnlineMessage:  7,  // Back online is important too, not _as_ important?
+	OmadaTestMessage:            0,  // Test messages are not important
+	UnrecognisedMessage:         4,  // Not specifically recognised, but still make it trigger a notification
+	OmadaOfflineMessage:         10, // Going offline seems important
+	OmadaOnlineMessage:          7,  // Back online is important too, not _as_ important?
+	OmadaAPDisconnectedMessage:  8,  // Wi-Fi coverage is lost where this AP was
+	OmadaAPConnectedMessage:     5,  // Good to know, but nothing needs doing
+	OmadaClientRoamingMessage:   1,  // Happens all the time, only useful when looking for it
+	OmadaDHCPExhaustedMessage:   8,  // New clients can't get onto the network
+	OmadaFirmwareUpgradeMessage: 3,  // Usually the result of something an admin did
+	OmadaRogueAPMessage:         8,  // Possibly someone impersonating the network
+	OmadaPoEOverloadMessage:     8,  // Powered devices may be shut down by the switch
+	OmadaVPNUpMessage:           5,  // Like online, but a little less important
+	OmadaVPNDownMessage:         9,  // Like offline, but a little less important
+	OmadaIntrusionMessage:       9,  // Something on or towards the network is up to no good
+	OmadaLoginFailedMessage:     8,  // Someone may be guessing passwords
+	OmadaLogUploadFailedMessage: 3,  // Logs are missing, but nothing is broken
+	LinkFlappingMessage:         8,  // Sent once instead of a stream of offline/online messages
+	LinkSettledMessage:          7,  // Like online, the link is stable again
 }
 
 // OmadaMessage type and methods
 
-// The data structure for the JSON incoming from the Omada Controller webhook;
-// this must be configured as the "Omada format" (Google chat format is not supported).
+// The data structure for the JSON incoming from the Omada Controller webhook
+// when configured as the "Omada format"; the "Google Chat format" is parsed
+// into this same structure by ParseGoogleChatMessage.
 //
-// Any incoming fields not mentioned here aren't supported at this time.
+// Any incoming fields not mentioned here aren't supported at this time. The
+// fields Omada doesn't send are never read from (or written to) JSON; see
+// MessageRecord for storing them.
 type OmadaMessage struct {
 	Controller  string   `json:"Controller"`
 	Site        string   `json:"Site"`
 	Description string   `json:"description"`
 	Text        []string `json:"text"`
 	Timestamp   int64    `json:"timestamp"`
+
+	// Not sent by Omada; filled in when this message ends an outage.
+	Outage *Outage `json:"-"`
+
+	// Not sent by Omada; the name of the webhook endpoint the message came
+	// in on, empty for the default endpoint.
+	Endpoint string `json:"-"`
+
+	// Not sent by Omada; the ID of the webhook request the message came in
+	// with, logged with everything that happens to it.
+	RequestID string `json:"-"`
+
+	// Not sent by Omada; identifies the message in the history.
+	ID string `json:"-"`
+
+	// The type of a message made up by this program, see SetType.
+	fixedType    OmadaMessageType
+	hasFixedType bool
+
+	// What Classify made of the message.
+	class      classification
+	classified bool
+}
+
+// SetType gives a message made up by this program its type, rather than
+// leaving it to the catalogue to find it in the text; that way no message
+// Omada (or anyone else) sends can pass for one of these. Rules still apply.
+func (msg *OmadaMessage) SetType(t OmadaMessageType) {
+	msg.fixedType = t
+	msg.hasFixedType = true
+	msg.classified = false
 }
 
 // The title for the message as it will be sent to Gotify. Will take the name
@@ -61,10 +146,23 @@ type OmadaMessage struct {
 // These fields are not always available though. An empty Site will currently
 // be ignored; an empty Controller will be set to `Omada Webhook Test` if the
 // message is also detected to be a test message.
+//
+// A matching rule with a title template overrides all of this.
 func (msg OmadaMessage) Title() string {
+	c := msg.classification()
+	title := msg.defaultTitle(c.Type)
+
+	if c.Rule != nil && c.Rule.title != nil {
+		return c.rules.render(c.Rule, c.Rule.title, msg.templateData(c), title)
+	}
+
+	return title
+}
+
+func (msg OmadaMessage) defaultTitle(t OmadaMessageType) string {
 	controller := msg.Controller
 
-	if controller == "" && msg.Type() == OmadaTestMessage {
+	if controller == "" && t == OmadaTestMessage {
 		controller = "Omada Webhook Test"
 	}
 
@@ -82,14 +180,11 @@ func (msg OmadaMessage) Date() time.Time {
 	return time.Time(time.UnixMilli(msg.Timestamp))
 }
 
+// The text of the message as it will be sent to Gotify, followed by the time
+// at which the event took place. A matching rule with a body template
+// replaces the text, but the time is still added.
 func (msg OmadaMessage) Body() string {
-	messages := msg.Text
-
-	// An Omada controller initiated "test webhook" message does not get the usual
-	// body text, but it does have a description. Load that into the body instead.
-	if len(messages) == 0 && msg.Type() == OmadaTestMessage {
-		messages = append(messages, msg.Description)
-	}
+	messages := msg.bodyText()
 
 	if msg.Timestamp > 0 {
 		// The non-zero timestamp passed along to this message is a millisecond based epoch;
@@ -101,60 +196,180 @@ func (msg OmadaMessage) Body() string {
 	return strings.Join(messages, "\n")
 }
 
-// Get the type of the message by comparing the contents against known values.
+// The lines of text making up the body, without the time.
+func (msg OmadaMessage) bodyText() []string {
+	c := msg.classification()
+	messages := msg.defaultText(c.Type)
+
+	if c.Rule != nil && c.Rule.body != nil {
+		messages = []string{c.rules.render(c.Rule, c.Rule.body, msg.templateData(c), strings.Join(messages, "\n"))}
+	}
+
+	if msg.Outage != nil {
+		messages = append(messages, msg.Outage.Summary())
+	}
+
+	return messages
+}
+
+func (msg OmadaMessage) defaultText(t OmadaMessageType) []string {
+	messages := append([]string{}, msg.Text...)
+
+	// An Omada controller initiated "test webhook" message does not get the usual
+	// body text, but it does have a description. Load that into the body instead.
+	if len(messages) == 0 && t == OmadaTestMessage {
+		messages = append(messages, msg.Description)
+	}
+
+	return messages
+}
+
+func (msg OmadaMessage) templateData(c classification) RuleTemplateData {
+	return RuleTemplateData{
+		Controller:  msg.Controller,
+		Site:        msg.Site,
+		Description: msg.Description,
+		Text:        msg.Text,
+		Type:        c.name,
+		Priority:    c.priority(),
+		Title:       msg.defaultTitle(c.Type),
+		Body:        strings.Join(msg.defaultText(c.Type), "\n"),
+		Entities:    msg.Entities(),
+		Outage:      msg.Outage,
+	}
+}
+
+// Get the type of the message by comparing the contents against known values,
+// or as set by the first matching rule.
 func (msg OmadaMessage) Type() OmadaMessageType {
-	return parseTypeFromMessage(&msg)
+	return msg.classification().Type
 }
 
-// Determine the priority of the message base on the detected message type.
+// The name of the type of the message, as used in the logs (and
+// configuration); unlike Type().String() this knows the types rules add.
+func (msg OmadaMessage) TypeName() string {
+	return msg.classification().name
+}
+
+// Determine the priority of the message base on the detected message type,
+// unless a matching rule sets it.
 func (msg OmadaMessage) Priority() int {
-	return messageTypeToPriority[msg.Type()]
+	return msg.classification().priority()
+}
+
+// The name of the classification rule the message matches, empty when it
+// matches none (or the rule has no name).
+func (msg OmadaMessage) RuleName() string {
+	if rule := msg.classification().Rule; rule != nil {
+		return rule.Name
+	}
+	return ""
+}
+
+// The priority a message of the given type gets unless configured otherwise.
+// Types without a priority of their own (such as those added by rules) are
+// treated the same as unrecognised messages.
+func DefaultPriority(t OmadaMessageType) int {
+	if priority, ok := messageTypeToPriority[t]; ok {
+		return priority
+	}
+	return messageTypeToPriority[UnrecognisedMessage]
+}
+
+// The outcome of classifying a message: its type and the rule that matched
+// it, if any, along with the rules that matched and the name of the type.
+type classification struct {
+	Type OmadaMessageType
+	Rule *Rule
+
+	rules *RuleSet
+	name  string
+}
+
+func (c classification) priority() int {
+	if c.Rule != nil && c.Rule.Priority != nil {
+		return *c.Rule.Priority
+	}
+	return DefaultPriority(c.Type)
+}
+
+// Classify works out the type of the message, and the rule it matches, with
+// the given rules (nil for just the built-in catalogue). The type, priority,
+// title and body all follow from that one outcome, whatever rules are set
+// afterwards; classify the message again after changing it.
+//
+// A message which hasn't been classified is classified with the active
+// rules each time one of those is asked for.
+func (msg *OmadaMessage) Classify(rs *RuleSet) {
+	msg.class = classify(msg, rs)
+	msg.classified = true
+}
+
+func (msg OmadaMessage) classification() classification {
+	if msg.classified {
+		return msg.class
+	}
+	return classify(&msg, ActiveRules())
+}
+
+// Rules come first; a rule without a type of its own keeps the type the
+// built-in catalogue finds, so it can just change the priority or text.
+func classify(msg *OmadaMessage, rs *RuleSet) classification {
+	c := classification{
+		Type:  parseTypeFromMessage(msg),
+		Rule:  rs.match(msg),
+		rules: rs,
+	}
+
+	if msg.hasFixedType {
+		c.Type = msg.fixedType
+	}
+
+	if c.Rule != nil {
+		if t, ok := rs.messageType(c.Rule); ok {
+			c.Type = t
+		}
+	}
+
+	c.name = rs.typeName(c.Type)
+	return c
 }
 
 // Functions
 
 var shardSecretRe = regexp.MustCompile(`"shardSecret":\s*"([^"]+)"`)
 
-func ParseOmadaMessage(out *log.Logger, body []byte) (*OmadaMessage, error) {
-	// It can be helpful to log the incoming JSON data for debugging purposes
-	// but should one need to share their messages with others it's not ideal
-	// that it has the 'shardSecret' within, so wipe this from the string.
-	sanitised := string(body)
-	sanitised = shardSecretRe.ReplaceAllString(sanitised, `"shardSecret":"****"`)
+// It can be helpful to log (or keep) the incoming JSON data for debugging
+// purposes but should one need to share their messages with others it's not
+// ideal that it has the 'shardSecret' within, so wipe this from the string.
+func Sanitise(body []byte) string {
+	return shardSecretRe.ReplaceAllString(string(body), `"shardSecret":"****"`)
+}
 
-	out.Printf("Processing incoming message: `%v`", sanitised)
+func ParseOmadaMessage(logger *slog.Logger, body []byte) (*OmadaMessage, error) {
+	sanitised := Sanitise(body)
+
+	logger.Debug("Processing incoming message", "payload", sanitised)
 
 	// Parse the JSON body data into the omadaMessage format, populating res
 	res := OmadaMessage{}
 	if err := json.Unmarshal(body, &res); err != nil {
-		out.Printf("Error decoding the message into the OmadaMessage format structure. Error: %v", err)
-		out.Printf("The message was: %v", sanitised)
+		logger.Error("Error decoding the message into the OmadaMessage format structure", "error", err, "payload", sanitised)
 		return &res, err
 	}
 
-	out.Printf("The message is detected to be of type `%v` and is given priority %v", omadaMessageTypeName[res.Type()], res.Priority())
+	res.Classify(ActiveRules())
+	logger.Info("Received a message", "type", res.TypeName(), "priority", res.Priority())
 
 	return &res, nil
 }
 
-var isATestMessage = regexp.MustCompile(`webhook test message[.] Please ignore`)
-var wasOnline = regexp.MustCompile(`The online detection result of \[.+\] was online`)
-var wasOffline = regexp.MustCompile(`The online detection result of \[.+\] was offline`)
-
 // Inspect the given message and return what type the message
 // is expected to be based on its findings.
 func parseTypeFromMessage(msg *OmadaMessage) OmadaMessageType {
-	if isATestMessage.MatchString(msg.Description) {
-		return OmadaTestMessage
-	}
-
-	for _, text := range msg.Text {
-		if wasOffline.MatchString(text) {
-			return OmadaOfflineMessage
-		}
-
-		if wasOnline.MatchString(text) {
-			return OmadaOnlineMessage
+	for _, matcher := range messageCatalogue {
+		if matcher.matches(msg) {
+			return matcher.Type
 		}
 	}
 
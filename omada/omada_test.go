@@ -3,7 +3,7 @@ package omada_test
 import (
 	"bytes"
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
 	"testing"
 	"time"
@@ -106,6 +106,28 @@ func TestTypeOmadaMessage(t *testing.T) {
 				Type:     omada.OmadaTestMessage,
 			},
 		},
+		{
+			name: "Online message ending an outage",
+			message: &omada.OmadaMessage{
+				Controller: "Omada_Controller",
+				Site:       "Online Site",
+				Text: []string{
+					"[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was online.\r",
+				},
+				Timestamp: 1758852934790,
+				Outage: &omada.Outage{
+					Start: time.UnixMilli(1758852180790),
+					End:   time.UnixMilli(1758852934790),
+				},
+			},
+			want: &omadaMessageMethodValues{
+				Title:    "Omada_Controller: Online Site",
+				Body:     fmt.Sprintf("[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was online.\r\nOutage lasted 12m34s (down since %v)\nTimestamp: %v", omada.HumanReadableTimestamp(time.UnixMilli(1758852180790)), omada.HumanReadableTimestamp(time.UnixMilli(1758852934790))),
+				Priority: 7,
+				Date:     time.UnixMilli(1758852934790),
+				Type:     omada.OmadaOnlineMessage,
+			},
+		},
 		{
 			// This is not an actual message I've seen, but it's interesting
 			// to test the behaviour is as expected from it nonetheless.
@@ -261,7 +283,7 @@ func TestParseOmadaMessage(t *testing.T) {
 	for _, tt := range tests {
 		var (
 			buf    bytes.Buffer
-			logger = log.New(&buf, "logger: ", log.Lshortfile)
+			logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
 		)
 
 		t.Run(tt.name, func(t *testing.T) {
@@ -269,7 +291,7 @@ func TestParseOmadaMessage(t *testing.T) {
 
 			logged := buf.String()
 
-			if !strings.Contains(logged, `Processing incoming message:`) {
+			if !strings.Contains(logged, `msg="Processing incoming message"`) {
 				t.Errorf("logger output does not contain incoming message: %s", logged)
 			} else {
 				t.Logf("logger output contains incoming message: %s", logged)
@@ -285,7 +307,7 @@ func TestParseOmadaMessage(t *testing.T) {
 				t.Errorf("ParseOmadaMessage() test failed: %v", diff)
 			}
 
-			if !tt.wantErr && !strings.Contains(logged, `The message is detected to be of type`) {
+			if !tt.wantErr && !strings.Contains(logged, `msg="Received a message"`) {
 				t.Errorf("logger output does not contain info about the detection: %s", logged)
 			} else {
 				t.Logf("logger output contains info about the detection: %s", logged)
@@ -302,7 +324,7 @@ func TestSanitisation(t *testing.T) {
 
 	var (
 		buf    bytes.Buffer
-		logger = log.New(&buf, "logger: ", log.Lshortfile)
+		logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	)
 
 	// Create a test message to ensure the sanitization works correctly
@@ -313,8 +335,8 @@ func TestSanitisation(t *testing.T) {
 
 	logged := buf.String()
 
-	// Verify that shardSecret doesn't appear in the output
-	if strings.Contains(logged, `shardSecret`) && !strings.Contains(logged, `"shardSecret":"****"`) {
+	// Verify that the secret doesn't appear in the output
+	if strings.Contains(logged, `secret123`) || !strings.Contains(logged, `\"shardSecret\":\"****\"`) {
 		t.Fatalf("shardSecret` should not be logged; got `%v`", logged)
 	} else {
 		t.Logf("shardSecret` was sanitized correctly; got `%v`", logged)
@@ -379,6 +401,12 @@ func TestParseTypeFromMessage(t *testing.T) {
 	}
 }
 
+// A logger for the tests, writing to the output of the test so the log
+// shows up along with any failures (and with -v).
+func testLogger(t *testing.T) *slog.Logger {
+	return slog.New(slog.NewTextHandler(t.Output(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
 // EOF
 
 func TestOmadaMessage_Title(t *testing.T) {
@@ -406,7 +434,7 @@ func TestOmadaMessage_Title(t *testing.T) {
 	for _, tt := range tests {
 		var (
 			buf bytes.Buffer
-			out = log.New(&buf, "logger: ", log.Lshortfile)
+			out = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
 		)
 
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,31 @@
+package omada
+
+import (
+	"fmt"
+	"time"
+)
+
+// An Outage describes the downtime a message (such as an online message)
+// brings to an end. It is not something Omada sends, but is worked out by
+// pairing up messages; see the linkstate package.
+type Outage struct {
+	// When the outage began, i.e. the time of the message that started it.
+	Start time.Time `json:"start"`
+
+	// When the outage ended, i.e. the time of the message carrying this.
+	End time.Time `json:"end"`
+}
+
+// How long the outage lasted.
+func (o Outage) Duration() time.Duration {
+	return o.End.Sub(o.Start)
+}
+
+// A line for the body of the message, such as:
+//
+//	Outage lasted 12m34s (down since 2025-09-26 02:15:04 +0000 UTC)
+func (o Outage) Summary() string {
+	return fmt.Sprintf("Outage lasted %v (down since %v)", o.Duration().Round(time.Second), HumanReadableTimestamp(o.Start))
+}
+
+// EOF
@@ -0,0 +1,52 @@
+package omada
+
+// A MessageRecord is an OmadaMessage as it is stored, e.g. in the delivery
+// queue. As JSON the message only holds what Omada sends, so a payload can't
+// claim to be any more than that; the record holds what the message got
+// after it came in as well. It is written as a single object, the message
+// along with these fields.
+type MessageRecord struct {
+	OmadaMessage
+
+	ID        string  `json:"id,omitempty"`
+	Endpoint  string  `json:"endpoint,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+	Outage    *Outage `json:"outage,omitempty"`
+
+	// The name of the type given with SetType, if any.
+	Type string `json:"type,omitempty"`
+}
+
+// The record of the message, for storing it.
+func (msg OmadaMessage) Record() MessageRecord {
+	r := MessageRecord{
+		OmadaMessage: msg,
+		ID:           msg.ID,
+		Endpoint:     msg.Endpoint,
+		RequestID:    msg.RequestID,
+		Outage:       msg.Outage,
+	}
+
+	if msg.hasFixedType {
+		r.Type = msg.fixedType.String()
+	}
+
+	return r
+}
+
+// The message as it was stored.
+func (r MessageRecord) Message() *OmadaMessage {
+	msg := r.OmadaMessage
+	msg.ID = r.ID
+	msg.Endpoint = r.Endpoint
+	msg.RequestID = r.RequestID
+	msg.Outage = r.Outage
+
+	if t, ok := MessageTypeByName(r.Type); ok && r.Type != "" {
+		msg.SetType(t)
+	}
+
+	return &msg
+}
+
+// EOF
@@ -0,0 +1,345 @@
+package omada
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Rule classifies the messages it matches, overriding what the built-in
+// catalogue would make of them. Rules are normally loaded from a file with
+// LoadRules, which may be written in YAML or in JSON:
+//
+//	rules:
+//	  - name: Backup WAN is not important
+//	    match:
+//	      site: ^Home$
+//	      text: online detection result of \[WAN2\]
+//	    priority: 2
+//	  - name: UPS on battery
+//	    match:
+//	      text: UPS .* on battery
+//	    type: ups-on-battery
+//	    priority: 9
+//	    title: "{{ .Site }}: power failure"
+//
+// The match conditions are regular expressions, all of those given must
+// match; text matches when any one line of the text does. Title and body
+// are Go templates, see RuleTemplateData for what is available to them;
+// e.g. `{{ with .Entities.Port }}{{ . }} {{ end }}down` to name the port.
+type Rule struct {
+	Name     string    `yaml:"name" json:"name"`
+	Match    RuleMatch `yaml:"match" json:"match"`
+	Type     string    `yaml:"type" json:"type"`
+	Priority *int      `yaml:"priority" json:"priority"`
+	Title    string    `yaml:"title" json:"title"`
+	Body     string    `yaml:"body" json:"body"`
+
+	description *regexp.Regexp
+	text        *regexp.Regexp
+	controller  *regexp.Regexp
+	site        *regexp.Regexp
+	title       *template.Template
+	body        *template.Template
+}
+
+type RuleMatch struct {
+	Description string `yaml:"description" json:"description"`
+	Text        string `yaml:"text" json:"text"`
+	Controller  string `yaml:"controller" json:"controller"`
+	Site        string `yaml:"site" json:"site"`
+}
+
+// The data passed to the title and body templates of a rule. Title and Body
+// hold what would have been used without the rule.
+type RuleTemplateData struct {
+	Controller  string
+	Site        string
+	Description string
+	Text        []string
+	Type        string
+	Priority    int
+	Title       string
+	Body        string
+	Entities    Entities
+	Outage      *Outage
+}
+
+// An ordered list of rules; the first rule to match a message wins.
+type RuleSet struct {
+	Rules []*Rule `yaml:"rules" json:"rules"`
+
+	// Where templates which fail to render are logged, if anywhere.
+	Logger *slog.Logger `yaml:"-" json:"-"`
+
+	// The message types the rules set, by name and (for those the rules
+	// add) by number. The types the rules add are only known to them, so
+	// they are gone along with the rules once those are replaced.
+	types map[string]OmadaMessageType
+	names map[OmadaMessageType]string
+}
+
+// The types rules add are numbered from here on; every set of rules numbers
+// its own.
+var firstRuleType = OmadaMessageType(len(omadaMessageTypeName))
+
+// The rules currently in use, nil until SetRules is called.
+var activeRules atomic.Pointer[RuleSet]
+
+// Makes the given rules the ones used to classify messages from now on;
+// pass nil to go back to just the built-in catalogue. Safe to call while
+// messages are being processed.
+func SetRules(rs *RuleSet) {
+	activeRules.Store(rs)
+}
+
+// The rules currently in use, nil when only the built-in catalogue is used.
+func ActiveRules() *RuleSet {
+	return activeRules.Load()
+}
+
+// LoadRules reads, parses and validates a rules file.
+func LoadRules(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read rules file: %w", err)
+	}
+
+	rs, err := ParseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("rules file %v: %w", path, err)
+	}
+
+	return rs, nil
+}
+
+// ParseRules parses and validates rules given as YAML or JSON (the latter
+// being valid YAML too). Unknown fields are rejected to catch typos.
+func ParseRules(data []byte) (*RuleSet, error) {
+	rs := &RuleSet{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(rs); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse rules: %w", err)
+	}
+
+	if err := rs.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// Validate checks the rules and prepares them for use. ParseRules does this
+// already; it's needed for rules decoded as part of another file.
+func (rs *RuleSet) Validate() error {
+	return rs.compile()
+}
+
+// Validates every rule and prepares it for use, collecting all the errors
+// so they can be fixed in one go.
+func (rs *RuleSet) compile() error {
+	errs := []error{}
+	rs.types = map[string]OmadaMessageType{}
+	rs.names = map[OmadaMessageType]string{}
+
+	for i, rule := range rs.Rules {
+		if rule == nil {
+			errs = append(errs, fmt.Errorf("rule %d is empty", i+1))
+			continue
+		}
+
+		label := fmt.Sprintf("rule %d", i+1)
+		if rule.Name != "" {
+			label += fmt.Sprintf(" (%q)", rule.Name)
+		}
+
+		for _, err := range rule.compile() {
+			errs = append(errs, fmt.Errorf("%v: %w", label, err))
+		}
+
+		if rule.Type != "" {
+			rs.addType(rule.Type)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (rule *Rule) compile() []error {
+	errs := []error{}
+
+	pattern := func(field, expr string) *regexp.Regexp {
+		if expr == "" {
+			return nil
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %v pattern: %w", field, err))
+		}
+		return re
+	}
+
+	rule.description = pattern("description", rule.Match.Description)
+	rule.text = pattern("text", rule.Match.Text)
+	rule.controller = pattern("controller", rule.Match.Controller)
+	rule.site = pattern("site", rule.Match.Site)
+
+	if rule.Match == (RuleMatch{}) {
+		errs = append(errs, errors.New("no match conditions given"))
+	}
+
+	if rule.Priority != nil && (*rule.Priority < 0 || *rule.Priority > 10) {
+		errs = append(errs, fmt.Errorf("priority %d is out of range (0 to 10)", *rule.Priority))
+	}
+
+	if rule.Type != "" && !typeNameRe.MatchString(rule.Type) {
+		errs = append(errs, fmt.Errorf("type %q must be lowercase letters, digits and dashes", rule.Type))
+	}
+
+	var err error
+	if rule.Title != "" {
+		if rule.title, err = template.New("title").Option("missingkey=error").Parse(rule.Title); err != nil {
+			errs = append(errs, fmt.Errorf("invalid title template: %w", err))
+		}
+	}
+
+	if rule.Body != "" {
+		if rule.body, err = template.New("body").Option("missingkey=error").Parse(rule.Body); err != nil {
+			errs = append(errs, fmt.Errorf("invalid body template: %w", err))
+		}
+	}
+
+	return errs
+}
+
+func (rule *Rule) matches(msg *OmadaMessage) bool {
+	if rule.description != nil && !rule.description.MatchString(msg.Description) {
+		return false
+	}
+
+	if rule.controller != nil && !rule.controller.MatchString(msg.Controller) {
+		return false
+	}
+
+	if rule.site != nil && !rule.site.MatchString(msg.Site) {
+		return false
+	}
+
+	if rule.text != nil {
+		for _, text := range msg.Text {
+			if rule.text.MatchString(text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// Finds the first rule matching the message, if any.
+func (rs *RuleSet) match(msg *OmadaMessage) *Rule {
+	if rs == nil {
+		return nil
+	}
+
+	for _, rule := range rs.Rules {
+		if rule.matches(msg) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// Numbers the type with the given name: a built-in type keeps its own, a
+// type the rules add gets the next one after the built-in types and those
+// the rules added before it.
+func (rs *RuleSet) addType(name string) {
+	if _, ok := rs.types[name]; ok {
+		return
+	}
+
+	t, ok := MessageTypeByName(name)
+	if !ok {
+		t = firstRuleType + OmadaMessageType(len(rs.names))
+		rs.names[t] = name
+	}
+
+	rs.types[name] = t
+}
+
+// MessageTypeByName looks up the message type with the given name as the
+// rules know it, whether built-in or added by the rules.
+func (rs *RuleSet) MessageTypeByName(name string) (OmadaMessageType, bool) {
+	if rs != nil {
+		if t, ok := rs.types[name]; ok {
+			return t, true
+		}
+	}
+
+	return MessageTypeByName(name)
+}
+
+// The type the rule sets, if it sets one.
+func (rs *RuleSet) messageType(rule *Rule) (OmadaMessageType, bool) {
+	if rule.Type == "" {
+		return UnrecognisedMessage, false
+	}
+
+	t, ok := rs.types[rule.Type]
+	return t, ok
+}
+
+// The name of the type, which for the types the rules add only they know.
+func (rs *RuleSet) typeName(t OmadaMessageType) string {
+	if rs != nil {
+		if name, ok := rs.names[t]; ok {
+			return name
+		}
+	}
+
+	return t.String()
+}
+
+// Renders one of the rule's templates, falling back to the given value
+// should it fail; a broken template shouldn't lose the notification, but
+// it should be noticed.
+func (rs *RuleSet) render(rule *Rule, tmpl *template.Template, data RuleTemplateData, fallback string) string {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		if rs.Logger != nil {
+			rs.Logger.Warn("Could not render the template of a rule, using the default instead", "rule", rule.Name, "template", tmpl.Name(), "error", err)
+		}
+		return fallback
+	}
+	return out.String()
+}
+
+var typeNameRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// MessageTypeByName looks up the built-in message type with the given name;
+// see RuleSet.MessageTypeByName for the types rules add.
+func MessageTypeByName(name string) (OmadaMessageType, bool) {
+	for t, n := range omadaMessageTypeName {
+		if n == name {
+			return t, true
+		}
+	}
+
+	return UnrecognisedMessage, false
+}
+
+// EOF
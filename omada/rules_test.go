@@ -0,0 +1,293 @@
+package omada_test
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+const testRules = `
+rules:
+  - name: Backup WAN is not important
+    match:
+      site: ^Home$
+      text: online detection result of \[WAN2\]
+    priority: 2
+  - name: UPS on battery
+    match:
+      text: UPS .* on battery
+    type: ups-on-battery
+    priority: 9
+    title: "{{ .Site }}: power failure"
+    body: "{{ .Body }} (was {{ .Type }})"
+  - name: Lab controller
+    match:
+      controller: Lab
+    type: test
+`
+
+func TestRules(t *testing.T) {
+	rules, err := omada.ParseRules([]byte(testRules))
+	if err != nil {
+		t.Fatalf("ParseRules() failed: %v", err)
+	}
+
+	omada.SetRules(rules)
+	t.Cleanup(func() { omada.SetRules(nil) })
+
+	upsType, ok := rules.MessageTypeByName("ups-on-battery")
+	if !ok {
+		t.Fatal("The ups-on-battery type is not known to the rules")
+	}
+
+	tests := []struct {
+		name     string
+		message  *omada.OmadaMessage
+		want     *omadaMessageMethodValues
+		wantType omada.OmadaMessageType
+	}{
+		{
+			name: "Priority override keeps the built-in type",
+			message: &omada.OmadaMessage{
+				Controller: "Controller",
+				Site:       "Home",
+				Text:       []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [WAN2] was offline."},
+			},
+			want: &omadaMessageMethodValues{
+				Title:    "Controller: Home",
+				Body:     "[gateway:98-03-8E-3A-8D-53]: The online detection result of [WAN2] was offline.",
+				Priority: 2,
+				Type:     omada.OmadaOfflineMessage,
+			},
+		},
+		{
+			name: "All conditions must match",
+			message: &omada.OmadaMessage{
+				Controller: "Controller",
+				Site:       "Office",
+				Text:       []string{"[gateway:98-03-8E-3A-8D-53]: The online detection result of [WAN2] was offline."},
+			},
+			want: &omadaMessageMethodValues{
+				Title:    "Controller: Office",
+				Body:     "[gateway:98-03-8E-3A-8D-53]: The online detection result of [WAN2] was offline.",
+				Priority: 10,
+				Type:     omada.OmadaOfflineMessage,
+			},
+		},
+		{
+			name: "New type with title and body templates",
+			message: &omada.OmadaMessage{
+				Controller: "Controller",
+				Site:       "Office",
+				Text:       []string{"UPS in rack 1 is on battery"},
+			},
+			want: &omadaMessageMethodValues{
+				Title:    "Office: power failure",
+				Body:     "UPS in rack 1 is on battery (was ups-on-battery)",
+				Priority: 9,
+				Type:     upsType,
+			},
+		},
+		{
+			name: "Built-in type by name takes its default priority",
+			message: &omada.OmadaMessage{
+				Controller: "Lab",
+				Site:       "Bench",
+				Text:       []string{"Anything at all"},
+			},
+			want: &omadaMessageMethodValues{
+				Title:    "Lab: Bench",
+				Body:     "Anything at all",
+				Priority: 0,
+				Type:     omada.OmadaTestMessage,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.message.Type(); got != tt.want.Type {
+				t.Errorf("Type() = %v, want %v", got, tt.want.Type)
+			}
+
+			if got := tt.message.Priority(); got != tt.want.Priority {
+				t.Errorf("Priority() = %v, want %v", got, tt.want.Priority)
+			}
+
+			if got := tt.message.Title(); got != tt.want.Title {
+				t.Errorf("Title() = %q, want %q", got, tt.want.Title)
+			}
+
+			if got := tt.message.Body(); got != tt.want.Body {
+				t.Errorf("Body() = %q, want %q", got, tt.want.Body)
+			}
+		})
+	}
+}
+
+func TestParseRules_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  []string
+	}{
+		{
+			name:  "unknown field",
+			rules: "rules:\n  - name: typo\n    match:\n      txt: foo\n",
+			want:  []string{"field txt not found"},
+		},
+		{
+			name:  "no conditions",
+			rules: "rules:\n  - name: empty\n    priority: 3\n",
+			want:  []string{`rule 1 ("empty"): no match conditions given`},
+		},
+		{
+			name:  "several problems are all reported",
+			rules: "rules:\n  - match:\n      text: \"([\"\n    priority: 11\n  - match:\n      site: x\n    type: Not Valid\n    title: \"{{ .Site \"\n",
+			want: []string{
+				"rule 1: invalid text pattern",
+				"rule 1: priority 11 is out of range",
+				`rule 2: type "Not Valid" must be lowercase`,
+				"rule 2: invalid title template",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := omada.ParseRules([]byte(tt.rules))
+			if err == nil {
+				t.Fatal("ParseRules() accepted invalid rules")
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Error `%v` does not mention `%v`", err, want)
+				}
+			}
+		})
+	}
+}
+
+// The types rules add are only known to those rules, so replacing them
+// (e.g. on a reload) leaves nothing behind.
+func TestRules_TypesScopedToRuleSet(t *testing.T) {
+	ruleSet := func(typeName string) *omada.RuleSet {
+		rules, err := omada.ParseRules([]byte("rules:\n  - match:\n      text: fan\n    type: " + typeName + "\n"))
+		if err != nil {
+			t.Fatalf("ParseRules() failed: %v", err)
+		}
+		return rules
+	}
+
+	old, renamed := ruleSet("fan-failure"), ruleSet("fan-fault")
+
+	if _, ok := omada.MessageTypeByName("fan-failure"); ok {
+		t.Error("The fan-failure type is known outside of its rules")
+	}
+
+	if _, ok := renamed.MessageTypeByName("fan-failure"); ok {
+		t.Error("The fan-failure type is known to rules which don't add it")
+	}
+
+	if _, ok := renamed.MessageTypeByName("offline"); !ok {
+		t.Error("The built-in types are not known to the rules")
+	}
+
+	msg := &omada.OmadaMessage{Text: []string{"[switch:B0-19-21-AA-BB-CC]: fan failure"}}
+	msg.Classify(old)
+
+	omada.SetRules(renamed)
+	t.Cleanup(func() { omada.SetRules(nil) })
+
+	if got := msg.TypeName(); got != "fan-failure" {
+		t.Errorf("TypeName() = %v, want fan-failure", got)
+	}
+
+	unclassified := &omada.OmadaMessage{Text: msg.Text}
+	if got := unclassified.TypeName(); got != "fan-fault" {
+		t.Errorf("TypeName() = %v, want fan-fault", got)
+	}
+}
+
+// A template which fails when it is rendered is logged, and the default
+// used instead.
+func TestRules_TemplateFailureLogged(t *testing.T) {
+	rules, err := omada.ParseRules([]byte("rules:\n  - name: Broken\n    match:\n      text: fan\n    title: \"{{ .Outage.Start }}\"\n"))
+	if err != nil {
+		t.Fatalf("ParseRules() failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	rules.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	msg := &omada.OmadaMessage{Controller: "Controller", Site: "Office", Text: []string{"fan failure"}}
+	msg.Classify(rules)
+
+	if got := msg.Title(); got != "Controller: Office" {
+		t.Errorf("Title() = %q, want the default title", got)
+	}
+
+	if log := buf.String(); !strings.Contains(log, "rule=Broken") || !strings.Contains(log, "template=title") {
+		t.Errorf("Expected the failure to be logged with the rule, got %q", log)
+	}
+}
+
+// A message classified with some rules stays that way, whatever the rules
+// in use are by the time it is sent.
+func TestOmadaMessage_Classify(t *testing.T) {
+	ruleSet := func(priority int, title string) *omada.RuleSet {
+		rules, err := omada.ParseRules([]byte(fmt.Sprintf("rules:\n  - match:\n      text: UPS\n    priority: %d\n    title: %v\n", priority, title)))
+		if err != nil {
+			t.Fatalf("ParseRules() failed: %v", err)
+		}
+		return rules
+	}
+
+	old, updated := ruleSet(2, "Old"), ruleSet(9, "New")
+
+	msg := &omada.OmadaMessage{Text: []string{"UPS on battery"}}
+	msg.Classify(old)
+
+	omada.SetRules(updated)
+	t.Cleanup(func() { omada.SetRules(nil) })
+
+	if msg.Priority() != 2 || msg.Title() != "Old" {
+		t.Errorf("Expected the message to keep its classification, got priority %d and title %q", msg.Priority(), msg.Title())
+	}
+
+	unclassified := &omada.OmadaMessage{Text: []string{"UPS on battery"}}
+	if unclassified.Priority() != 9 || unclassified.Title() != "New" {
+		t.Errorf("Expected the active rules to be used, got priority %d and title %q", unclassified.Priority(), unclassified.Title())
+	}
+}
+
+func TestLoadRules_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	json := `{"rules": [{"name": "json rule", "match": {"description": "something"}, "priority": 6}]}`
+
+	if err := os.WriteFile(path, []byte(json), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := omada.LoadRules(path)
+	if err != nil {
+		t.Fatalf("LoadRules() failed: %v", err)
+	}
+
+	if len(rules.Rules) != 1 || rules.Rules[0].Name != "json rule" {
+		t.Errorf("LoadRules() did not load the rule: %+v", rules.Rules)
+	}
+
+	if _, err := omada.LoadRules(path + ".missing"); err == nil {
+		t.Error("LoadRules() did not fail on a missing file")
+	}
+}
+
+// EOF
@@ -0,0 +1,52 @@
+package omada
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// The fields Omada is known to send, for each format. The shardSecret is
+// not part of OmadaMessage, but it is known all the same.
+var (
+	omadaFields      = []string{"Controller", "Site", "description", "text", "timestamp", "shardSecret"}
+	googleChatFields = []string{"text"}
+)
+
+// UnknownFields returns the names of the top level fields of a message
+// which this program doesn't know about (in either format), sorted; those
+// are ignored when parsing, so this is how to find out that Omada started
+// sending something new. Names are matched without regard to case, just as
+// the JSON decoder does. A body that isn't a JSON object has none.
+func UnknownFields(body []byte) []string {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+
+	known := omadaFields
+	if isGoogleChatFormat(body) {
+		known = googleChatFields
+	}
+
+	unknown := []string{}
+	for name := range fields {
+		if !containsFold(known, name) {
+			unknown = append(unknown, name)
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// EOF
@@ -0,0 +1,52 @@
+package omada_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "Omada format with only known fields",
+			body: `{"Site":"Home","description":"d","shardSecret":"s","text":["x"],"Controller":"C","timestamp":1}`,
+			want: []string{},
+		},
+		{
+			name: "Omada format with new fields",
+			body: `{"Site":"Home","text":["x"],"_priority":3,"siteId":"abc","Controller":"C"}`,
+			want: []string{"_priority", "siteId"},
+		},
+		{
+			name: "Case doesn't matter",
+			body: `{"site":"Home","Text":["x"]}`,
+			want: []string{},
+		},
+		{
+			name: "Google Chat format",
+			body: `{"text":"*Controller*\nSite: Home","cards":[]}`,
+			want: []string{"cards"},
+		},
+		{
+			name: "Not an object",
+			body: `["x"]`,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := deep.Equal(omada.UnknownFields([]byte(tt.body)), tt.want); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// EOF
@@ -0,0 +1,218 @@
+// Package replay reads webhook payloads kept earlier, in the history or a
+// file of captured request bodies, so they can be run through parsing,
+// classification and routing again; to see what a change to the rules or
+// routes would do to real messages, or to send them again.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/history"
+)
+
+// Message is a payload to replay, along with its history entry when it
+// came from the history: the message as it was classified back then.
+type Message struct {
+	Line    int // in the file read, when read from one
+	Payload json.RawMessage
+	Entry   *history.Entry
+
+	// The endpoint the message is taken to have come in on, without an
+	// entry; the default one when empty.
+	Endpoint string
+}
+
+// Result is what became of a message replayed: how it is classified and
+// routed now, what changed since it was received (when that is known),
+// and when delivering, whether it was delivered.
+type Result struct {
+	Line       int       `json:"line,omitempty"`
+	ID         string    `json:"id,omitempty"` // of its history entry
+	Received   time.Time `json:"received"`
+	Endpoint   string    `json:"endpoint,omitempty"`
+	Controller string    `json:"controller"`
+	Site       string    `json:"site"`
+	Type       string    `json:"type"`
+	Priority   int       `json:"priority"`
+	Rule       string    `json:"rule,omitempty"`
+	Title      string    `json:"title"`
+	Body       string    `json:"body"`
+
+	// The Gotify route the message takes, and whether that drops it; no
+	// route when its endpoint doesn't send to Gotify.
+	Route   string `json:"route,omitempty"`
+	Dropped bool   `json:"dropped,omitempty"`
+
+	Changes []string `json:"changes,omitempty"`
+
+	// Only when delivering (see history.ResultDelivered and so on), or
+	// when the message couldn't be replayed.
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Read reads messages from JSON lines, each either a request body as
+// received from Omada, or a line of a history file; lines of the history
+// file about deliveries are skipped, as are entries without a payload.
+func Read(r io.Reader) ([]Message, error) {
+	messages := []Message{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 4*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		data := []byte(strings.TrimSpace(scanner.Text()))
+		if len(data) == 0 {
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("line %d is not a JSON object: %w", line, err)
+		}
+
+		if _, ok := fields["delivery"]; ok {
+			continue
+		}
+
+		if raw, ok := fields["entry"]; ok {
+			var entry history.Entry
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return nil, fmt.Errorf("line %d is not a history entry: %w", line, err)
+			}
+			if len(entry.Payload) > 0 {
+				messages = append(messages, Message{Line: line, Payload: entry.Payload, Entry: &entry})
+			}
+			continue
+		}
+
+		messages = append(messages, Message{Line: line, Payload: json.RawMessage(data)})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// FromHistory returns the messages for the entries with a payload, oldest
+// first; history.Store.Query returns them newest first.
+func FromHistory(entries []history.Entry) []Message {
+	messages := []Message{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if len(entries[i].Payload) > 0 {
+			messages = append(messages, Message{Payload: entries[i].Payload, Entry: &entries[i]})
+		}
+	}
+	return messages
+}
+
+// Compare lists what is different about the result from the entry, as the
+// message was classified and routed when it was received.
+func (r *Result) Compare(entry *history.Entry) {
+	if entry == nil {
+		return
+	}
+
+	change := func(what string, was, is any) {
+		r.Changes = append(r.Changes, fmt.Sprintf("%v: %v → %v", what, was, is))
+	}
+
+	if entry.Type != r.Type {
+		change("type", entry.Type, r.Type)
+	}
+	if entry.Priority != r.Priority {
+		change("priority", entry.Priority, r.Priority)
+	}
+	if entry.Rule != r.Rule {
+		change("rule", quote(entry.Rule), quote(r.Rule))
+	}
+	if entry.Title != r.Title {
+		change("title", quote(entry.Title), quote(r.Title))
+	}
+	if entry.Body != r.Body {
+		r.Changes = append(r.Changes, "body")
+	}
+
+	for _, d := range entry.Deliveries {
+		if d.Notifier != "gotify" || d.Route == "" {
+			continue
+		}
+
+		was, is := d.Route, r.Route
+		if d.Result == history.ResultDropped {
+			was += " (dropped)"
+		}
+		if r.Dropped {
+			is += " (dropped)"
+		}
+		if was != is {
+			change("route", was, is)
+		}
+	}
+}
+
+func quote(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// Print writes the result for people to read: the message as it would be
+// sent to Gotify, and what changed.
+func (r *Result) Print(w io.Writer) {
+	where := fmt.Sprintf("line %d", r.Line)
+	if r.ID != "" {
+		where = r.ID
+	}
+
+	if r.Error != "" && r.Title == "" {
+		fmt.Fprintf(w, "%v: %v\n\n", where, r.Error)
+		return
+	}
+
+	fmt.Fprintf(w, "%v  %v  %v, priority %d", where, r.Received.Local().Format("2006-01-02 15:04:05"), r.Type, r.Priority)
+	if r.Rule != "" {
+		fmt.Fprintf(w, ", rule %q", r.Rule)
+	}
+	switch {
+	case r.Dropped:
+		fmt.Fprintf(w, ", dropped by route %v", r.Route)
+	case r.Route != "":
+		fmt.Fprintf(w, ", route %v", r.Route)
+	default:
+		fmt.Fprint(w, ", not sent to Gotify")
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "  %v\n", r.Title)
+	for _, line := range strings.Split(strings.TrimRight(r.Body, "\n"), "\n") {
+		fmt.Fprintf(w, "  %v\n", line)
+	}
+
+	for _, c := range r.Changes {
+		fmt.Fprintf(w, "  changed %v\n", c)
+	}
+
+	if r.Result != "" {
+		fmt.Fprintf(w, "  %v", r.Result)
+		if r.Error != "" {
+			fmt.Fprintf(w, ": %v", r.Error)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w)
+}
+
+// EOF
@@ -0,0 +1,108 @@
+package replay_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/replay"
+)
+
+func TestRead(t *testing.T) {
+	input := strings.Join([]string{
+		`{"Controller":"Controller","Site":"Home","text":["Something happened."]}`,
+		``,
+		`{"entry":{"id":"abc","site":"Office","payload":{"Controller":"Controller","text":["Something else happened."]},"deliveries":[]}}`,
+		`{"id":"abc","delivery":{"notifier":"gotify","result":"delivered"}}`,
+		`{"entry":{"id":"def","title":"Made up","deliveries":[]}}`,
+	}, "\n")
+
+	messages, err := replay.Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("Expected a body and a history entry, got %+v", messages)
+	}
+
+	if messages[0].Line != 1 || messages[0].Entry != nil || !strings.Contains(string(messages[0].Payload), "Something happened.") {
+		t.Errorf("Expected the body on line 1, got %+v", messages[0])
+	}
+
+	if messages[1].Line != 3 || messages[1].Entry == nil || messages[1].Entry.ID != "abc" || !strings.Contains(string(messages[1].Payload), "Something else happened.") {
+		t.Errorf("Expected the history entry on line 3, got %+v", messages[1])
+	}
+
+	if _, err := replay.Read(strings.NewReader("{}\nnot JSON\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error for line 2, got %v", err)
+	}
+}
+
+func TestFromHistory(t *testing.T) {
+	entries := []history.Entry{
+		{ID: "newest", Payload: []byte(`{}`)},
+		{ID: "made-up"},
+		{ID: "oldest", Payload: []byte(`{}`)},
+	}
+
+	messages := replay.FromHistory(entries)
+	if len(messages) != 2 || messages[0].Entry.ID != "oldest" || messages[1].Entry.ID != "newest" {
+		t.Errorf("Expected the entries with a payload, oldest first, got %+v", messages)
+	}
+}
+
+func TestResult(t *testing.T) {
+	entry := &history.Entry{
+		Type:     "offline",
+		Priority: 8,
+		Title:    "Controller: Home",
+		Body:     "WAN1 went down",
+		Deliveries: []history.Delivery{
+			{Notifier: "ntfy", Result: history.ResultDelivered},
+			{Notifier: "gotify", Route: "WAN", Result: history.ResultDelivered},
+		},
+	}
+
+	result := replay.Result{
+		Line:     4,
+		Received: time.Date(2025, 9, 26, 4, 15, 0, 0, time.UTC),
+		Type:     "offline",
+		Priority: 10,
+		Rule:     "Urgent",
+		Title:    "Controller: Home",
+		Body:     "WAN1 went down",
+		Route:    "Quiet",
+		Dropped:  true,
+	}
+
+	result.Compare(entry)
+
+	want := []string{`priority: 8 → 10`, `rule: none → "Urgent"`, `route: WAN → Quiet (dropped)`}
+	if strings.Join(result.Changes, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected the changes %q, got %q", want, result.Changes)
+	}
+
+	var buf bytes.Buffer
+	result.Print(&buf)
+
+	for _, line := range []string{
+		`line 4  ` + result.Received.Local().Format("2006-01-02 15:04:05") + `  offline, priority 10, rule "Urgent", dropped by route Quiet`,
+		`  WAN1 went down`,
+		`  changed priority: 8 → 10`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected the line %q, got:\n%v", line, buf.String())
+		}
+	}
+
+	unchanged := replay.Result{Type: "offline", Priority: 8, Title: "Controller: Home", Body: "WAN1 went down", Route: "WAN"}
+	unchanged.Compare(entry)
+	if len(unchanged.Changes) != 0 {
+		t.Errorf("Expected no changes, got %q", unchanged.Changes)
+	}
+}
+
+// EOF
@@ -0,0 +1,125 @@
+// Package tlsconfig serves HTTPS with a certificate and key read from files,
+// reloading them when they change on disk, so that a renewed certificate
+// (say, by certbot or cert-manager) is picked up without a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/config"
+)
+
+// Reloader holds the certificate, and the CAs client certificates are
+// verified against when ClientCAFile is set.
+type Reloader struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	Logger       *slog.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// New loads the certificate and key, and the client CAs when a file for
+// them is given.
+func New(certFile, keyFile, clientCAFile string, logger *slog.Logger) (*Reloader, error) {
+	r := &Reloader{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: clientCAFile,
+		Logger:       logger,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the files again. When any of them can't be used, the ones
+// loaded before stay in use.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the TLS certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.ClientCAFile != "" {
+		data, err := os.ReadFile(r.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read the client CA file: %w", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return errors.New("the client CA file holds no PEM encoded certificates")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+
+	return nil
+}
+
+// Config is the TLS configuration for the server. It asks for the current
+// certificate (and client CAs) on every connection, so a reload applies to
+// the connections made after it.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+
+			if r.clientCAs != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = r.clientCAs
+			}
+
+			return cfg, nil
+		},
+	}
+}
+
+// Run reloads the files whenever they change, checking every interval,
+// until the context is cancelled.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	files := func() []string {
+		files := []string{r.CertFile, r.KeyFile}
+		if r.ClientCAFile != "" {
+			files = append(files, r.ClientCAFile)
+		}
+		return files
+	}
+
+	config.WatchFiles(ctx, interval, files, func() {
+		if err := r.Reload(); err != nil {
+			r.Logger.Error("Keeping the current TLS certificate", "error", err)
+			return
+		}
+		r.Logger.Info("Reloaded the TLS certificate")
+	})
+}
+
+// EOF
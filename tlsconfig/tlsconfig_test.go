@@ -0,0 +1,217 @@
+package tlsconfig_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/tlsconfig"
+)
+
+// A certificate with its key, signed by parent (or by itself without one).
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	if err := os.WriteFile(certFile, c.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPEM(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Starts an HTTPS server using the reloader, returning its URL.
+func serve(t *testing.T, r *tlsconfig.Reloader) string {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	server.TLS = r.Config()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// The common name of the certificate the server presents.
+func presented(t *testing.T, url string, roots *x509.CertPool, client *tls.Certificate) (string, error) {
+	config := &tls.Config{RootCAs: roots}
+	if client != nil {
+		config.Certificates = []tls.Certificate{*client}
+	}
+
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	response, err := httpClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	return response.TLS.PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestReloader(t *testing.T) {
+	logger := testLogger(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	ca := newCert(t, "Test CA", nil, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	newCert(t, "first", ca, false).write(t, certFile, keyFile)
+
+	r, err := tlsconfig.New(certFile, keyFile, "", logger)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	url := serve(t, r)
+
+	if name, err := presented(t, url, roots, nil); err != nil || name != "first" {
+		t.Fatalf("Expected the first certificate, got %q (%v)", name, err)
+	}
+
+	newCert(t, "renewed", ca, false).write(t, certFile, keyFile)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+
+	if name, err := presented(t, url, roots, nil); err != nil || name != "renewed" {
+		t.Errorf("Expected the renewed certificate, got %q (%v)", name, err)
+	}
+
+	// Halfway through replacing the files the key doesn't match; the renewed
+	// certificate stays in use.
+	os.WriteFile(certFile, newCert(t, "broken", ca, false).pem, 0o600)
+	if err := r.Reload(); err == nil {
+		t.Errorf("Expected the mismatched key to be refused")
+	}
+
+	if name, err := presented(t, url, roots, nil); err != nil || name != "renewed" {
+		t.Errorf("Expected the renewed certificate to stay in use, got %q (%v)", name, err)
+	}
+}
+
+func TestReloader_ClientCA(t *testing.T) {
+	logger := testLogger(t)
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+
+	ca := newCert(t, "Test CA", nil, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newCert(t, "server", ca, false).write(t, certFile, keyFile)
+
+	clientCA := newCert(t, "Controllers CA", nil, true)
+	if err := os.WriteFile(caFile, clientCA.pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := tlsconfig.New(certFile, keyFile, caFile, logger)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	url := serve(t, r)
+
+	keyPair := func(c *testCert) *tls.Certificate {
+		cert, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &cert
+	}
+
+	if _, err := presented(t, url, roots, keyPair(newCert(t, "controller", clientCA, false))); err != nil {
+		t.Errorf("Expected a client certificate from the CA to be accepted, got %v", err)
+	}
+
+	if _, err := presented(t, url, roots, keyPair(newCert(t, "intruder", ca, false))); err == nil {
+		t.Errorf("Expected a client certificate from another CA to be refused")
+	}
+
+	if _, err := presented(t, url, roots, nil); err == nil {
+		t.Errorf("Expected a client without a certificate to be refused")
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+
+	newCert(t, "server", nil, false).write(t, certFile, keyFile)
+	os.WriteFile(caFile, []byte("not a certificate"), 0o600)
+
+	if _, err := tlsconfig.New(filepath.Join(dir, "missing.pem"), keyFile, "", slog.Default()); err == nil || !strings.Contains(err.Error(), "could not load the TLS certificate") {
+		t.Errorf("Expected an error for the missing certificate, got %v", err)
+	}
+
+	if _, err := tlsconfig.New(certFile, keyFile, caFile, slog.Default()); err == nil || !strings.Contains(err.Error(), "no PEM encoded certificates") {
+		t.Errorf("Expected an error for the client CA file, got %v", err)
+	}
+}
+
+// A logger for the tests, writing to the output of the test so the log
+// shows up along with any failures (and with -v).
+func testLogger(t *testing.T) *slog.Logger {
+	return slog.New(slog.NewTextHandler(t.Output(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// EOF
@@ -0,0 +1,192 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// An Authenticator decides whether a request may deliver a message. Check
+// runs before the body is read, so most requests which aren't allowed in
+// are turned away without it; CheckBody runs once the body has been read,
+// for the authenticators that need to look at it.
+type Authenticator interface {
+	Check(r *http.Request) error
+	CheckBody(r *http.Request, body []byte) error
+}
+
+// The headers the shared secret is looked for in. Omada uses the first;
+// the others are what proxies (or people) tend to turn it into.
+var secretHeaders = []string{"Access_token", "Access-Token", "X-Access-Token"}
+
+// HeaderSecret checks the shared secret Omada sends in the Access_token
+// header; this is how Omada controllers authenticate themselves.
+type HeaderSecret struct {
+	Secret string
+}
+
+func (a HeaderSecret) Check(r *http.Request) error {
+	given := false
+
+	for _, header := range secretHeaders {
+		for _, value := range r.Header.Values(header) {
+			given = true
+			if secretsEqual(value, a.Secret) {
+				return nil
+			}
+		}
+	}
+
+	if !given {
+		return errors.New("no access token given")
+	}
+	return errors.New("wrong access token")
+}
+
+func (a HeaderSecret) CheckBody(*http.Request, []byte) error {
+	return nil
+}
+
+// PayloadSecret checks the shared secret Omada includes in the body of its
+// messages, as the shardSecret field (sic); for when something along the
+// way drops the header. Messages in the Google Chat format don't have it.
+type PayloadSecret struct {
+	Secret string
+}
+
+func (a PayloadSecret) Check(*http.Request) error {
+	return nil
+}
+
+func (a PayloadSecret) CheckBody(_ *http.Request, body []byte) error {
+	payload := struct {
+		ShardSecret string `json:"shardSecret"`
+	}{}
+
+	if err := json.Unmarshal(body, &payload); err != nil || payload.ShardSecret == "" {
+		return errors.New("no shardSecret in the message")
+	}
+
+	if !secretsEqual(payload.ShardSecret, a.Secret) {
+		return errors.New("wrong shardSecret in the message")
+	}
+
+	return nil
+}
+
+// The header the HMAC signature is expected in, unless configured otherwise.
+const DefaultSignatureHeader = "X-Signature-256"
+
+// HMACSignature checks a signature of the body, which a reverse proxy in
+// front of this program adds: the hex encoded HMAC-SHA256 of the body using
+// the secret, optionally prefixed with "sha256=" as GitHub does.
+type HMACSignature struct {
+	Secret string
+	Header string
+}
+
+func (a HMACSignature) Check(r *http.Request) error {
+	_, err := a.signature(r)
+	return err
+}
+
+func (a HMACSignature) CheckBody(r *http.Request, body []byte) error {
+	signature, err := a.signature(r)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, []byte(a.Secret))
+	mac.Write(body)
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("wrong signature in %v", a.header())
+	}
+
+	return nil
+}
+
+func (a HMACSignature) header() string {
+	if a.Header == "" {
+		return DefaultSignatureHeader
+	}
+	return a.Header
+}
+
+func (a HMACSignature) signature(r *http.Request) ([]byte, error) {
+	value := r.Header.Get(a.header())
+	if value == "" {
+		return nil, fmt.Errorf("no signature in %v", a.header())
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(value, "sha256="))
+	if err != nil || len(signature) != sha256.Size {
+		return nil, fmt.Errorf("invalid signature in %v", a.header())
+	}
+
+	return signature, nil
+}
+
+// The ways of checking the shared secret, see AuthConfig.
+const (
+	AuthHeader  = "header"
+	AuthPayload = "payload"
+	AuthBoth    = "both"
+)
+
+// How requests are authenticated, as given in the configuration:
+//
+//	auth: both
+//	hmac_secret: pr0xySecwet
+//
+// Auth says where the shared secret must be: in the header (the default),
+// in the payload, or in both. With an HMAC secret the body must be signed
+// as well (see HMACSignature).
+type AuthConfig struct {
+	Mode       string `yaml:"auth"`
+	HMACSecret string `yaml:"hmac_secret"`
+	HMACHeader string `yaml:"hmac_header"`
+}
+
+// Authenticators builds the authenticators for the given shared secret; a
+// request must pass all of them.
+func (ac AuthConfig) Authenticators(secret string) ([]Authenticator, error) {
+	auth := []Authenticator{}
+
+	switch ac.Mode {
+	case "", AuthHeader:
+		auth = append(auth, HeaderSecret{Secret: secret})
+	case AuthPayload:
+		auth = append(auth, PayloadSecret{Secret: secret})
+	case AuthBoth:
+		auth = append(auth, HeaderSecret{Secret: secret}, PayloadSecret{Secret: secret})
+	default:
+		return nil, fmt.Errorf("auth %q must be one of %q, %q or %q", ac.Mode, AuthHeader, AuthPayload, AuthBoth)
+	}
+
+	if ac.HMACSecret != "" {
+		auth = append(auth, HMACSignature{Secret: ac.HMACSecret, Header: ac.HMACHeader})
+	}
+
+	return auth, nil
+}
+
+// Compares the hashes of the secrets in constant time, so that neither the
+// contents nor the length of the secret can be learned from the timing.
+// An empty secret never matches anything.
+func secretsEqual(given, secret string) bool {
+	if secret == "" {
+		return false
+	}
+
+	a, b := sha256.Sum256([]byte(given)), sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// EOF
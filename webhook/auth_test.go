@@ -0,0 +1,189 @@
+package webhook_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/webhook"
+)
+
+// A body which remembers whether it was read.
+type watchedBody struct {
+	*strings.Reader
+	read bool
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.Reader.Read(p)
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookServer_Auth(t *testing.T) {
+	const (
+		secret     = "vewySecwet"
+		hmacSecret = "pr0xySecwet"
+		withSecret = `{"Site":"Some site","shardSecret":"vewySecwet","text":["Something happened."],"Controller":"Controller","timestamp":1758852904877}`
+		wrongOne   = `{"Site":"Some site","shardSecret":"guess","text":["Something happened."],"Controller":"Controller","timestamp":1758852904877}`
+	)
+
+	tests := []struct {
+		name     string
+		auth     webhook.AuthConfig
+		headers  map[string]string
+		body     string
+		code     int
+		bodyRead bool
+		logged   string
+	}{
+		{
+			name:     "Header secret",
+			headers:  map[string]string{"Access_token": secret},
+			body:     withSecret,
+			code:     http.StatusOK,
+			bodyRead: true,
+		},
+		{
+			name:     "Header secret as spelled by a proxy",
+			headers:  map[string]string{"X-Access-Token": secret},
+			body:     withSecret,
+			code:     http.StatusOK,
+			bodyRead: true,
+		},
+		{
+			name:    "Wrong header secret",
+			headers: map[string]string{"Access_token": "guess"},
+			body:    withSecret,
+			code:    http.StatusForbidden,
+			logged:  `path=/hook/auth client=192.0.2.1 reason="wrong access token"`,
+		},
+		{
+			name:   "No header secret",
+			body:   withSecret,
+			code:   http.StatusForbidden,
+			logged: "no access token given",
+		},
+		{
+			name:     "Payload secret",
+			auth:     webhook.AuthConfig{Mode: webhook.AuthPayload},
+			body:     withSecret,
+			code:     http.StatusOK,
+			bodyRead: true,
+		},
+		{
+			name:     "Wrong payload secret",
+			auth:     webhook.AuthConfig{Mode: webhook.AuthPayload},
+			headers:  map[string]string{"Access_token": secret},
+			body:     wrongOne,
+			code:     http.StatusForbidden,
+			bodyRead: true,
+			logged:   "wrong shardSecret in the message",
+		},
+		{
+			name:     "Both, but only the header is right",
+			auth:     webhook.AuthConfig{Mode: webhook.AuthBoth},
+			headers:  map[string]string{"Access_token": secret},
+			body:     wrongOne,
+			code:     http.StatusForbidden,
+			bodyRead: true,
+		},
+		{
+			name:     "Signed by the proxy",
+			auth:     webhook.AuthConfig{HMACSecret: hmacSecret},
+			headers:  map[string]string{"Access_token": secret, "X-Signature-256": sign(hmacSecret, withSecret)},
+			body:     withSecret,
+			code:     http.StatusOK,
+			bodyRead: true,
+		},
+		{
+			name:     "Signed with another secret",
+			auth:     webhook.AuthConfig{HMACSecret: hmacSecret},
+			headers:  map[string]string{"Access_token": secret, "X-Signature-256": sign("guess", withSecret)},
+			body:     withSecret,
+			code:     http.StatusForbidden,
+			bodyRead: true,
+			logged:   "wrong signature in X-Signature-256",
+		},
+		{
+			name:    "Not signed",
+			auth:    webhook.AuthConfig{HMACSecret: hmacSecret, HMACHeader: "X-Proxy-Signature"},
+			headers: map[string]string{"Access_token": secret, "X-Signature-256": sign(hmacSecret, withSecret)},
+			body:    withSecret,
+			code:    http.StatusForbidden,
+			logged:  "no signature in X-Proxy-Signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				buf    bytes.Buffer
+				logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			)
+
+			auth, err := tt.auth.Authenticators(secret)
+			if err != nil {
+				t.Fatalf("Authenticators() failed: %v", err)
+			}
+
+			server := &webhook.WebhookServer{
+				Logger: logger,
+				Endpoints: []*webhook.Endpoint{
+					{Name: "auth", Path: "/hook/auth", SharedSecret: secret, Auth: auth, Notifiers: []notify.Notifier{&fakeNotifier{name: "gotify"}}},
+				},
+			}
+
+			body := &watchedBody{Reader: strings.NewReader(tt.body)}
+			request := httptest.NewRequest(http.MethodPost, "/hook/auth", body)
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.code {
+				t.Errorf("Expected status %d, got %d", tt.code, response.Code)
+			}
+
+			if body.read != tt.bodyRead {
+				t.Errorf("Expected the body to be read: %v, but it was: %v", tt.bodyRead, body.read)
+			}
+
+			if !strings.Contains(buf.String(), tt.logged) {
+				t.Errorf("Expected the log to contain %q, got %q", tt.logged, buf.String())
+			}
+		})
+	}
+}
+
+func TestAuthConfig_Errors(t *testing.T) {
+	_, err := webhook.AuthConfig{Mode: "basic"}.Authenticators("vewySecwet")
+	if err == nil || !strings.Contains(err.Error(), `auth "basic" must be one of`) {
+		t.Errorf("Expected an error for the unknown mode, got %v", err)
+	}
+
+	// An empty secret is never accepted, not even when none is given.
+	auth, _ := webhook.AuthConfig{}.Authenticators("")
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.Header.Set("Access_token", "")
+
+	if err := auth[0].Check(request); err == nil {
+		t.Errorf("Expected an empty secret to be refused")
+	}
+}
+
+// EOF
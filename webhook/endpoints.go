@@ -0,0 +1,245 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/notify"
+	"gopkg.in/yaml.v3"
+)
+
+// An Endpoint is a path of its own that Omada controllers can send their
+// webhooks to, with its own shared secret and notifiers. This way several
+// controllers (say, of different customers) can each have their own secret,
+// and the messages of each go to their own Gotify application.
+type Endpoint struct {
+	Name         string
+	Path         string
+	SharedSecret string
+
+	// How requests are authenticated; when not set, by SharedSecret in the
+	// Access_token header.
+	Auth []Authenticator
+
+	// The networks requests are accepted from; anywhere when empty.
+	Allow []netip.Prefix
+
+	// Used for messages that don't name their site.
+	Site string
+
+	Notifiers []notify.Notifier
+}
+
+// How an endpoint is described in the endpoints file, see LoadEndpoints.
+type EndpointConfig struct {
+	Name   string          `yaml:"name" json:"name"`
+	Path   string          `yaml:"path" json:"path"`
+	Secret string          `yaml:"secret" json:"secret"`
+	Site   string          `yaml:"site" json:"site"`
+	Gotify EndpointGotify  `yaml:"gotify" json:"gotify"`
+	Routes []*gotify.Route `yaml:"routes" json:"routes"`
+	Allow  []string        `yaml:"allow" json:"allow"`
+
+	AuthConfig `yaml:",inline"`
+}
+
+// The Gotify server and application the messages of an endpoint go to,
+// unless its routes say otherwise.
+type EndpointGotify struct {
+	URL   string `yaml:"url" json:"url"`
+	Token string `yaml:"token" json:"token"`
+}
+
+type endpointsFile struct {
+	Endpoints []*EndpointConfig `yaml:"endpoints" json:"endpoints"`
+}
+
+var endpointNameRe = regexp.MustCompile(`^[a-z0-9]+([-_][a-z0-9]+)*$`)
+
+// LoadEndpoints reads, parses and validates an endpoints file, which may be
+// written in YAML or in JSON:
+//
+//	endpoints:
+//	  - name: customer-a
+//	    secret: vewySecwet
+//	    site: Customer A
+//	    gotify:
+//	      token: AbCdEf123
+//	    routes:
+//	      - match:
+//	          type: ^client-roaming$
+//	        drop: true
+//	  - name: customer-b
+//	    path: /omada/b
+//	    secret: alsoVewySecwet
+//	    auth: both
+//	    allow: [192.0.2.0/24]
+//	    gotify:
+//	      url: https://gotify.customer-b.example.com/
+//	      token: GhIjKl456
+//
+// The path defaults to /hook/<name>. How the secret is checked is set as
+// described for AuthConfig; allow lists the networks (see ParseNetworks)
+// requests are accepted from. The Gotify URL and token default to
+// those of the given client, which is also used for the logger and format.
+// The routes work just like those of a routes file (see gotify.Route), with
+// the endpoint's Gotify application as the default.
+func LoadEndpoints(path string, gc gotify.GotifyClient) ([]*Endpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read endpoints file: %w", err)
+	}
+
+	endpoints, err := ParseEndpoints(data, gc)
+	if err != nil {
+		return nil, fmt.Errorf("endpoints file %v: %w", path, err)
+	}
+
+	return endpoints, nil
+}
+
+// ParseEndpoints parses and validates endpoints given as YAML or JSON, and
+// sets up their notifiers. Unknown fields are rejected to catch typos.
+func ParseEndpoints(data []byte, gc gotify.GotifyClient) ([]*Endpoint, error) {
+	file := &endpointsFile{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse endpoints: %w", err)
+	}
+
+	return BuildEndpoints(file.Endpoints, gc)
+}
+
+// BuildEndpoints validates the endpoints and sets up their notifiers, see
+// LoadEndpoints for the defaults.
+func BuildEndpoints(configs []*EndpointConfig, gc gotify.GotifyClient) ([]*Endpoint, error) {
+	endpoints := []*Endpoint{}
+	errs := []error{}
+	names := map[string]bool{}
+	paths := map[string]string{}
+
+	for i, config := range configs {
+		if config == nil {
+			errs = append(errs, fmt.Errorf("endpoint %d is empty", i+1))
+			continue
+		}
+
+		label := fmt.Sprintf("endpoint %d", i+1)
+		if config.Name != "" {
+			label += fmt.Sprintf(" (%q)", config.Name)
+		}
+
+		endpoint, endpointErrs := config.build(gc)
+		for _, err := range endpointErrs {
+			errs = append(errs, fmt.Errorf("%v: %w", label, err))
+		}
+
+		if endpoint == nil {
+			continue
+		}
+
+		if names[endpoint.Name] {
+			errs = append(errs, fmt.Errorf("%v: there is another endpoint with this name", label))
+		}
+		names[endpoint.Name] = true
+
+		if other, taken := paths[endpoint.Path]; taken {
+			errs = append(errs, fmt.Errorf("%v: path %v is already used by endpoint %q", label, endpoint.Path, other))
+		}
+		paths[endpoint.Path] = endpoint.Name
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return endpoints, nil
+}
+
+func (config *EndpointConfig) build(gc gotify.GotifyClient) (*Endpoint, []error) {
+	errs := []error{}
+
+	if !endpointNameRe.MatchString(config.Name) {
+		errs = append(errs, fmt.Errorf("name %q must be lowercase letters, digits, dashes and underscores", config.Name))
+	}
+
+	path := config.Path
+	if path == "" {
+		path = "/hook/" + config.Name
+	}
+
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		errs = append(errs, fmt.Errorf("path %q must start with a / and can't be just /", path))
+	}
+
+	if config.Secret == "" {
+		errs = append(errs, errors.New("no secret given"))
+	}
+
+	auth, err := config.AuthConfig.Authenticators(config.Secret)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	allow, err := ParseNetworks(config.Allow)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("allow: %w", err))
+	}
+
+	routes := &gotify.RouteSet{Routes: config.Routes}
+	if err := routes.Validate(); err != nil {
+		// Each problem with the routes gets the label of the endpoint.
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = append(errs, joined.Unwrap()...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	if config.Gotify.URL != "" {
+		gc.GotifyURL = config.Gotify.URL
+	}
+	if config.Gotify.Token != "" {
+		gc.Token = config.Gotify.Token
+	}
+
+	notifier := gotify.NewNotifier(gc)
+	if len(config.Routes) > 0 {
+		notifier.Routes = routes
+	}
+
+	return &Endpoint{
+		Name:         config.Name,
+		Path:         path,
+		SharedSecret: config.Secret,
+		Auth:         auth,
+		Allow:        allow,
+		Site:         config.Site,
+		Notifiers:    []notify.Notifier{notifier},
+	}, nil
+}
+
+func (endpoint *Endpoint) authenticators() []Authenticator {
+	if endpoint.Auth != nil {
+		return endpoint.Auth
+	}
+	return []Authenticator{HeaderSecret{Secret: endpoint.SharedSecret}}
+}
+
+// EOF
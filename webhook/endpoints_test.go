@@ -0,0 +1,169 @@
+package webhook_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/webhook"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	gc := gotify.GotifyClient{GotifyURL: "http://gotify.local/", Token: "app-token"}
+
+	endpoints, err := webhook.ParseEndpoints([]byte(`
+endpoints:
+  - name: customer-a
+    secret: secret-a
+    site: Customer A
+    gotify:
+      token: token-a
+    routes:
+      - match:
+          type: ^client-roaming$
+        drop: true
+  - name: customer-b
+    path: /omada/b
+    secret: secret-b
+    auth: payload
+    hmac_secret: proxy-secret
+`), gc)
+	if err != nil {
+		t.Fatalf("ParseEndpoints() failed: %v", err)
+	}
+
+	if len(endpoints) != 2 {
+		t.Fatalf("Expected 2 endpoints, got %d", len(endpoints))
+	}
+
+	a, b := endpoints[0], endpoints[1]
+
+	if a.Path != "/hook/customer-a" || a.SharedSecret != "secret-a" || a.Site != "Customer A" {
+		t.Errorf("Unexpected endpoint %+v", a)
+	}
+
+	if notifier := a.Notifiers[0].(*gotify.Notifier); notifier.Client.Token != "token-a" || notifier.Routes == nil {
+		t.Errorf("Expected the Gotify application and routes of the endpoint, got %+v", notifier)
+	}
+
+	if b.Path != "/omada/b" {
+		t.Errorf("Expected the given path, got %v", b.Path)
+	}
+
+	if len(b.Auth) != 2 || b.Auth[0] != (webhook.PayloadSecret{Secret: "secret-b"}) || b.Auth[1] != (webhook.HMACSignature{Secret: "proxy-secret"}) {
+		t.Errorf("Expected the payload to be checked and signed, got %+v", b.Auth)
+	}
+
+	if notifier := b.Notifiers[0].(*gotify.Notifier); notifier.Client.Token != "app-token" || notifier.Routes != nil {
+		t.Errorf("Expected the default Gotify application, got %+v", notifier)
+	}
+}
+
+func TestParseEndpoints_Errors(t *testing.T) {
+	_, err := webhook.ParseEndpoints([]byte(`
+endpoints:
+  - name: Customer A
+    path: hook
+  - name: customer-b
+    secret: secret-b
+  - name: customer-c
+    path: /hook/customer-b
+    secret: secret-c
+  - name: customer-d
+    secret: secret-d
+    routes:
+      - name: Nothing
+        token: abc
+`), gotify.GotifyClient{})
+	if err == nil {
+		t.Fatal("ParseEndpoints() succeeded, expected an error")
+	}
+
+	for _, want := range []string{
+		`endpoint 1 ("Customer A"): name "Customer A" must be lowercase`,
+		`endpoint 1 ("Customer A"): path "hook" must start with a /`,
+		`endpoint 1 ("Customer A"): no secret given`,
+		`endpoint 3 ("customer-c"): path /hook/customer-b is already used by endpoint "customer-b"`,
+		`endpoint 4 ("customer-d"): route 1 ("Nothing"): no match conditions given`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestWebhookServer_Endpoints(t *testing.T) {
+	logger := testLogger(t)
+
+	main, customer := &fakeNotifier{name: "gotify"}, &fakeNotifier{name: "gotify"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{main},
+		SharedSecret: "vewySecwet",
+		Logger:       logger,
+		Endpoints: []*webhook.Endpoint{
+			{
+				Name:         "customer-a",
+				Path:         "/hook/customer-a",
+				SharedSecret: "secret-a",
+				Site:         "Customer A",
+				Notifiers:    []notify.Notifier{customer},
+			},
+		},
+	}
+
+	json := `{"description":"This is a webhook message from Omada Controller","text":["[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\r"],"Controller":"Omada Controller_347044","timestamp":1758852904877,"endpoint":"forged"}`
+
+	tests := []struct {
+		name   string
+		path   string
+		secret string
+		status int
+		to     *fakeNotifier
+	}{
+		{name: "Default endpoint", path: "/", secret: "vewySecwet", status: http.StatusOK, to: main},
+		{name: "Customer endpoint", path: "/hook/customer-a", secret: "secret-a", status: http.StatusOK, to: customer},
+		{name: "Secret of another endpoint", path: "/hook/customer-a", secret: "vewySecwet", status: http.StatusForbidden},
+		{name: "Unknown path", path: "/hook/customer-b", secret: "secret-a", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			main.messages, customer.messages = 0, 0
+
+			request, _ := http.NewRequest(http.MethodPost, tt.path, strings.NewReader(json))
+			request.Header.Set("Access_token", tt.secret)
+
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, response.Code)
+			}
+
+			if tt.to == nil {
+				if main.messages+customer.messages != 0 {
+					t.Errorf("Expected no notifications to be sent")
+				}
+				return
+			}
+
+			if tt.to.messages != 1 || main.messages+customer.messages != 1 {
+				t.Errorf("Expected a notification through the notifier of the endpoint only")
+			}
+		})
+	}
+
+	if customer.last.Endpoint != "customer-a" || customer.last.Site != "Customer A" {
+		t.Errorf("Expected the endpoint and its site to be filled in, got %+v", customer.last)
+	}
+
+	if main.last.Endpoint != "" {
+		t.Errorf("Expected the default endpoint, got %q", main.last.Endpoint)
+	}
+}
+
+// EOF
@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// Keeps what became of the message for the notifier in the history, along
+// with the route the notifier picked; a message its route drops counts as
+// dropped rather than delivered.
+func (ws *WebhookServer) recordDelivery(omadaMessage *omada.OmadaMessage, notifier notify.Notifier, result string, err error) {
+	if ws.History == nil {
+		return
+	}
+
+	d := history.Delivery{Notifier: notifier.Name(), Result: result}
+
+	if router, ok := notifier.(notify.Router); ok {
+		route, drop := router.RouteOf(omadaMessage)
+		d.Route = route
+		if drop && result == history.ResultDelivered {
+			d.Result = history.ResultDropped
+		}
+	}
+
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	ws.History.Record(omadaMessage.ID, d)
+}
+
+// The result of an attempt at delivering a message.
+func outcome(err error) string {
+	if err != nil {
+		return history.ResultFailed
+	}
+	return history.ResultDelivered
+}
+
+// ErrNoPayload is returned by Resend for a message that can't be sent
+// again, as this program made it up itself rather than received it.
+var ErrNoPayload = errors.New("the message wasn't received as such, there is nothing to send again")
+
+// Resend sends the message with the ID in the history again, straight away
+// rather than through the queue: to the named notifiers of its endpoint or,
+// without any named, to those it was delivered to before (or all of them, if
+// it never was). The message is parsed again from the payload kept, so it is
+// classified and routed by the rules in use now; the outcomes are added to
+// its entry. Each notifier is tried even when another fails.
+func (ws *WebhookServer) Resend(ctx context.Context, id string, targets ...string) error {
+	entry, ok := ws.History.Get(id)
+	if !ok {
+		return fmt.Errorf("there is no message %q in the history", id)
+	}
+
+	if len(entry.Payload) == 0 {
+		return ErrNoPayload
+	}
+
+	logger := logging.WithRequestID(ws.Logger, entry.RequestID)
+	settings := ws.current()
+
+	omadaMessage, err := reparse(entry.Payload, &entry, settings.Rules)
+	if err != nil {
+		return err
+	}
+
+	if len(targets) == 0 {
+		targets = deliveredTo(entry)
+	}
+
+	if len(targets) == 0 {
+		notifiers, _ := settings.notifiers(omadaMessage.Endpoint)
+		for _, notifier := range notifiers {
+			targets = append(targets, notifier.Name())
+		}
+	}
+
+	errs := []error{}
+
+	for _, target := range targets {
+		notifier, ok := settings.notifier(omadaMessage.Endpoint, target)
+		if !ok {
+			errs = append(errs, fmt.Errorf("the endpoint of the message has no %v notifier (anymore)", target))
+			continue
+		}
+
+		logger.Info("Sending a message again", "id", id, "notifier", target)
+
+		err := notifyWithMetrics(ctx, notifier, omadaMessage)
+		ws.recordDelivery(omadaMessage, notifier, outcome(err), err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", target, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// The notifiers the message in the entry was delivered to (or tried to be),
+// each named once, in the order they first were.
+func deliveredTo(entry history.Entry) []string {
+	names := []string{}
+
+	for _, d := range entry.Deliveries {
+		if !slices.Contains(names, d.Notifier) {
+			names = append(names, d.Notifier)
+		}
+	}
+
+	return names
+}
+
+// Parses a payload kept earlier, giving the message what it got when it
+// came in (when its history entry is known), and classifies it with the
+// given rules. Parsing logs every message as received, which these aren't,
+// so nothing is logged.
+func reparse(payload []byte, entry *history.Entry, rules *omada.RuleSet) (*omada.OmadaMessage, error) {
+	omadaMessage, err := omada.ParseMessage(slog.New(slog.DiscardHandler), payload)
+	if err != nil || omadaMessage == nil {
+		return nil, fmt.Errorf("could not parse the message again: %w", err)
+	}
+
+	if entry != nil {
+		omadaMessage.ID = entry.ID
+		omadaMessage.RequestID = entry.RequestID
+		omadaMessage.Endpoint = entry.Endpoint
+		if omadaMessage.Site == "" {
+			omadaMessage.Site = entry.Site
+		}
+	}
+
+	omadaMessage.Classify(rules)
+	return omadaMessage, nil
+}
+
+// The named notifier of the endpoint, if it has one.
+func (s Settings) notifier(endpoint, name string) (notify.Notifier, bool) {
+	notifiers, _ := s.notifiers(endpoint)
+	for _, notifier := range notifiers {
+		if notifier.Name() == name {
+			return notifier, true
+		}
+	}
+	return nil, false
+}
+
+// EOF
@@ -0,0 +1,225 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
+	"github.com/leeft/omada-to-gotify/webhook"
+)
+
+// A notifier with routes, dropping the messages of the site it's told to.
+type routingNotifier struct {
+	fakeNotifier
+	dropSite string
+}
+
+func (r *routingNotifier) RouteOf(msg *omada.OmadaMessage) (string, bool) {
+	if msg.Site == r.dropSite {
+		return "quiet", true
+	}
+	return "default", false
+}
+
+func TestWebhookServer_History(t *testing.T) {
+	store, _ := history.New("", slog.Default())
+
+	good := &routingNotifier{fakeNotifier: fakeNotifier{name: "gotify"}, dropSite: "Lab"}
+	bad := &fakeNotifier{name: "ntfy", fail: errors.New("ntfy is down")}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{good, bad},
+		SharedSecret: "vewySecwet",
+		Logger:       slog.Default(),
+		History:      store,
+	}
+
+	send := func(site string) {
+		body := `{"Site":"` + site + `","text":["Something happened."],"Controller":"Controller","shardSecret":"vewySecwet"}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set("Access_token", "vewySecwet")
+		request.Header.Set(webhook.RequestIDHeader, "request-"+site)
+		server.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	send("Home")
+	send("Lab")
+
+	entries := store.Query(history.Query{})
+	if len(entries) != 2 {
+		t.Fatalf("Expected both messages in the history, got %d", len(entries))
+	}
+
+	lab, home := entries[0], entries[1]
+
+	if home.RequestID != "request-Home" {
+		t.Errorf("Expected the entry to have the ID of its request, got %q", home.RequestID)
+	}
+
+	if lab.ID != good.last.ID {
+		t.Errorf("Expected the message delivered to carry the ID of its entry, got %q", good.last.ID)
+	}
+
+	if strings.Contains(string(home.Payload), "vewySecwet") {
+		t.Errorf("Expected the secret to be masked, got %s", home.Payload)
+	}
+
+	want := map[string][]history.Delivery{
+		"Home": {
+			{Notifier: "gotify", Route: "default", Result: history.ResultDelivered, Attempts: 1},
+			{Notifier: "ntfy", Result: history.ResultFailed, Error: "ntfy is down", Attempts: 1},
+		},
+		"Lab": {
+			{Notifier: "gotify", Route: "quiet", Result: history.ResultDropped},
+			{Notifier: "ntfy", Result: history.ResultFailed, Error: "ntfy is down", Attempts: 1},
+		},
+	}
+
+	for _, entry := range entries {
+		if len(entry.Deliveries) != len(want[entry.Site]) {
+			t.Fatalf("Expected %d deliveries for %v, got %+v", len(want[entry.Site]), entry.Site, entry.Deliveries)
+		}
+
+		for i, d := range entry.Deliveries {
+			d.At = want[entry.Site][i].At
+			if d != want[entry.Site][i] {
+				t.Errorf("Expected %+v for %v, got %+v", want[entry.Site][i], entry.Site, d)
+			}
+		}
+	}
+}
+
+func TestWebhookServer_HistoryQueued(t *testing.T) {
+	store, _ := history.New("", slog.Default())
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{&fakeNotifier{name: "gotify"}},
+		SharedSecret: "vewySecwet",
+		Logger:       slog.Default(),
+		History:      store,
+	}
+
+	queue, err := webhook.NewDeliveryQueue(t.TempDir(), server.DeliverTo, slog.Default())
+	if err != nil {
+		t.Fatalf("NewDeliveryQueue() failed: %v", err)
+	}
+	server.Queue = queue
+
+	msg := &omada.OmadaMessage{Controller: "Controller", Text: []string{"Something happened."}}
+	if err := server.Deliver(context.Background(), msg); err != nil {
+		t.Fatalf("Deliver() failed: %v", err)
+	}
+
+	entry, ok := store.Get(msg.ID)
+	if !ok || len(entry.Deliveries) != 1 || entry.Deliveries[0].Result != history.ResultQueued {
+		t.Fatalf("Expected the message to be recorded as queued, got %+v", entry)
+	}
+
+	if err := server.DeliverTo(context.Background(), "gotify", msg); err != nil {
+		t.Fatalf("DeliverTo() failed: %v", err)
+	}
+
+	if entry, _ := store.Get(msg.ID); entry.Deliveries[0].Result != history.ResultDelivered || entry.Deliveries[0].Attempts != 1 {
+		t.Errorf("Expected the queued message to be recorded as delivered, got %+v", entry.Deliveries)
+	}
+}
+
+func TestWebhookServer_Resend(t *testing.T) {
+	store, _ := history.New("", slog.Default())
+
+	gotify := &fakeNotifier{name: "gotify"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{gotify},
+		SharedSecret: "vewySecwet",
+		Logger:       slog.Default(),
+		History:      store,
+	}
+
+	body := `{"Site":"Home","text":["Something happened."],"Controller":"Controller","shardSecret":"vewySecwet"}`
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	request.Header.Set("Access_token", "vewySecwet")
+	request.Header.Set(webhook.RequestIDHeader, "request-1")
+	server.ServeHTTP(httptest.NewRecorder(), request)
+
+	id := gotify.last.ID
+
+	if err := server.Resend(context.Background(), id, "gotify"); err != nil {
+		t.Fatalf("Resend() failed: %v", err)
+	}
+
+	if gotify.messages != 2 || gotify.last.Site != "Home" || gotify.last.ID != id || gotify.last.RequestID != "request-1" {
+		t.Errorf("Expected the message to be sent again as it was, got %+v", gotify.last)
+	}
+
+	if entry, _ := store.Get(id); entry.Deliveries[0].Attempts != 2 {
+		t.Errorf("Expected the second attempt to be recorded, got %+v", entry.Deliveries)
+	}
+
+	if err := server.Resend(context.Background(), id, "ntfy"); err == nil {
+		t.Error("Expected an error resending to a notifier the endpoint doesn't have")
+	}
+
+	if err := server.Resend(context.Background(), "unknown", "gotify"); err == nil {
+		t.Error("Expected an error resending a message that isn't in the history")
+	}
+
+	made := &omada.OmadaMessage{Controller: "Controller", Text: []string{"Made up."}}
+	server.Deliver(context.Background(), made)
+
+	if err := server.Resend(context.Background(), made.ID, "gotify"); !errors.Is(err, webhook.ErrNoPayload) {
+		t.Errorf("Expected a message without a payload not to be sent again, got %v", err)
+	}
+}
+
+// Without naming any, a message is sent again to the notifiers it went to
+// the first time, whichever those are.
+func TestWebhookServer_ResendDelivered(t *testing.T) {
+	store, _ := history.New("", testLogger(t))
+
+	ntfy := &fakeNotifier{name: "ntfy"}
+	email := &fakeNotifier{name: "email"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{ntfy, email},
+		SharedSecret: "vewySecwet",
+		Logger:       testLogger(t),
+		History:      store,
+	}
+
+	body := `{"Site":"Home","text":["Something happened."],"Controller":"Controller","shardSecret":"vewySecwet"}`
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	request.Header.Set("Access_token", "vewySecwet")
+	server.ServeHTTP(httptest.NewRecorder(), request)
+
+	id := ntfy.last.ID
+
+	if err := server.Resend(context.Background(), id); err != nil {
+		t.Fatalf("Resend() failed: %v", err)
+	}
+
+	if ntfy.messages != 2 || email.messages != 2 {
+		t.Errorf("Expected both notifiers to get the message again, ntfy got %d and email %d", ntfy.messages, email.messages)
+	}
+
+	// A notifier that is gone doesn't keep the others from getting it.
+	server.Notifiers = []notify.Notifier{email}
+
+	err := server.Resend(context.Background(), id)
+	if err == nil || !strings.Contains(err.Error(), "no ntfy notifier") {
+		t.Errorf("Expected an error for the ntfy notifier that is gone, got %v", err)
+	}
+
+	if email.messages != 3 {
+		t.Errorf("Expected email to get the message once more, got %d", email.messages)
+	}
+}
+
+// EOF
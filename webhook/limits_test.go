@@ -0,0 +1,137 @@
+package webhook_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/webhook"
+)
+
+func networks(t *testing.T, list ...string) []netip.Prefix {
+	prefixes, err := webhook.ParseNetworks(list)
+	if err != nil {
+		t.Fatalf("ParseNetworks() failed: %v", err)
+	}
+	return prefixes
+}
+
+func TestWebhookServer_Allow(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	)
+
+	server := &webhook.WebhookServer{
+		Notifiers:      []notify.Notifier{&fakeNotifier{name: "gotify"}},
+		SharedSecret:   "vewySecwet",
+		Logger:         logger,
+		Allow:          networks(t, "192.168.10.0/24", "2001:db8::/32"),
+		TrustedProxies: networks(t, "10.0.0.1"),
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		code      int
+	}{
+		{"From the management VLAN", "192.168.10.5:40000", "", http.StatusOK},
+		{"Over IPv6", "[2001:db8::5]:40000", "", http.StatusOK},
+		{"From elsewhere", "192.168.20.5:40000", "", http.StatusForbidden},
+		{"Forwarding header from an untrusted client", "192.168.20.5:40000", "192.168.10.5", http.StatusForbidden},
+		{"Through the trusted proxy", "10.0.0.1:40000", "192.168.10.5", http.StatusOK},
+		{"Through the trusted proxy, from elsewhere", "10.0.0.1:40000", "192.168.10.5, 192.168.20.5", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			json := `{"Site":"Some site","text":["Something happened."],"Controller":"Controller","timestamp":1758852904877}`
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(json))
+			request.RemoteAddr = tt.remote
+			request.Header.Set("Access_token", "vewySecwet")
+			if tt.forwarded != "" {
+				request.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.code {
+				t.Errorf("Expected status %d, got %d", tt.code, response.Code)
+			}
+		})
+	}
+
+	if !strings.Contains(buf.String(), `path=/ client=192.168.20.5 reason="not from one of the allowed networks"`) {
+		t.Errorf("Expected the rejection to be logged with the client's address, got %q", buf.String())
+	}
+}
+
+func TestWebhookServer_RateLimit(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	)
+
+	gotify := &fakeNotifier{name: "gotify"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:       []notify.Notifier{gotify},
+		SharedSecret:    "vewySecwet",
+		Logger:          logger,
+		IPLimit:         webhook.RateLimit{PerMinute: 1, Burst: 4},
+		ControllerLimit: webhook.RateLimit{PerMinute: 1, Burst: 2},
+	}
+
+	send := func(remote, controller string) *httptest.ResponseRecorder {
+		json := `{"Site":"Some site","text":["Something happened."],"Controller":"` + controller + `","timestamp":1758852904877}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(json))
+		request.RemoteAddr = remote
+		request.Header.Set("Access_token", "vewySecwet")
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		return response
+	}
+
+	// The controller runs out before the source IP does.
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := send("192.0.2.1:1234", "Noisy").Code; code != want {
+			t.Errorf("Request %d: expected status %d, got %d", i+1, want, code)
+		}
+	}
+
+	// Another controller from the same address gets the address's last token;
+	// the request turned away above took one as well.
+	if code := send("192.0.2.1:1234", "Quiet").Code; code != http.StatusOK {
+		t.Errorf("Expected the other controller to get through, got %d", code)
+	}
+
+	response := send("192.0.2.1:1234", "Quiet")
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the address to be limited with a Retry-After, got %d", response.Code)
+	}
+
+	// Other addresses have buckets of their own.
+	if code := send("192.0.2.2:1234", "Quiet").Code; code != http.StatusOK {
+		t.Errorf("Expected another address to get through, got %d", code)
+	}
+
+	if gotify.messages != 4 {
+		t.Errorf("Expected 4 messages to be delivered, got %d", gotify.messages)
+	}
+
+	for _, want := range []string{`limited="controller \"Noisy\""`, `limited="source IP 192.0.2.1"`} {
+		if strings.Count(buf.String(), want) != 1 {
+			t.Errorf("Expected %q to be logged once, got %q", want, buf.String())
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/metrics"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+var (
+	webhooksReceived = metrics.NewCounter("omada_to_gotify_webhooks_received_total",
+		"Webhook requests received, by endpoint and the status code of the response.", "endpoint", "code")
+	rateLimited = metrics.NewCounter("omada_to_gotify_rate_limited_total",
+		"Requests turned away for going over the rate limit, by limit (ip or controller).", "limit")
+	parseFailures = metrics.NewCounter("omada_to_gotify_parse_failures_total",
+		"Webhook requests with a body that could not be parsed as an Omada message.")
+	messagesReceived = metrics.NewCounter("omada_to_gotify_messages_total",
+		"Messages received, by type and priority.", "type", "priority")
+	notifications = metrics.NewCounter("omada_to_gotify_notifications_total",
+		"Attempts at sending a message to a notification service, by notifier and result.", "notifier", "result")
+	notificationDuration = metrics.NewHistogram("omada_to_gotify_notification_duration_seconds",
+		"How long sending a message to a notification service took, by notifier.", metrics.DefaultBuckets, "notifier")
+	queueDepth = metrics.NewGauge("omada_to_gotify_queue_depth",
+		"Messages in the delivery queue waiting to be delivered.")
+	queueRetries = metrics.NewCounter("omada_to_gotify_queue_retries_total",
+		"Failed deliveries from the queue which are retried later, by target.", "target")
+	queueDropped = metrics.NewCounter("omada_to_gotify_queue_dropped_total",
+		"Queued messages given up on because they got too old, by target.", "target")
+)
+
+// The endpoint label for requests; "none" for paths without an endpoint.
+func endpointLabel(endpoint *Endpoint) string {
+	switch {
+	case endpoint == nil:
+		return "none"
+	case endpoint.Name == "":
+		return "default"
+	}
+	return endpoint.Name
+}
+
+func countMessage(msg *omada.OmadaMessage) {
+	messagesReceived.Inc(msg.TypeName(), strconv.Itoa(msg.Priority()))
+}
+
+// Sends the message to the notifier, keeping track of how that went.
+func notifyWithMetrics(ctx context.Context, notifier notify.Notifier, msg *omada.OmadaMessage) error {
+	start := time.Now()
+	err := notifier.Notify(ctx, msg)
+	notificationDuration.Observe(time.Since(start).Seconds(), notifier.Name())
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	notifications.Inc(notifier.Name(), result)
+
+	return err
+}
+
+// Remembers the status code of the response for the metrics.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.code == 0 {
+		sr.code = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(data []byte) (int, error) {
+	if sr.code == 0 {
+		sr.code = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(data)
+}
+
+// The status code sent, 200 when the handler didn't send anything at all.
+func (sr *statusRecorder) status() string {
+	if sr.code == 0 {
+		return strconv.Itoa(http.StatusOK)
+	}
+	return strconv.Itoa(sr.code)
+}
+
+// EOF
@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseNetworks parses a list of networks in CIDR notation, such as
+// 192.168.1.0/24; a plain address counts as a network of just itself.
+func ParseNetworks(networks []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+
+		if addr, err := netip.ParseAddr(network); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a network such as 192.168.1.0/24, or an address", network)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func contains(networks []netip.Prefix, addr netip.Addr) bool {
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The address the request came from. That's the address of the connection,
+// unless it comes from one of the trusted proxies: then it's the address
+// the proxies say they got the request from, in X-Forwarded-For. Addresses
+// in there are only believed as far as they were added by trusted proxies;
+// anything before that could have been made up by the client.
+func clientIP(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		// Not an actual connection, as in tests.
+		addr, _ := netip.ParseAddr(r.RemoteAddr)
+		return addr.Unmap()
+	}
+
+	addr := remote.Addr().Unmap()
+	if !contains(trusted, addr) {
+		return addr
+	}
+
+	forwarded := []string{}
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+
+		addr = hop.Unmap()
+		if !contains(trusted, addr) {
+			break
+		}
+	}
+
+	return addr
+}
+
+// EOF
@@ -0,0 +1,345 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// The function the queue calls to deliver a message to the named target
+// (a notifier); returning an error schedules another attempt later on.
+type DeliveryFunc func(ctx context.Context, target string, msg *omada.OmadaMessage) error
+
+// A single queued message as it is stored on disk. Each entry lives in its
+// own file so that a crash halfway through writing one can never corrupt
+// any of the others.
+type queueEntry struct {
+	ID          string              `json:"id"`
+	Target      string              `json:"target"`
+	Message     omada.MessageRecord `json:"message"`
+	Attempts    int                 `json:"attempts"`
+	Enqueued    time.Time           `json:"enqueued"`
+	NextAttempt time.Time           `json:"next_attempt"`
+	LastError   string              `json:"last_error,omitempty"`
+}
+
+// DeliveryQueue is a durable, directory backed queue of messages waiting
+// to be delivered. Messages are written to disk before Enqueue returns, so
+// they survive a restart of the process; Run keeps retrying failed
+// deliveries with an exponential backoff (plus jitter) until they succeed.
+type DeliveryQueue struct {
+	Dir     string
+	Deliver DeliveryFunc
+	Logger  *slog.Logger
+
+	// The delay before the first retry, doubled on every failed attempt
+	// up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Messages older than this are dropped instead of retried again;
+	// zero means they are retried forever.
+	MaxAge time.Duration
+
+	// How long a single delivery attempt may take before it is given up on
+	// (and retried later); zero means it may take as long as it likes.
+	AttemptTimeout time.Duration
+
+	// When set, messages given up on are recorded as such.
+	History *history.Store
+
+	mu      sync.Mutex
+	entries []*queueEntry
+	seq     uint64
+	wake    chan struct{}
+}
+
+const queueFileSuffix = ".json"
+
+// NewDeliveryQueue creates the queue directory when needed and loads any
+// messages left behind by a previous run.
+func NewDeliveryQueue(dir string, deliver DeliveryFunc, logger *slog.Logger) (*DeliveryQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create queue directory %q: %w", dir, err)
+	}
+
+	q := &DeliveryQueue{
+		Dir:            dir,
+		Deliver:        deliver,
+		Logger:         logger,
+		MinBackoff:     time.Second,
+		MaxBackoff:     5 * time.Minute,
+		AttemptTimeout: time.Minute,
+		wake:           make(chan struct{}, 1),
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	if len(q.entries) > 0 {
+		logger.Info("Loaded undelivered messages from the queue directory", "messages", len(q.entries), "dir", dir)
+	}
+
+	return q, nil
+}
+
+// Enqueue stores the message for the given target on disk and wakes up the
+// delivery loop. The message is safe once this returns without an error.
+//
+// Each target gets its own entry, so that a retry for one of them doesn't
+// send the message to the others again.
+func (q *DeliveryQueue) Enqueue(target string, msg *omada.OmadaMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	now := time.Now()
+	entry := &queueEntry{
+		ID:          fmt.Sprintf("%020d-%06d", now.UnixNano(), q.seq),
+		Target:      target,
+		Message:     msg.Record(),
+		Enqueued:    now,
+		NextAttempt: now,
+	}
+
+	if err := q.write(entry); err != nil {
+		return err
+	}
+
+	q.entries = append(q.entries, entry)
+	queueDepth.Set(float64(len(q.entries)))
+	q.signal()
+
+	return nil
+}
+
+// The number of messages still waiting to be delivered.
+func (q *DeliveryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Run delivers queued messages until the context is cancelled. A delivery
+// in progress at that moment is allowed to finish before Run returns, for
+// up to AttemptTimeout.
+func (q *DeliveryQueue) Run(ctx context.Context) {
+	deliveryCtx := context.WithoutCancel(ctx)
+
+	for {
+		wait := q.deliverDue(ctx, deliveryCtx, time.Now())
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-q.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Drain makes one last attempt at delivering the messages which are due,
+// for when the program is about to stop; Run must have returned already.
+// It gives up when the context is done, and returns the number of messages
+// left in the queue. Those are delivered once the program runs again.
+func (q *DeliveryQueue) Drain(ctx context.Context) int {
+	q.deliverDue(ctx, ctx, time.Now())
+	return q.Len()
+}
+
+// Attempt every entry that is due until ctx is done, and return how long to
+// wait until the next one will be. The deliveries use deliveryCtx.
+func (q *DeliveryQueue) deliverDue(ctx, deliveryCtx context.Context, now time.Time) time.Duration {
+	q.mu.Lock()
+	due := []*queueEntry{}
+	for _, entry := range q.entries {
+		if !entry.NextAttempt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	q.mu.Unlock()
+
+	// Delivery happens without holding the lock so Enqueue never has to wait
+	// for a slow notification service.
+	for _, entry := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		q.attempt(deliveryCtx, entry)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wait := q.MaxBackoff
+	for _, entry := range q.entries {
+		if until := time.Until(entry.NextAttempt); until < wait {
+			wait = until
+		}
+	}
+
+	return max(wait, 0)
+}
+
+func (q *DeliveryQueue) attempt(ctx context.Context, entry *queueEntry) {
+	if q.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.AttemptTimeout)
+		defer cancel()
+	}
+
+	err := q.Deliver(ctx, entry.Target, entry.Message.Message())
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err == nil {
+		q.remove(entry)
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+
+	if q.MaxAge > 0 && time.Since(entry.Enqueued) > q.MaxAge {
+		q.logger(entry).Error("Giving up on the queued message", "attempts", entry.Attempts, "error", err)
+		q.History.Record(entry.Message.ID, history.Delivery{Notifier: entry.Target, Result: history.ResultGivenUp, Error: err.Error()})
+		queueDropped.Inc(entry.Target)
+		q.remove(entry)
+		return
+	}
+
+	queueRetries.Inc(entry.Target)
+	delay := q.backoff(entry.Attempts)
+	entry.NextAttempt = time.Now().Add(delay)
+
+	q.logger(entry).Warn("Delivery of the queued message failed, retrying", "attempt", entry.Attempts, "retry_in", delay.Round(time.Millisecond).String(), "error", err)
+
+	if err := q.write(entry); err != nil {
+		q.logger(entry).Error("Could not update the queued message", "error", err)
+	}
+}
+
+// Exponential backoff with "equal jitter": half of the delay is fixed, the
+// other half is random. This keeps retries spread out should many messages
+// fail at once, while still guaranteeing some minimum delay.
+func (q *DeliveryQueue) backoff(attempts int) time.Duration {
+	delay := q.MinBackoff
+	for i := 1; i < attempts && delay < q.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, q.MaxBackoff)
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+
+	return half + rand.N(half)
+}
+
+// For what is logged about the entry, along with the request its message
+// came in with.
+func (q *DeliveryQueue) logger(entry *queueEntry) *slog.Logger {
+	return logging.WithRequestID(q.Logger, entry.Message.RequestID).With("queued", entry.ID, "notifier", entry.Target)
+}
+
+// Must be called with the lock held.
+func (q *DeliveryQueue) remove(entry *queueEntry) {
+	for i, e := range q.entries {
+		if e == entry {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			break
+		}
+	}
+	queueDepth.Set(float64(len(q.entries)))
+
+	if err := os.Remove(q.path(entry.ID)); err != nil && !os.IsNotExist(err) {
+		q.logger(entry).Error("Could not remove the queued message", "error", err)
+	}
+}
+
+func (q *DeliveryQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *DeliveryQueue) path(id string) string {
+	return filepath.Join(q.Dir, id+queueFileSuffix)
+}
+
+// Writes to a temporary file first and renames it into place, so a file
+// with the final name is always complete.
+func (q *DeliveryQueue) write(entry *queueEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tmp := q.path(entry.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("could not write queued message: %w", err)
+	}
+
+	if err := os.Rename(tmp, q.path(entry.ID)); err != nil {
+		return fmt.Errorf("could not store queued message: %w", err)
+	}
+
+	return nil
+}
+
+func (q *DeliveryQueue) load() error {
+	files, err := os.ReadDir(q.Dir)
+	if err != nil {
+		return fmt.Errorf("could not read queue directory %q: %w", q.Dir, err)
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), queueFileSuffix) {
+			names = append(names, file.Name())
+		}
+	}
+
+	// The IDs start with a zero padded timestamp so this keeps the order in
+	// which the messages arrived.
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(q.Dir, name))
+		if err != nil {
+			return fmt.Errorf("could not read queued message %q: %w", name, err)
+		}
+
+		entry := &queueEntry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			q.Logger.Warn("Skipping an unreadable queued message", "file", name, "error", err)
+			continue
+		}
+
+		q.entries = append(q.entries, entry)
+	}
+
+	queueDepth.Set(float64(len(q.entries)))
+	return nil
+}
+
+// EOF
@@ -0,0 +1,299 @@
+package webhook_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
+	"github.com/leeft/omada-to-gotify/webhook"
+)
+
+// A DeliveryFunc that fails a set number of times before it starts
+// accepting messages, and remembers what it was given.
+type flakyDelivery struct {
+	mu        sync.Mutex
+	failures  int
+	attempts  int
+	delivered []omada.OmadaMessage
+}
+
+func (fd *flakyDelivery) deliver(ctx context.Context, target string, msg *omada.OmadaMessage) error {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	fd.attempts++
+	if fd.failures > 0 {
+		fd.failures--
+		return errors.New("gotify is down")
+	}
+
+	fd.delivered = append(fd.delivered, *msg)
+	return nil
+}
+
+func (fd *flakyDelivery) count() int {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return len(fd.delivered)
+}
+
+func (fd *flakyDelivery) attemptCount() int {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return fd.attempts
+}
+
+// A copy of the messages delivered so far.
+func (fd *flakyDelivery) messages() []omada.OmadaMessage {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return append([]omada.OmadaMessage{}, fd.delivered...)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeliveryQueue_Retries(t *testing.T) {
+	logger := testLogger(t)
+
+	fd := &flakyDelivery{failures: 2}
+
+	queue, err := webhook.NewDeliveryQueue(t.TempDir(), fd.deliver, logger)
+	if err != nil {
+		t.Fatalf("NewDeliveryQueue() failed: %v", err)
+	}
+	queue.MinBackoff = time.Millisecond
+	queue.MaxBackoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	if err := queue.Enqueue("gotify", &omada.OmadaMessage{Site: "Queued Site"}); err != nil {
+		t.Fatalf("Enqueue() failed: %v", err)
+	}
+
+	waitFor(t, "the message to be delivered", func() bool { return fd.count() == 1 })
+	waitFor(t, "the queue to be empty", func() bool { return queue.Len() == 0 })
+
+	if attempts := fd.attemptCount(); attempts != 3 {
+		t.Errorf("Expected 3 delivery attempts, got %d", attempts)
+	}
+
+	if delivered := fd.messages(); delivered[0].Site != "Queued Site" {
+		t.Errorf("Delivered the wrong message: %+v", delivered[0])
+	}
+}
+
+func TestDeliveryQueue_SurvivesRestart(t *testing.T) {
+	var (
+		logger = testLogger(t)
+		dir    = t.TempDir()
+	)
+
+	// The first queue is never run, as if the process was stopped before
+	// Gotify came back.
+	first, err := webhook.NewDeliveryQueue(dir, func(context.Context, string, *omada.OmadaMessage) error { return errors.New("unused") }, logger)
+	if err != nil {
+		t.Fatalf("NewDeliveryQueue() failed: %v", err)
+	}
+
+	outage := &omada.Outage{Start: time.UnixMilli(1758852904877), End: time.UnixMilli(1758852934790)}
+	for _, site := range []string{"First", "Second"} {
+		msg := &omada.OmadaMessage{Site: site, Endpoint: "customer-a", RequestID: "req-" + site, ID: "id-" + site, Outage: outage}
+		msg.SetType(omada.LinkFlappingMessage)
+		if err := first.Enqueue("gotify", msg); err != nil {
+			t.Fatalf("Enqueue() failed: %v", err)
+		}
+	}
+
+	fd := &flakyDelivery{}
+
+	second, err := webhook.NewDeliveryQueue(dir, fd.deliver, logger)
+	if err != nil {
+		t.Fatalf("NewDeliveryQueue() failed: %v", err)
+	}
+
+	if second.Len() != 2 {
+		t.Fatalf("Expected 2 messages to be loaded from disk, got %d", second.Len())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go second.Run(ctx)
+
+	waitFor(t, "both messages to be delivered", func() bool { return fd.count() == 2 })
+
+	delivered := fd.messages()
+	if delivered[0].Site != "First" || delivered[1].Site != "Second" {
+		t.Errorf("Messages were not delivered in order: %+v", delivered)
+	}
+
+	// What the messages got when they came in is kept with them.
+	if got := delivered[0]; got.Endpoint != "customer-a" || got.RequestID != "req-First" || got.ID != "id-First" || got.Outage == nil || !got.Outage.Start.Equal(outage.Start) || got.Type() != omada.LinkFlappingMessage {
+		t.Errorf("The message lost what it got when it came in: %+v", got)
+	}
+
+	third, err := webhook.NewDeliveryQueue(dir, fd.deliver, logger)
+	if err != nil {
+		t.Fatalf("NewDeliveryQueue() failed: %v", err)
+	}
+
+	if third.Len() != 0 {
+		t.Errorf("Delivered messages were left on disk; %d loaded", third.Len())
+	}
+}
+
+func TestDeliveryQueue_Stop(t *testing.T) {
+	logger := testLogger(t)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	fd := &flakyDelivery{}
+
+	// A slow delivery, still in progress when the queue is told to stop.
+	slow := func(ctx context.Context, target string, msg *omada.OmadaMessage) error {
+		if msg.Site == "Slow" {
+			close(started)
+			<-release
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		return fd.deliver(ctx, target, msg)
+	}
+
+	queue, err := webhook.NewDeliveryQueue(t.TempDir(), slow, logger)
+	if err != nil {
+		t.Fatalf("NewDeliveryQueue() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(done)
+	}()
+
+	if err := queue.Enqueue("gotify", &omada.OmadaMessage{Site: "Slow"}); err != nil {
+		t.Fatalf("Enqueue() failed: %v", err)
+	}
+
+	<-started
+	cancel()
+
+	// Queued while stopping, to be delivered by Drain.
+	if err := queue.Enqueue("gotify", &omada.OmadaMessage{Site: "Late"}); err != nil {
+		t.Fatalf("Enqueue() failed: %v", err)
+	}
+
+	close(release)
+	<-done
+
+	if fd.count() != 1 {
+		t.Fatalf("Expected the delivery in progress to finish, %d delivered", fd.count())
+	}
+
+	if left := queue.Drain(context.Background()); left != 0 {
+		t.Errorf("Expected Drain() to deliver the last message, %d left", left)
+	}
+
+	if delivered := fd.messages(); len(delivered) != 2 || delivered[1].Site != "Late" {
+		t.Errorf("Unexpected deliveries %+v", delivered)
+	}
+}
+
+// A notification service that stops answering is given up on after the
+// attempt timeout, even though the deliveries ignore the queue stopping,
+// and the message is tried again later.
+func TestDeliveryQueue_AttemptTimeout(t *testing.T) {
+	logger := testLogger(t)
+
+	fd := &flakyDelivery{}
+	var hung atomic.Bool
+
+	hanging := func(ctx context.Context, target string, msg *omada.OmadaMessage) error {
+		if hung.CompareAndSwap(false, true) {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return fd.deliver(ctx, target, msg)
+	}
+
+	queue, err := webhook.NewDeliveryQueue(t.TempDir(), hanging, logger)
+	if err != nil {
+		t.Fatalf("NewDeliveryQueue() failed: %v", err)
+	}
+	queue.MinBackoff = time.Millisecond
+	queue.AttemptTimeout = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go queue.Run(ctx)
+
+	if err := queue.Enqueue("gotify", &omada.OmadaMessage{Site: "Hung"}); err != nil {
+		t.Fatalf("Enqueue() failed: %v", err)
+	}
+
+	waitFor(t, "the message to be delivered", func() bool { return fd.count() == 1 })
+}
+
+func TestWebhookServer_Queue(t *testing.T) {
+	logger := testLogger(t)
+
+	fd := &flakyDelivery{}
+
+	queue, err := webhook.NewDeliveryQueue(t.TempDir(), fd.deliver, logger)
+	if err != nil {
+		t.Fatalf("NewDeliveryQueue() failed: %v", err)
+	}
+
+	mock := &GotifyClientMessageMock{}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{&gotify.Notifier{Client: gotify.GotifyClient{Logger: logger}, Message: mock}},
+		SharedSecret: "vewySecwet",
+		Logger:       logger,
+		Queue:        queue,
+	}
+
+	json := []byte(`{"Site":"Some site","description":"This is a webhook message from Omada Controller","text":["[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was offline.\r"],"Controller":"Omada Controller_347044","timestamp":1758852904877}`)
+
+	request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewReader(json))
+	request.Header.Set("Access_token", server.SharedSecret)
+
+	response := httptest.NewRecorder()
+
+	server.ServeHTTP(response, request)
+
+	if got := response.Result().Status; got != "200 OK" {
+		t.Errorf("Expected status code to be `200 OK`, but got `%s`", got)
+	}
+
+	if queue.Len() != 1 {
+		t.Errorf("Expected the message to be queued, queue length is %d", queue.Len())
+	}
+
+	if mock.Calls != 0 {
+		t.Errorf("Expected Gotify to not be called directly, but it was called %d times", mock.Calls)
+	}
+}
+
+// EOF
@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/logging"
+)
+
+// A rate limit for a token bucket: on average PerMinute requests a minute,
+// with bursts of up to Burst requests at once. Off when PerMinute is 0.
+type RateLimit struct {
+	PerMinute float64
+	Burst     int
+}
+
+func (rl RateLimit) enabled() bool {
+	return rl.PerMinute > 0
+}
+
+func (rl RateLimit) burst() float64 {
+	return float64(max(rl.Burst, 1))
+}
+
+// A token bucket for each key (a source IP, or a controller). The limit is
+// passed along each time rather than kept, so it can change when the
+// configuration is reloaded without losing track of the buckets.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+
+	// Who the bucket is for, and how many requests were turned away since
+	// it ran out; for the logs.
+	label    string
+	rejected int
+}
+
+// How often buckets which have filled up again are forgotten.
+const sweepInterval = time.Minute
+
+// Takes a token from the bucket for the key. When there is none, the
+// request is to be turned away; the first time that happens is logged, and
+// once requests are let through again, how many were turned away. What is
+// logged about the bucket of the key goes with the ID of the request.
+func (l *limiter) take(logger *slog.Logger, requestID, key, label string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(logger, limit, now)
+	}
+
+	perSecond := limit.PerMinute / 60
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.burst(), last: now, label: label}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		b.rejected++
+		if b.rejected == 1 {
+			logging.WithRequestID(logger, requestID).Warn("Rate limiting, too many requests a minute", "limited", label, "per_minute", limit.PerMinute)
+		}
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+
+	if b.rejected > 0 {
+		logging.WithRequestID(logger, requestID).Info("No longer rate limiting", "limited", label, "turned_away", b.rejected)
+		b.rejected = 0
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// Forgets the buckets which are full again, they're as good as new ones.
+// Must be called with the lock held.
+func (l *limiter) sweep(logger *slog.Logger, limit RateLimit, now time.Time) {
+	refill := time.Duration(limit.burst() / (limit.PerMinute / 60) * float64(time.Second))
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) < refill {
+			continue
+		}
+
+		if b.rejected > 0 {
+			logger.Info("No longer rate limiting", "limited", b.label, "turned_away", b.rejected)
+		}
+		delete(l.buckets, key)
+	}
+
+	l.swept = now
+}
+
+// EOF
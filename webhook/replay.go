@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
+	"github.com/leeft/omada-to-gotify/replay"
+)
+
+// The largest body of payloads the replay API accepts, in bytes.
+const maxReplaySize = 32 * 1024 * 1024
+
+// Replay runs the messages through parsing, classification and Gotify
+// routing again, as if they came in now: with the rules and routes in use
+// now, though without the outage tracker or flap detector. The query picks
+// the messages by how they are classified now; up to its limit, when set.
+//
+// Unless delivering, nothing is sent anywhere. With deliver, each message
+// is sent to the gotify notifier of its endpoint straight away, and when
+// it came from the history the outcome is added to its entry.
+func (ws *WebhookServer) Replay(ctx context.Context, messages []replay.Message, q history.Query, deliver bool) []replay.Result {
+	results := []replay.Result{}
+	settings := ws.current()
+
+	for _, m := range messages {
+		if q.Limit > 0 && len(results) >= q.Limit {
+			break
+		}
+
+		result := replay.Result{Line: m.Line}
+		if m.Entry != nil {
+			result.ID = m.Entry.ID
+		}
+
+		omadaMessage, err := reparse(m.Payload, m.Entry, settings.Rules)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		if m.Entry == nil {
+			omadaMessage.Endpoint = m.Endpoint
+		}
+
+		entry := history.Entry{
+			Received:   omadaMessage.Date().UTC(),
+			Controller: omadaMessage.Controller,
+			Site:       omadaMessage.Site,
+			Type:       omadaMessage.TypeName(),
+			Priority:   omadaMessage.Priority(),
+		}
+		if m.Entry != nil {
+			entry.Received = m.Entry.Received
+		}
+
+		if !q.Matches(&entry) {
+			continue
+		}
+
+		result.Received = entry.Received
+		result.Endpoint = omadaMessage.Endpoint
+		result.Controller = entry.Controller
+		result.Site = entry.Site
+		result.Type = entry.Type
+		result.Priority = entry.Priority
+		result.Rule = omadaMessage.RuleName()
+		result.Title = omadaMessage.Title()
+		result.Body = omadaMessage.Body()
+
+		notifier, ok := settings.notifier(omadaMessage.Endpoint, "gotify")
+		if ok {
+			if router, ok := notifier.(notify.Router); ok {
+				result.Route, result.Dropped = router.RouteOf(omadaMessage)
+			}
+		}
+
+		result.Compare(m.Entry)
+
+		if deliver {
+			ws.replayDelivery(ctx, &result, omadaMessage, notifier)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Sends a message replayed to Gotify, when its endpoint sends there.
+func (ws *WebhookServer) replayDelivery(ctx context.Context, result *replay.Result, omadaMessage *omada.OmadaMessage, notifier notify.Notifier) {
+	if notifier == nil {
+		result.Result = history.ResultFailed
+		result.Error = "its endpoint doesn't send to Gotify (anymore)"
+		return
+	}
+
+	err := notifyWithMetrics(ctx, notifier, omadaMessage)
+	ws.recordDelivery(omadaMessage, notifier, outcome(err), err)
+
+	result.Result = outcome(err)
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case result.Dropped:
+		result.Result = history.ResultDropped
+	}
+}
+
+// ReplayHandler replays messages on POST, answering with the results as
+// JSON. The messages are the JSON lines in the body (see replay.Read), or
+// without a body, those in the history. The parameters are those of a
+// history query (see history.ParseQuery), selecting messages as they are
+// classified now; with deliver=true they're sent to Gotify, with
+// changed=true only those classified or routed differently now are listed.
+func (ws *WebhookServer) ReplayHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		q, err := history.ParseQuery(params, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		flags := map[string]bool{}
+		for _, name := range []string{"deliver", "changed"} {
+			if value := params.Get(name); value != "" {
+				if flags[name], err = strconv.ParseBool(value); err != nil {
+					http.Error(w, name+" must be true or false, not "+strconv.Quote(value), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxReplaySize))
+
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		var messages []replay.Message
+		if len(bytes.TrimSpace(body)) > 0 {
+			if messages, err = replay.Read(bytes.NewReader(body)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			// Everything in the range of the query; the type and priority
+			// are those of the message now, so they're checked later.
+			messages = replay.FromHistory(ws.History.Query(history.Query{
+				Since:      q.Since,
+				Until:      q.Until,
+				Controller: q.Controller,
+				Site:       q.Site,
+				Limit:      math.MaxInt,
+			}))
+		}
+
+		results := ws.Replay(r.Context(), messages, q, flags["deliver"])
+
+		changed := 0
+		for _, result := range results {
+			if len(result.Changes) > 0 {
+				changed++
+			}
+		}
+
+		if flags["changed"] {
+			results = slices.DeleteFunc(results, func(result replay.Result) bool {
+				return len(result.Changes) == 0
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Count   int             `json:"count"`
+			Changed int             `json:"changed"`
+			Results []replay.Result `json:"results"`
+		}{len(results), changed, results})
+	})
+}
+
+// EOF
@@ -0,0 +1,132 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/replay"
+	"github.com/leeft/omada-to-gotify/webhook"
+)
+
+func TestWebhookServer_Replay(t *testing.T) {
+	store, _ := history.New("", slog.Default())
+
+	gotify := &routingNotifier{fakeNotifier: fakeNotifier{name: "gotify"}, dropSite: "Lab"}
+	ntfy := &fakeNotifier{name: "ntfy"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{gotify, ntfy},
+		SharedSecret: "vewySecwet",
+		Logger:       slog.Default(),
+		History:      store,
+	}
+
+	for _, site := range []string{"Home", "Lab"} {
+		body := `{"Site":"` + site + `","text":["Something happened."],"Controller":"Controller","shardSecret":"vewySecwet"}`
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set("Access_token", "vewySecwet")
+		server.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	// The routes changed since: now the home site's messages are dropped.
+	gotify.dropSite = "Home"
+	sent := gotify.messages
+
+	messages := replay.FromHistory(store.Query(history.Query{}))
+	results := server.Replay(context.Background(), messages, history.Query{}, false)
+
+	if len(results) != 2 || results[0].Site != "Home" || results[1].Site != "Lab" {
+		t.Fatalf("Expected both messages, oldest first, got %+v", results)
+	}
+
+	if !results[0].Dropped || results[0].Route != "quiet" || strings.Join(results[0].Changes, ",") != "route: default → quiet (dropped)" {
+		t.Errorf("Expected the home site's message to be dropped now, got %+v", results[0])
+	}
+
+	if results[1].Dropped || len(results[1].Changes) != 1 {
+		t.Errorf("Expected the lab's message not to be dropped anymore, got %+v", results[1])
+	}
+
+	if gotify.messages != sent || results[0].Result != "" {
+		t.Errorf("Expected nothing to be sent on a dry run")
+	}
+
+	site := history.Query{Site: "lab"}
+	results = server.Replay(context.Background(), messages, site, true)
+
+	if len(results) != 1 || results[0].Result != history.ResultDelivered || gotify.messages != sent+1 || ntfy.messages != 2 {
+		t.Fatalf("Expected the lab's message to be sent to Gotify only, got %+v", results)
+	}
+
+	if entry, _ := store.Get(results[0].ID); entry.Deliveries[0].Result != history.ResultDelivered || entry.Deliveries[0].Attempts != 1 {
+		t.Errorf("Expected the delivery to be recorded in the history, got %+v", entry.Deliveries)
+	}
+
+	broken := []replay.Message{{Line: 1, Payload: []byte(`{"Controller":42}`)}}
+	if results := server.Replay(context.Background(), broken, history.Query{}, false); len(results) != 1 || results[0].Error == "" {
+		t.Errorf("Expected an error for a payload that can't be parsed, got %+v", results)
+	}
+}
+
+func TestWebhookServer_ReplayHandler(t *testing.T) {
+	store, _ := history.New("", slog.Default())
+
+	gotify := &routingNotifier{fakeNotifier: fakeNotifier{name: "gotify"}, dropSite: "Lab"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{gotify},
+		SharedSecret: "vewySecwet",
+		Logger:       slog.Default(),
+		History:      store,
+	}
+
+	body := `{"Site":"Lab","text":["Something happened."],"Controller":"Controller","shardSecret":"vewySecwet"}`
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	request.Header.Set("Access_token", "vewySecwet")
+	server.ServeHTTP(httptest.NewRecorder(), request)
+
+	gotify.dropSite = ""
+
+	post := func(target, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		server.ReplayHandler().ServeHTTP(response, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		return response
+	}
+
+	var list struct {
+		Count   int             `json:"count"`
+		Changed int             `json:"changed"`
+		Results []replay.Result `json:"results"`
+	}
+
+	response := post("/api/replay?changed=true", "")
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("Expected the results, got %d: %s", response.Code, response.Body)
+	}
+
+	if list.Count != 1 || list.Changed != 1 || list.Results[0].Dropped {
+		t.Errorf("Expected the message from the history, not dropped anymore, got %+v", list)
+	}
+
+	response = post("/api/replay", `{"Site":"Office","text":["Something happened."],"Controller":"Controller"}`)
+	if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil || list.Count != 1 || list.Results[0].Site != "Office" || list.Results[0].Line != 1 {
+		t.Errorf("Expected the result for the payload given, got %d: %s", response.Code, response.Body)
+	}
+
+	if response := post("/api/replay?deliver=maybe", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid flag to be refused, got %d", response.Code)
+	}
+
+	if response := post("/api/replay", "not JSON"); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid body to be refused, got %d", response.Code)
+	}
+}
+
+// EOF
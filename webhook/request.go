@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/metrics"
+	"github.com/leeft/omada-to-gotify/omada"
+)
+
+// The header a request ID is taken from, when the client (or a proxy in
+// front of this program) sends one, and returned in.
+const RequestIDHeader = "X-Request-ID"
+
+// At most this long, and made of letters, digits and a few punctuation
+// characters; anything else is replaced with an ID of our own, so the
+// logs can't be filled with whatever a client likes.
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// The largest body accepted unless configured otherwise; Omada's messages
+// are well under a kilobyte.
+const DefaultMaxBodySize = 64 * 1024
+
+// The names of the fields are only logged: they come from whoever sends the
+// request, and each label value would make a series of its own.
+var unknownFields = metrics.NewCounter("omada_to_gotify_unknown_fields_total",
+	"Fields in messages this program doesn't know about; only counted in strict mode.")
+
+// How many names of unknown fields are remembered, so each is logged only
+// the first time; those after are logged every time they show up.
+const maxSeenFields = 100
+
+func (s Settings) maxBodySize() int64 {
+	if s.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return s.MaxBodySize
+}
+
+// The ID of the request: the one it came with when that will do, or a new one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); requestIDRe.MatchString(id) {
+		return id
+	}
+	return logging.NewRequestID()
+}
+
+// Whether the request says its body is JSON. Not saying anything is fine
+// too, as not every client bothers.
+func isJSON(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// Logs the fields of the message this program doesn't know about, each
+// the first time it shows up (as long as there aren't too many of them),
+// and counts them every time. The message itself is accepted all the same.
+func (ws *WebhookServer) recordUnknownFields(logger *slog.Logger, body []byte) {
+	fields := omada.UnknownFields(body)
+	if len(fields) == 0 {
+		return
+	}
+
+	ws.fieldsMu.Lock()
+	defer ws.fieldsMu.Unlock()
+
+	if ws.seenFields == nil {
+		ws.seenFields = map[string]bool{}
+	}
+
+	for _, field := range fields {
+		unknownFields.Inc()
+
+		if !ws.seenFields[field] {
+			if len(ws.seenFields) < maxSeenFields {
+				ws.seenFields[field] = true
+			}
+			logger.Info("The message has a field this program doesn't know about (only logged the first time)", "field", field)
+		}
+	}
+}
+
+// EOF
@@ -0,0 +1,151 @@
+package webhook_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/webhook"
+)
+
+func TestWebhookServer_Requests(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	)
+
+	gotify := &fakeNotifier{name: "gotify"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{gotify},
+		SharedSecret: "vewySecwet",
+		Logger:       logger,
+		MaxBodySize:  256,
+		Strict:       true,
+	}
+
+	message := `{"Site":"Some site","text":["Something happened."],"Controller":"Controller","timestamp":1758852904877,"siteId":"abc"}`
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		unsized     bool
+		code        int
+	}{
+		{"JSON", http.MethodPost, "application/json; charset=utf-8", message, false, http.StatusOK},
+		{"No content type", http.MethodPost, "", message, false, http.StatusOK},
+		{"Another content type", http.MethodPost, "application/x-www-form-urlencoded", message, false, http.StatusUnsupportedMediaType},
+		{"GET", http.MethodGet, "", "", false, http.StatusMethodNotAllowed},
+		{"PUT", http.MethodPut, "application/json", message, false, http.StatusMethodNotAllowed},
+		{"Too large", http.MethodPost, "application/json", message + strings.Repeat(" ", 256), false, http.StatusRequestEntityTooLarge},
+		{"Too large, without saying so", http.MethodPost, "application/json", message + strings.Repeat(" ", 256), true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			request.Header.Set("Access_token", "vewySecwet")
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.unsized {
+				request.ContentLength = -1
+			}
+
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+
+			if response.Code != tt.code {
+				t.Errorf("Expected status %d, got %d", tt.code, response.Code)
+			}
+
+			if tt.code == http.StatusMethodNotAllowed && response.Header().Get("Allow") != http.MethodPost {
+				t.Errorf("Expected an Allow header, got %q", response.Header().Get("Allow"))
+			}
+		})
+	}
+
+	if gotify.messages != 2 {
+		t.Errorf("Expected the messages with a field more to be delivered, got %d", gotify.messages)
+	}
+
+	if strings.Count(buf.String(), "field=siteId") != 1 {
+		t.Errorf("Expected the unknown field to be logged once, got %q", buf.String())
+	}
+
+	if sample(t, `omada_to_gotify_unknown_fields_total`) != 2 {
+		t.Errorf("Expected the unknown field to be counted twice")
+	}
+}
+
+func TestWebhookServer_RequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.FormatJSON, slog.LevelInfo)
+
+	good, bad := &fakeNotifier{name: "gotify"}, &fakeNotifier{name: "ntfy", fail: errors.New("ntfy is down")}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{good, bad},
+		SharedSecret: "vewySecwet",
+		Logger:       logger,
+	}
+
+	send := func(id string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"Site":"Some site","text":["Something happened."],"Controller":"Controller"}`))
+		request.Header.Set("Access_token", "vewySecwet")
+		if id != "" {
+			request.Header.Set(webhook.RequestIDHeader, id)
+		}
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		return response
+	}
+
+	if id := send("proxy-1234").Header().Get(webhook.RequestIDHeader); id != "proxy-1234" {
+		t.Errorf("Expected the request's own ID to be used, got %q", id)
+	}
+
+	if good.last.RequestID != "proxy-1234" {
+		t.Errorf("Expected the message to carry the request ID, got %q", good.last.RequestID)
+	}
+
+	// Each line is a JSON object, and everything about the request has its ID.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for _, line := range lines {
+		entry := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected JSON, got %q: %v", line, err)
+		}
+
+		if entry[logging.RequestIDKey] != "proxy-1234" {
+			t.Errorf("Expected the request ID in %q", line)
+		}
+
+		if _, ok := entry["payload"]; ok {
+			t.Errorf("Expected the payload to be logged at debug level only, got %q", line)
+		}
+	}
+
+	if !strings.Contains(buf.String(), `"msg":"Error sending the message","request_id":"proxy-1234","notifier":"ntfy"`) {
+		t.Errorf("Expected the failed delivery to be logged with the request ID, got %q", buf.String())
+	}
+
+	for _, id := range []string{"", "not acceptable", strings.Repeat("x", 65)} {
+		got := send(id).Header().Get(webhook.RequestIDHeader)
+		if got == "" || got == id {
+			t.Errorf("Expected a new ID instead of %q, got %q", id, got)
+		}
+	}
+}
+
+// EOF
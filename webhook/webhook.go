@@ -1,60 +1,429 @@
 package webhook
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/history"
+	"github.com/leeft/omada-to-gotify/linkstate"
+	"github.com/leeft/omada-to-gotify/logging"
+	"github.com/leeft/omada-to-gotify/notify"
 	"github.com/leeft/omada-to-gotify/omada"
 )
 
 type WebhookServer struct {
-	GotifyClient        gotify.GotifyClient
-	GotifyClientMessage gotify.GotifyClientMessage
-	SharedSecret        string
-	Logger              *log.Logger
+	// Every message is delivered to each of these.
+	Notifiers []notify.Notifier
+
+	SharedSecret string
+	Logger       *slog.Logger
+
+	// How requests on / are authenticated; when not set, by SharedSecret in
+	// the Access_token header.
+	Auth []Authenticator
+
+	// Further endpoints, each with their own path, secret and notifiers.
+	// Without any, messages are accepted on any path; with them, only on
+	// their paths (and on / when SharedSecret is set).
+	Endpoints []*Endpoint
+
+	// The networks requests on / are accepted from; anywhere when empty.
+	Allow []netip.Prefix
+
+	// The proxies whose X-Forwarded-For header is believed, see clientIP.
+	TrustedProxies []netip.Prefix
+
+	// How many requests each source IP may send, and how many messages
+	// each controller; no limit when not set.
+	IPLimit         RateLimit
+	ControllerLimit RateLimit
+
+	// The largest request body accepted, DefaultMaxBodySize when 0.
+	MaxBodySize int64
+
+	// When set, fields of messages this program doesn't know about are
+	// logged and counted; the messages are accepted all the same.
+	Strict bool
+
+	// The rules each message is classified with. Without any, just the
+	// built-in catalogue is used.
+	Rules *omada.RuleSet
+
+	// When set, messages are handed to this queue and Omada gets its response
+	// right away; the queue takes care of delivering (and retrying) them.
+	Queue *DeliveryQueue
+
+	// When set, every message is kept in the history, with what became of it.
+	History *history.Store
+
+	// When set, online messages are told how long the link was offline.
+	Outages *linkstate.OutageTracker
+
+	// When set, the messages of links that keep going offline and online
+	// again are held back; the detector sends a summary instead.
+	Flaps *linkstate.FlapDetector
+
+	// Guards the fields Settings holds once the server runs.
+	mu sync.RWMutex
+
+	ipLimiter         limiter
+	controllerLimiter limiter
+
+	// The unknown fields logged already, in strict mode.
+	fieldsMu   sync.Mutex
+	seenFields map[string]bool
+}
+
+// The settings which can be changed while the server runs, see Apply.
+type Settings struct {
+	Notifiers       []notify.Notifier
+	SharedSecret    string
+	Auth            []Authenticator
+	Endpoints       []*Endpoint
+	Allow           []netip.Prefix
+	TrustedProxies  []netip.Prefix
+	IPLimit         RateLimit
+	ControllerLimit RateLimit
+	MaxBodySize     int64
+	Strict          bool
+	Rules           *omada.RuleSet
+}
+
+// Apply replaces the notifiers, authentication, endpoints, limits and rules
+// in one go, e.g. after the configuration has been reloaded. Requests
+// already being handled finish with the settings they started out with.
+func (ws *WebhookServer) Apply(s Settings) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.Notifiers = s.Notifiers
+	ws.SharedSecret = s.SharedSecret
+	ws.Auth = s.Auth
+	ws.Endpoints = s.Endpoints
+	ws.Allow = s.Allow
+	ws.TrustedProxies = s.TrustedProxies
+	ws.IPLimit = s.IPLimit
+	ws.ControllerLimit = s.ControllerLimit
+	ws.MaxBodySize = s.MaxBodySize
+	ws.Strict = s.Strict
+	ws.Rules = s.Rules
+}
+
+// The notifiers messages are delivered with right now, those of the
+// endpoints included; e.g. to check on them.
+func (ws *WebhookServer) AllNotifiers() []notify.Notifier {
+	s := ws.current()
+
+	notifiers := append([]notify.Notifier{}, s.Notifiers...)
+	for _, endpoint := range s.Endpoints {
+		notifiers = append(notifiers, endpoint.Notifiers...)
+	}
+
+	return notifiers
+}
+
+// The settings as they are right now.
+func (ws *WebhookServer) current() Settings {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	return Settings{
+		Notifiers:       ws.Notifiers,
+		SharedSecret:    ws.SharedSecret,
+		Auth:            ws.Auth,
+		Endpoints:       ws.Endpoints,
+		Allow:           ws.Allow,
+		TrustedProxies:  ws.TrustedProxies,
+		IPLimit:         ws.IPLimit,
+		ControllerLimit: ws.ControllerLimit,
+		MaxBodySize:     ws.MaxBodySize,
+		Strict:          ws.Strict,
+		Rules:           ws.Rules,
+	}
 }
 
-func (ws *WebhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
+func (ws *WebhookServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	settings := ws.current()
+	endpoint := settings.endpoint(r.URL.Path)
+
+	w := &statusRecorder{ResponseWriter: rw}
+	defer func() { webhooksReceived.Inc(endpointLabel(endpoint), w.status()) }()
+
+	// Everything logged about the request, up to its delivery, carries its ID.
+	id := requestID(r)
+	w.Header().Set(RequestIDHeader, id)
+	logger := logging.WithRequestID(ws.Logger, id)
+
+	if endpoint == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isJSON(r) {
+		http.Error(w, "Unsupported media type, expected application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ip := clientIP(r, settings.TrustedProxies)
+
+	if len(endpoint.Allow) > 0 && !contains(endpoint.Allow, ip) {
+		ws.reject(logger, w, r, ip, errors.New("not from one of the allowed networks"))
+		return
+	}
+
+	if settings.IPLimit.enabled() {
+		label := fmt.Sprintf("source IP %v", ip)
+		if ok, wait := ws.ipLimiter.take(ws.Logger, id, ip.String(), label, settings.IPLimit, time.Now()); !ok {
+			ws.limited(w, "ip", wait)
+			return
+		}
+	}
+
+	auth := endpoint.authenticators()
+
+	// Before the body is read, so most unwanted requests don't get to send it.
+	for _, a := range auth {
+		if err := a.Check(r); err != nil {
+			ws.reject(logger, w, r, ip, err)
+			return
+		}
+	}
+
+	// Checked up front when the client says how large it is, and otherwise
+	// as the body is read.
+	maxBodySize := settings.maxBodySize()
+	if r.ContentLength > maxBodySize {
+		ws.tooLarge(logger, w, r, ip, maxBodySize)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		ws.tooLarge(logger, w, r, ip, maxBodySize)
+		return
+	}
+
 	if err != nil {
-		log.Printf("Error reading request body: %v", err)
+		logger.Error("Error reading the request body", "error", err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
 	defer r.Body.Close()
 
-	if r.Header["Access_token"] == nil || r.Header["Access_token"][0] != ws.SharedSecret {
-		http.Error(w, "Not authorized", http.StatusForbidden)
-		return
+	for _, a := range auth {
+		if err := a.CheckBody(r, body); err != nil {
+			ws.reject(logger, w, r, ip, err)
+			return
+		}
 	}
 
-	omadaMessage, err := omada.ParseOmadaMessage(ws.Logger, body)
+	omadaMessage, err := omada.ParseMessage(logger, body)
 	if err != nil || omadaMessage == nil {
-		ws.Logger.Printf("Error parsing Omada notification message: %v", err)
+		logger.Error("Error parsing Omada notification message", "error", err)
+		parseFailures.Inc()
 		http.Error(w, "Internal message parsing error", http.StatusInternalServerError)
 		return
 	}
 
-	// This code sets up a new client as per the example of the gotify supplied go client;
-	// it does so every time it forwards a webhook. I'm new to Go and new to how the
-	// lifetime of these variables affects network connections, I can't find a "Close"
-	// method for it plus it would seem the notifications are sent sporadically anyway
-	// so this should be okay.
+	if settings.Strict {
+		ws.recordUnknownFields(logger, body)
+	}
+
+	// Not part of the payload (see omada.MessageRecord), so always set here.
+	omadaMessage.Endpoint = endpoint.Name
+	omadaMessage.RequestID = id
+	if omadaMessage.Site == "" {
+		omadaMessage.Site = endpoint.Site
+	}
 
-	err = ws.GotifyClient.Send(ws.GotifyClientMessage, omadaMessage)
+	// Once, with the rules of the settings the request is handled with; a
+	// reload halfway through doesn't change what the message is.
+	omadaMessage.Classify(settings.Rules)
 
-	if err != nil {
-		ws.Logger.Printf("Error sending message to Gotify: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// Once it's known which controller sent the message, which is after
+	// parsing it; the same name on another endpoint is another controller.
+	if settings.ControllerLimit.enabled() {
+		key := omadaMessage.Endpoint + "\x00" + omadaMessage.Controller
+		label := fmt.Sprintf("controller %q", omadaMessage.Controller)
+		if endpoint.Name != "" {
+			label += fmt.Sprintf(" on endpoint %v", endpoint.Name)
+		}
+
+		if ok, wait := ws.controllerLimiter.take(ws.Logger, id, key, label, settings.ControllerLimit, time.Now()); !ok {
+			ws.limited(w, "controller", wait)
+			return
+		}
+	}
+
+	countMessage(omadaMessage)
+
+	if ws.Outages != nil {
+		ws.Outages.Observe(omadaMessage)
+	}
+
+	heldBack := ws.Flaps != nil && !ws.Flaps.Observe(omadaMessage)
+	ws.History.Add(omadaMessage, body)
+
+	if heldBack {
+		logger.Info("Holding back the message, its link is flapping")
+		for _, notifier := range endpoint.Notifiers {
+			ws.recordDelivery(omadaMessage, notifier, history.ResultHeldBack, nil)
+		}
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	// Omada retries the whole message, so it is only told of a failure when
+	// no notifier got the message; those which did would get it again.
+	if delivered, err := ws.deliver(r.Context(), omadaMessage, endpoint.Notifiers); err != nil {
+		if delivered == 0 {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Warn("Not every notifier got the message, it won't be sent to them again", "delivered", delivered, "notifiers", len(endpoint.Notifiers))
+	}
+
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "") // or something like: "Webhook forwarded successfully" (Omada doesn't care though)
 }
 
+func (ws *WebhookServer) reject(logger *slog.Logger, w http.ResponseWriter, r *http.Request, ip netip.Addr, err error) {
+	logger.Warn("Rejected a request", "path", r.URL.Path, "client", ip.String(), "reason", err.Error())
+	http.Error(w, "Not authorized", http.StatusForbidden)
+}
+
+func (ws *WebhookServer) tooLarge(logger *slog.Logger, w http.ResponseWriter, r *http.Request, ip netip.Addr, maxBodySize int64) {
+	logger.Warn("Rejected a request", "path", r.URL.Path, "client", ip.String(), "reason", fmt.Sprintf("the body is larger than %d bytes", maxBodySize))
+	http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+}
+
+// Turns the request away for going over the limit; the limiter logs it.
+func (ws *WebhookServer) limited(w http.ResponseWriter, limit string, wait time.Duration) {
+	rateLimited.Inc(limit)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+// Deliver hands the message to the queue when there is one, or otherwise
+// sends it to each of the notifiers of its endpoint straight away. It is
+// for messages made up by this program, which are added to the history.
+func (ws *WebhookServer) Deliver(ctx context.Context, omadaMessage *omada.OmadaMessage) error {
+	settings := ws.current()
+	omadaMessage.Classify(settings.Rules)
+
+	ws.History.Add(omadaMessage, nil)
+
+	notifiers, ok := settings.notifiers(omadaMessage.Endpoint)
+	if !ok {
+		logger := logging.WithRequestID(ws.Logger, omadaMessage.RequestID)
+		logger.Warn("Dropping the message, there is no such endpoint (anymore)", "endpoint", omadaMessage.Endpoint)
+		return nil
+	}
+
+	_, err := ws.deliver(ctx, omadaMessage, notifiers)
+	return err
+}
+
+// Delivers to the given notifiers, those of the endpoint the message came in
+// on. Returns how many of them got the message (or have it queued), along
+// with the errors of those which didn't.
+func (ws *WebhookServer) deliver(ctx context.Context, omadaMessage *omada.OmadaMessage, notifiers []notify.Notifier) (int, error) {
+	logger := logging.WithRequestID(ws.Logger, omadaMessage.RequestID)
+	errs := []error{}
+	delivered := 0
+
+	for _, notifier := range notifiers {
+		if ws.Queue != nil {
+			err := ws.Queue.Enqueue(notifier.Name(), omadaMessage)
+			if err != nil {
+				logger.Error("Error queueing the message for delivery", "notifier", notifier.Name(), "error", err)
+				errs = append(errs, err)
+			} else {
+				delivered++
+			}
+			ws.recordDelivery(omadaMessage, notifier, history.ResultQueued, err)
+			continue
+		}
+
+		err := notifyWithMetrics(ctx, notifier, omadaMessage)
+		if err != nil {
+			logger.Error("Error sending the message", "notifier", notifier.Name(), "error", err)
+			errs = append(errs, err)
+		} else {
+			delivered++
+		}
+		ws.recordDelivery(omadaMessage, notifier, outcome(err), err)
+	}
+
+	return delivered, errors.Join(errs...)
+}
+
+// DeliverTo sends the message to the named notifier of its endpoint; this
+// is the function the queue uses to deliver its messages. The message is
+// classified with the rules in use at the time, as it is routed with the
+// routes in use at the time.
+func (ws *WebhookServer) DeliverTo(ctx context.Context, target string, omadaMessage *omada.OmadaMessage) error {
+	settings := ws.current()
+	omadaMessage.Classify(settings.Rules)
+
+	if notifier, ok := settings.notifier(omadaMessage.Endpoint, target); ok {
+		err := notifyWithMetrics(ctx, notifier, omadaMessage)
+		ws.recordDelivery(omadaMessage, notifier, outcome(err), err)
+		return err
+	}
+
+	// Retrying won't make the notifier appear, so the message is dropped.
+	logger := logging.WithRequestID(ws.Logger, omadaMessage.RequestID)
+	logger.Warn("Dropping the message, there is no such notifier (anymore)", "notifier", target)
+	return nil
+}
+
+// The endpoint for the path of a request, nil when there is none.
+func (s Settings) endpoint(path string) *Endpoint {
+	for _, endpoint := range s.Endpoints {
+		if endpoint.Path == path {
+			return endpoint
+		}
+	}
+
+	if len(s.Endpoints) > 0 && (path != "/" || s.SharedSecret == "") {
+		return nil
+	}
+
+	return &Endpoint{SharedSecret: s.SharedSecret, Auth: s.Auth, Allow: s.Allow, Notifiers: s.Notifiers}
+}
+
+// The notifiers of the named endpoint; the empty name is the default one.
+func (s Settings) notifiers(name string) ([]notify.Notifier, bool) {
+	if name == "" {
+		return s.Notifiers, true
+	}
+
+	for _, endpoint := range s.Endpoints {
+		if endpoint.Name == name {
+			return endpoint.Notifiers, true
+		}
+	}
+
+	return nil, false
+}
+
 // EOF
@@ -2,16 +2,21 @@ package webhook_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/go-openapi/runtime"
 	"github.com/gotify/go-api-client/v2/client/message"
 	"github.com/leeft/omada-to-gotify/gotify"
+	"github.com/leeft/omada-to-gotify/metrics"
+	"github.com/leeft/omada-to-gotify/notify"
+	"github.com/leeft/omada-to-gotify/omada"
 	"github.com/leeft/omada-to-gotify/webhook"
 )
 
@@ -39,10 +44,7 @@ func (errReader) Read(p []byte) (n int, err error) {
 
 func TestWebhookServer(t *testing.T) {
 
-	var (
-		buf    bytes.Buffer
-		logger = log.New(&buf, "logger: ", log.Lshortfile)
-	)
+	logger := testLogger(t)
 
 	const sharedSecret = "vewySecwet"
 	const someToken = "someAppToken"
@@ -56,10 +58,9 @@ func TestWebhookServer(t *testing.T) {
 	mock := &GotifyClientMessageMock{}
 
 	server := &webhook.WebhookServer{
-		GotifyClient:        gotifyClient,
-		GotifyClientMessage: mock,
-		SharedSecret:        sharedSecret,
-		Logger:              logger,
+		Notifiers:    []notify.Notifier{&gotify.Notifier{Client: gotifyClient, Message: mock}},
+		SharedSecret: sharedSecret,
+		Logger:       logger,
 	}
 
 	notAuthorizedTests := []struct {
@@ -248,3 +249,260 @@ func TestWebhookServer(t *testing.T) {
 		}
 	})
 }
+
+// A notifier which remembers what it was sent, and fails when told to.
+type fakeNotifier struct {
+	name     string
+	fail     error
+	messages int
+	last     *omada.OmadaMessage
+}
+
+func (f *fakeNotifier) Name() string { return f.name }
+
+func (f *fakeNotifier) Notify(ctx context.Context, msg *omada.OmadaMessage) error {
+	f.messages++
+	f.last = msg
+	return f.fail
+}
+
+func TestWebhookServer_Notifiers(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	)
+
+	ntfy := &fakeNotifier{name: "ntfy", fail: errors.New("ntfy is down")}
+	matrix := &fakeNotifier{name: "matrix"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{ntfy, matrix},
+		SharedSecret: "vewySecwet",
+		Logger:       logger,
+	}
+
+	msg := &omada.OmadaMessage{Site: "Some site"}
+
+	// A failing notifier doesn't stop the others from getting the message.
+	if err := server.Deliver(context.Background(), msg); err == nil {
+		t.Errorf("Expected the error of the failing notifier")
+	}
+
+	if ntfy.messages != 1 || matrix.messages != 1 {
+		t.Errorf("Expected both notifiers to be sent the message, got %d and %d", ntfy.messages, matrix.messages)
+	}
+
+	if err := server.DeliverTo(context.Background(), "matrix", msg); err != nil {
+		t.Errorf("DeliverTo() failed: %v", err)
+	}
+
+	if ntfy.messages != 1 || matrix.messages != 2 {
+		t.Errorf("Expected only matrix to be sent the message, got %d and %d", ntfy.messages, matrix.messages)
+	}
+
+	if err := server.DeliverTo(context.Background(), "pager", msg); err != nil {
+		t.Errorf("Expected a message for an unknown notifier to be dropped, got %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "notifier=pager") {
+		t.Errorf("Expected the dropped message to be logged, got %q", buf.String())
+	}
+}
+
+// Only this program knows about outages (and history IDs); a payload can't
+// make them up.
+func TestWebhookServer_SpoofedFields(t *testing.T) {
+	gotify := &fakeNotifier{name: "gotify"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{gotify},
+		SharedSecret: "vewySecwet",
+		Logger:       slog.New(slog.DiscardHandler),
+	}
+
+	json := `{"text":["[gateway:98-03-8E-3A-8D-53]: The online detection result of [2.5G WAN1] was online."],"Controller":"Controller","Site":"Home",` +
+		`"outage":{"start":"2000-01-01T00:00:00Z","end":"2025-01-01T00:00:00Z"},"id":"forged","request_id":"forged"}`
+
+	request, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(json))
+	request.Header.Set("Access_token", "vewySecwet")
+
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, response.Code)
+	}
+
+	msg := gotify.last
+	if msg.Outage != nil || msg.ID == "forged" || msg.RequestID == "forged" {
+		t.Errorf("The payload set fields Omada doesn't send: %+v", msg)
+	}
+
+	if body := msg.Body(); strings.Contains(body, "Outage lasted") {
+		t.Errorf("The body mentions an outage which never was: %q", body)
+	}
+}
+
+// Without a queue, Omada's retries go to every notifier; so it's only told
+// of a failure when none of them got the message.
+func TestWebhookServer_PartialFailure(t *testing.T) {
+	ntfy := &fakeNotifier{name: "ntfy", fail: errors.New("ntfy is down")}
+	matrix := &fakeNotifier{name: "matrix"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{ntfy, matrix},
+		SharedSecret: "vewySecwet",
+		Logger:       slog.New(slog.DiscardHandler),
+	}
+
+	send := func() int {
+		request, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(`{"Site":"Some site","text":["Something happened."]}`))
+		request.Header.Set("Access_token", "vewySecwet")
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	if code := send(); code != http.StatusOK {
+		t.Errorf("Expected status code %d when one notifier got the message, got %d", http.StatusOK, code)
+	}
+
+	if ntfy.messages != 1 || matrix.messages != 1 {
+		t.Errorf("Expected both notifiers to be sent the message, got %d and %d", ntfy.messages, matrix.messages)
+	}
+
+	matrix.fail = errors.New("matrix is down")
+
+	if code := send(); code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d when no notifier got the message, got %d", http.StatusInternalServerError, code)
+	}
+}
+
+func TestWebhookServer_Apply(t *testing.T) {
+	logger := testLogger(t)
+
+	before, after := &fakeNotifier{name: "gotify"}, &fakeNotifier{name: "gotify"}
+
+	server := &webhook.WebhookServer{
+		Notifiers:    []notify.Notifier{before},
+		SharedSecret: "before",
+		Logger:       logger,
+	}
+
+	send := func(secret string) int {
+		json := `{"Site":"Some site","text":["Something happened."],"Controller":"Controller","timestamp":1758852904877}`
+		request, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(json))
+		request.Header.Set("Access_token", secret)
+
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	if code := send("before"); code != http.StatusOK || before.messages != 1 {
+		t.Fatalf("Expected the message to be delivered before the change, got %d", code)
+	}
+
+	rules, err := omada.ParseRules([]byte("rules:\n  - match:\n      text: Something happened\n    priority: 1\n    title: Reclassified\n"))
+	if err != nil {
+		t.Fatalf("ParseRules() failed: %v", err)
+	}
+
+	server.Apply(webhook.Settings{Notifiers: []notify.Notifier{after}, SharedSecret: "after", Rules: rules})
+
+	if code := send("before"); code != http.StatusForbidden {
+		t.Errorf("Expected the old secret to be refused, got %d", code)
+	}
+
+	if code := send("after"); code != http.StatusOK || after.messages != 1 || before.messages != 1 {
+		t.Errorf("Expected the message to go to the new notifier only, got %d", code)
+	}
+
+	// Classified with the rules of the settings, rather than those active.
+	if msg := after.last; msg.Priority() != 1 || msg.Title() != "Reclassified" {
+		t.Errorf("Expected the message to be classified with the new rules, got priority %d and title %q", msg.Priority(), msg.Title())
+	}
+
+	if omada.ActiveRules() != nil {
+		t.Error("Applying the settings changed the active rules")
+	}
+}
+
+// The value of one series of the metrics, 0 when it isn't there (yet).
+func sample(t *testing.T, series string) float64 {
+	var buf bytes.Buffer
+	metrics.Default.WriteText(&buf)
+
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("Invalid value in %q: %v", line, err)
+			}
+			return v
+		}
+	}
+
+	return 0
+}
+
+func TestWebhookServer_Metrics(t *testing.T) {
+	logger := testLogger(t)
+
+	good, bad := &fakeNotifier{name: "metrics-good"}, &fakeNotifier{name: "metrics-bad", fail: errors.New("down")}
+
+	server := &webhook.WebhookServer{
+		Logger: logger,
+		Endpoints: []*webhook.Endpoint{
+			{Name: "metrics", Path: "/hook/metrics", SharedSecret: "vewySecwet", Notifiers: []notify.Notifier{good, bad}},
+		},
+	}
+
+	send := func(path, secret, body string) {
+		request, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		request.Header.Set("Access_token", secret)
+		server.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	json := `{"Site":"Some site","text":["Something happened."],"Controller":"Controller","timestamp":1758852904877}`
+	msg, _ := omada.ParseMessage(logger, []byte(json))
+	byType := `omada_to_gotify_messages_total{type="` + msg.TypeName() + `",priority="` + strconv.Itoa(msg.Priority()) + `"}`
+
+	messagesBefore := sample(t, byType)
+	parseFailuresBefore := sample(t, "omada_to_gotify_parse_failures_total")
+	notFoundBefore := sample(t, `omada_to_gotify_webhooks_received_total{endpoint="none",code="404"}`)
+
+	send("/hook/metrics", "vewySecwet", json)
+	send("/hook/metrics", "vewySecwet", json)
+	send("/hook/metrics", "wrong", json)
+	send("/hook/metrics", "vewySecwet", "not json")
+	send("/hook/elsewhere", "vewySecwet", json)
+
+	tests := []struct {
+		series string
+		want   float64
+	}{
+		{`omada_to_gotify_webhooks_received_total{endpoint="metrics",code="200"}`, 2},
+		{`omada_to_gotify_webhooks_received_total{endpoint="metrics",code="500"}`, 1},
+		{`omada_to_gotify_webhooks_received_total{endpoint="metrics",code="403"}`, 1},
+		{`omada_to_gotify_webhooks_received_total{endpoint="none",code="404"}`, notFoundBefore + 1},
+		{"omada_to_gotify_parse_failures_total", parseFailuresBefore + 1},
+		{byType, messagesBefore + 2},
+		{`omada_to_gotify_notifications_total{notifier="metrics-good",result="success"}`, 2},
+		{`omada_to_gotify_notifications_total{notifier="metrics-bad",result="failure"}`, 2},
+		{`omada_to_gotify_notification_duration_seconds_count{notifier="metrics-good"}`, 2},
+	}
+
+	for _, tt := range tests {
+		if got := sample(t, tt.series); got != tt.want {
+			t.Errorf("Expected %v for %v, got %v", tt.want, tt.series, got)
+		}
+	}
+}
+
+// A logger for the tests, writing to the output of the test so the log
+// shows up along with any failures (and with -v).
+func testLogger(t *testing.T) *slog.Logger {
+	return slog.New(slog.NewTextHandler(t.Output(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+}